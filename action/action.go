@@ -0,0 +1,217 @@
+// Package action provides functions for evaluating capture trees into Go
+// values. An action is registered for each capture ID, and the value of a
+// capture is computed by its action from the text it matched and the values of
+// its children. The actions provided here are modeled after LPeg's captures.
+// A function can also be run while parsing with MatchTime, to accept or reject
+// a match.
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+)
+
+// A Node is a capture that is being evaluated.
+type Node struct {
+	// Capture is the capture being evaluated.
+	Capture *memo.Capture
+	// Text is the subject text matched by the capture.
+	Text []byte
+	// Values are the values of the capture's children, in order.
+	Values []interface{}
+}
+
+// An Action computes the value of a capture. If an error is returned,
+// evaluation stops and the error is returned by Eval.
+type Action func(n *Node) (interface{}, error)
+
+// Actions maps capture IDs to the actions that compute their values.
+// Captures without an action evaluate to the text they matched.
+type Actions map[int]Action
+
+// An EvalError is returned when an action fails.
+type EvalError struct {
+	Id  int
+	Pos int
+	Err error
+}
+
+// Error returns the error message.
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%d: capture %d: %v", e.Pos, e.Id, e.Err)
+}
+
+// Unwrap returns the error returned by the action.
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// Eval evaluates the capture tree root, as returned by vm.Code.Exec, over
+// the subject r. It returns the values of the top-level captures.
+func (a Actions) Eval(root *memo.Capture, r io.ReaderAt) ([]interface{}, error) {
+	if root == nil {
+		return nil, nil
+	}
+	if !root.Dummy() {
+		v, err := a.eval(root, r)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{v}, nil
+	}
+	return a.children(root, r)
+}
+
+func (a Actions) children(c *memo.Capture, r io.ReaderAt) ([]interface{}, error) {
+	var values []interface{}
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		v, err := a.eval(ch, r)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (a Actions) eval(c *memo.Capture, r io.ReaderAt) (interface{}, error) {
+	text := input.Slice(r, c.Start(), c.End())
+	act, ok := a[c.Id()]
+	if !ok {
+		return string(text), nil
+	}
+
+	values, err := a.children(c, r)
+	if err != nil {
+		return nil, err
+	}
+	v, err := act(&Node{
+		Capture: c,
+		Text:    text,
+		Values:  values,
+	})
+	if err != nil {
+		if _, ok := err.(*EvalError); ok {
+			return nil, err
+		}
+		return nil, &EvalError{
+			Id:  c.Id(),
+			Pos: c.Start(),
+			Err: err,
+		}
+	}
+	return v, nil
+}
+
+// String evaluates to the text matched by the capture (LPeg's C).
+func String() Action {
+	return func(n *Node) (interface{}, error) {
+		return string(n.Text), nil
+	}
+}
+
+// Const evaluates to v (LPeg's Cc).
+func Const(v interface{}) Action {
+	return func(n *Node) (interface{}, error) {
+		return v, nil
+	}
+}
+
+// Position evaluates to the subject position where the capture starts (LPeg's
+// Cp).
+func Position() Action {
+	return func(n *Node) (interface{}, error) {
+		return n.Capture.Start(), nil
+	}
+}
+
+// List evaluates to a []interface{} containing the values of the capture's
+// children (LPeg's Ct).
+func List() Action {
+	return func(n *Node) (interface{}, error) {
+		values := make([]interface{}, len(n.Values))
+		copy(values, n.Values)
+		return values, nil
+	}
+}
+
+// Fold evaluates to the result of folding f over the values of the capture's
+// children from left to right, starting with the first value (LPeg's Cf). The
+// capture must have at least one child.
+func Fold(f func(acc, v interface{}) interface{}) Action {
+	return func(n *Node) (interface{}, error) {
+		if len(n.Values) == 0 {
+			return nil, fmt.Errorf("fold requires at least one value")
+		}
+		acc := n.Values[0]
+		for _, v := range n.Values[1:] {
+			acc = f(acc, v)
+		}
+		return acc, nil
+	}
+}
+
+// Substitute evaluates to the text matched by the capture, with the text of
+// each child replaced by the child's value (LPeg's Cs). Values that are not
+// strings are formatted with fmt.Sprint.
+func Substitute() Action {
+	return func(n *Node) (interface{}, error) {
+		buf := &bytes.Buffer{}
+		start := n.Capture.Start()
+		last := start
+		i := 0
+		it := n.Capture.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			buf.Write(n.Text[last-start : ch.Start()-start])
+			if s, ok := n.Values[i].(string); ok {
+				buf.WriteString(s)
+			} else {
+				fmt.Fprint(buf, n.Values[i])
+			}
+			last = ch.End()
+			i++
+		}
+		buf.Write(n.Text[last-start:])
+		return buf.String(), nil
+	}
+}
+
+// Func evaluates to the result of calling f with the text matched by the
+// capture and the values of its children (LPeg's function capture). An error
+// returned by f aborts the evaluation. Note that actions run after matching;
+// to reject a match while parsing, use MatchTime.
+func Func(f func(text []byte, values []interface{}) (interface{}, error)) Action {
+	return func(n *Node) (interface{}, error) {
+		return f(n.Text, n.Values)
+	}
+}
+
+// MatchTime returns a pattern that matches p and then calls f while parsing,
+// with the text matched by p and the subject position where it starts
+// (LPeg's Cmt). If f returns false, the pattern fails as if p had failed.
+// Otherwise the pattern succeeds, and also consumes the next n bytes of the
+// subject. Since f is called by the parser, it may be called for matches that
+// are later backtracked over, and not be called again for matches that are
+// memoized, so it should only depend on its arguments.
+func MatchTime(p pattern.Pattern, f func(text []byte, pos int) (n int, ok bool)) pattern.Pattern {
+	return pattern.Check(p, &matchTime{f: f})
+}
+
+// A matchTime is a checker that calls a match-time function.
+type matchTime struct {
+	f func(text []byte, pos int) (int, bool)
+}
+
+func (m *matchTime) Check(b []byte, src *input.Input, id, flag int) int {
+	n, ok := m.f(b, src.Pos()-len(b))
+	if !ok {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,143 @@
+package gpeg
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/action"
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func evalActions(p Pattern, in string, acts action.Actions) ([]interface{}, error) {
+	code := vm.Encode(MustCompile(p))
+	r := strings.NewReader(in)
+	match, _, ast, _, _ := code.Exec(r, memo.NoneTable{})
+	if !match {
+		return nil, errors.New("no match")
+	}
+	return acts.Eval(ast, r)
+}
+
+func TestActions(t *testing.T) {
+	const (
+		num = iota
+		sum
+		list
+		pos
+		word
+		subst
+		yes
+	)
+
+	digits := Plus(Set(charset.Range('0', '9')))
+	number := Cap(digits, num)
+	acts := action.Actions{
+		num: action.Func(func(text []byte, values []interface{}) (interface{}, error) {
+			return strconv.Atoi(string(text))
+		}),
+		sum: action.Fold(func(acc, v interface{}) interface{} {
+			return acc.(int) + v.(int)
+		}),
+		list:  action.List(),
+		pos:   action.Position(),
+		subst: action.Substitute(),
+		yes:   action.Const(true),
+	}
+
+	// fold
+	p := Cap(Concat(number, Star(Concat(Literal("+"), number))), sum)
+	vals, err := evalActions(p, "1+22+333", acts)
+	if err != nil || len(vals) != 1 || vals[0] != 356 {
+		t.Errorf("fold: got %v, %v", vals, err)
+	}
+
+	// list, position and constant
+	p = Cap(Star(Concat(Cap(Literal(""), pos), Or(number, Cap(Literal("y"), yes)), Optional(Literal(",")))), list)
+	vals, err = evalActions(p, "12,y,3", acts)
+	expect := []interface{}{0, 12, 3, true, 5, 3}
+	if err != nil || len(vals) != 1 {
+		t.Fatalf("list: got %v, %v", vals, err)
+	}
+	l := vals[0].([]interface{})
+	if len(l) != len(expect) {
+		t.Fatalf("list: got %v, expected %v", l, expect)
+	}
+	for i := range l {
+		if l[i] != expect[i] {
+			t.Errorf("list: got %v, expected %v", l, expect)
+			break
+		}
+	}
+
+	// substitution, with an uncaptured value and a nested action
+	p = Cap(Star(Or(Cap(Literal("cat"), word), Cap(digits, yes), Any(1))), subst)
+	vals, err = evalActions(p, "a cat has 4 legs", action.Actions{
+		word:  action.Const("dog"),
+		subst: acts[subst],
+		yes:   action.Const(true),
+	})
+	if err != nil || len(vals) != 1 || vals[0] != "a dog has true legs" {
+		t.Errorf("substitute: got %v, %v", vals, err)
+	}
+
+	// captures without an action evaluate to their text
+	p = Star(Concat(Cap(Plus(Set(charset.Range('a', 'z'))), word), Optional(Literal(" "))))
+	vals, err = evalActions(p, "ab cd", action.Actions{})
+	if err != nil || len(vals) != 2 || vals[0] != "ab" || vals[1] != "cd" {
+		t.Errorf("default: got %v, %v", vals, err)
+	}
+
+	// errors abort the evaluation
+	p = Cap(Concat(number, Star(Concat(Literal("+"), number))), sum)
+	_, err = evalActions(p, "1+99999999999999999999999", acts)
+	var eerr *action.EvalError
+	if !errors.As(err, &eerr) || eerr.Id != num || eerr.Pos != 2 {
+		t.Errorf("expected an evaluation error, got %v", err)
+	}
+}
+
+func TestMatchTime(t *testing.T) {
+	// a byte is a number below 256, and the next alternative is tried for
+	// larger numbers.
+	digits := Plus(Set(charset.Range('0', '9')))
+	byteNum := action.MatchTime(digits, func(text []byte, pos int) (int, bool) {
+		n, err := strconv.Atoi(string(text))
+		return 0, err == nil && n < 256
+	})
+	p := Concat(Or(Cap(byteNum, 1), Cap(digits, 2)), Not(Any(1)))
+	acts := action.Actions{
+		1: action.Const("byte"),
+		2: action.Const("int"),
+	}
+	for _, tt := range []struct{ in, want string }{
+		{"255", "byte"},
+		{"256", "int"},
+	} {
+		vals, err := evalActions(p, tt.in, acts)
+		if err != nil || len(vals) != 1 || vals[0] != tt.want {
+			t.Errorf("%s: got %v, %v", tt.in, vals, err)
+		}
+	}
+
+	// a length-prefixed string consumes the number of bytes given by its
+	// prefix.
+	var positions []int
+	str := action.MatchTime(Concat(digits, Literal(":")), func(text []byte, pos int) (int, bool) {
+		positions = append(positions, pos)
+		n, err := strconv.Atoi(string(text[:len(text)-1]))
+		return n, err == nil
+	})
+	p = Star(Cap(str, 0))
+	vals, err := evalActions(p, "3:abc10:0123456789", action.Actions{})
+	if err != nil || len(vals) != 2 || vals[0] != "3:abc" || vals[1] != "10:0123456789" {
+		t.Errorf("length-prefixed: got %v, %v", vals, err)
+	}
+	if len(positions) != 2 || positions[1] != 5 {
+		t.Errorf("positions: got %v", positions)
+	}
+}
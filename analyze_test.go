@@ -0,0 +1,88 @@
+package gpeg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zyedidia/gpeg/charset"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+)
+
+func TestNullable(t *testing.T) {
+	tests := []struct {
+		patt     Pattern
+		nullable bool
+	}{
+		{Literal("a"), false},
+		{Literal(""), true},
+		{Star(Literal("a")), true},
+		{Plus(Literal("a")), false},
+		{Concat(Optional(Literal("a")), Not(Literal("b"))), true},
+		{Or(Literal("a"), Set(charset.Range('0', '9'))), false},
+		{RepeatRange(Literal("a"), 0, 2), true},
+		{Grammar("A", map[string]Pattern{
+			"A": Concat(NonTerm("B"), NonTerm("B")),
+			"B": Optional(Literal("b")),
+		}), true},
+	}
+	for i, tt := range tests {
+		if got := Nullable(tt.patt); got != tt.nullable {
+			t.Errorf("%d: %s: got %v, expected %v", i, Prettify(tt.patt), got, tt.nullable)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		peg   string
+		diags []Diagnostic
+	}{
+		{`S <- 'a'*`, nil},
+		{`S <- ('a'? / !'b')* 'c'`, []Diagnostic{
+			{Kind: InfiniteLoop, Rule: "S", Start: 5, End: 19},
+		}},
+		{`S <- A+ A <- 'a' / B B <- ''`, []Diagnostic{
+			{Kind: InfiniteLoop, Rule: "S", Start: 5, End: 7},
+		}},
+		{`S <- ('a' A){2,} A <- ''`, nil},
+		{`S <- A ('+' A)* A <- [0-9]+ / Missing`, []Diagnostic{
+			{Kind: UndefinedRule, Rule: "A", Name: "Missing", Start: 30, End: 37},
+		}},
+		{`E <- E '+' N / N N <- [0-9]+ Unused <- 'x'`, []Diagnostic{
+			{Kind: LeftRecursion, Rule: "E", Start: 5, End: 16},
+			{Kind: UnreachableRule, Rule: "Unused", Start: 39, End: 42},
+		}},
+		{`A <- B 'a' / 'x' B <- A 'b' / 'y'`, []Diagnostic{
+			{Kind: LeftRecursion, Rule: "A", Start: 5, End: 16},
+			{Kind: LeftRecursion, Rule: "B", Start: 22, End: 33},
+		}},
+	}
+	for _, tt := range tests {
+		p, err := re.Compile(tt.peg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Analyze(p); !reflect.DeepEqual(got, tt.diags) {
+			t.Errorf("%q: got %#v, expected %#v", tt.peg, got, tt.diags)
+		}
+	}
+
+	// patterns without source locations and nested grammars.
+	p := Concat(
+		Star(Star(Literal("a"))),
+		Grammar("X", map[string]Pattern{
+			"X": NonTerm("Y"),
+		}),
+	)
+	want := []Diagnostic{
+		{Kind: InfiniteLoop, Start: -1, End: -1},
+		{Kind: UndefinedRule, Rule: "X", Name: "Y", Start: -1, End: -1},
+	}
+	if got := Analyze(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, expected %#v", got, want)
+	}
+	if got, want := want[1].Error(), "rule X: undefined rule Y"; got != want {
+		t.Errorf("got message %q, expected %q", got, want)
+	}
+}
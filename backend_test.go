@@ -0,0 +1,78 @@
+package gpeg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/bench"
+	"github.com/zyedidia/gpeg/input/linerope"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// Returns the results of an execution as a string, including the structure
+// of the capture tree.
+func result(match bool, n int, capt *memo.Capture, errs []vm.ParseError, err error) string {
+	var b strings.Builder
+	var dump func(c *memo.Capture)
+	dump = func(c *memo.Capture) {
+		fmt.Fprintf(&b, "(%d %d %d", c.Id(), c.Start(), c.End())
+		it := c.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			dump(ch)
+		}
+		b.WriteString(")")
+	}
+	fmt.Fprintf(&b, "%t %d %v %v ", match, n, errs, err)
+	if capt != nil {
+		dump(capt)
+	}
+	return b.String()
+}
+
+// The closure backend must give the same results as the interpreter when
+// reparsing incrementally.
+func TestClosureBackend(t *testing.T) {
+	rand.Seed(42)
+
+	peg, err := ioutil.ReadFile("grammars/java_memo.peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	java, err := ioutil.ReadFile("testdata/test.java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := pattern.MustCompile(re.MustCompileCap(string(peg), make(map[string]int)))
+	interp := vm.Encode(prog)
+	closures := vm.Encode(prog)
+	closures.SetBackend(vm.Closures)
+	if interp.Backend() != vm.Interpreter || closures.Backend() != vm.Closures {
+		t.Fatal("backend not selected")
+	}
+
+	itbl, ctbl := memo.NewTreeTable(0), memo.NewTreeTable(0)
+	r := linerope.New(java)
+	edits := append([]bench.Edit{{}}, bench.ToSingleEdits(bench.GenerateEdits(java, 20))...)
+	for i, e := range edits {
+		r.Remove(e.Start, e.End)
+		r.Insert(e.Start, e.Text)
+		edit := memo.Edit{Start: e.Start, End: e.End, Len: len(e.Text)}
+		itbl.ApplyEdit(edit)
+		ctbl.ApplyEdit(edit)
+
+		want := result(interp.Exec(r, itbl))
+		got := result(closures.Exec(r, ctbl))
+		if got != want {
+			t.Fatalf("edit %d: closures: %.100s, interpreter: %.100s", i, got, want)
+		}
+	}
+	if itbl.Size() != ctbl.Size() {
+		t.Errorf("memo tables differ: %d, %d entries", ctbl.Size(), itbl.Size())
+	}
+}
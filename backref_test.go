@@ -0,0 +1,144 @@
+package gpeg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// Elements whose closing tag must match the opening tag. The scope hides the
+// tags of the children from the closing tag.
+const xml = `
+	Elem <- '<' %tag:Name '>' %( {{Elem}}* ) '</' =tag '>'
+	Name <- [a-z]+
+`
+
+func TestReBackReference(t *testing.T) {
+	tests := []struct {
+		patt  string
+		tests []PatternTest
+	}{
+		{`'<<' %delim:[A-Z]+ '\n' (!('\n' =delim) .)* '\n' =delim`, []PatternTest{
+			{"<<EOF\nhello\nEOF", 15},
+			{"<<EOF\nEOT\nEOF", 13},
+			{"<<EOF\nhello\nEOT", -1},
+		}},
+		// definitions are undone by backtracking.
+		{`(%a:'x' 'y' / 'x') =a`, []PatternTest{{"xyx", 3}, {"xx", -1}}},
+		{`%a:'x' %a:'y' =a`, []PatternTest{{"xyy", 3}, {"xyx", -1}}},
+		{`%a:'' 'x' =a`, []PatternTest{{"x", 1}}},
+		{xml, []PatternTest{
+			{"<a></a>", 7},
+			{"<a><b></b><c></c></a>", 21},
+			{"<a><b></a></b>", -1},
+			{"<a><b></b></b>", -1},
+		}},
+		// symbols defined in a scope are not visible after it.
+		{`%a:'x' %(%a:'y') =a`, []PatternTest{{"xyx", 3}, {"xyy", -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patt, func(t *testing.T) {
+			check(re.MustCompile(tt.patt), tt.tests, t)
+		})
+	}
+
+	// symbols defined inside captures are visible.
+	check(re.MustCompileCap(`
+		S     <- Digit ',' =a
+		Digit <- %a:[0-9]
+	`, nil), []PatternTest{{"1,1", 3}, {"1,2", -1}}, t)
+}
+
+// Symbol captures are transparent, but they are not dummy captures.
+func TestSymbolCaptures(t *testing.T) {
+	code := vm.Encode(MustCompile(re.MustCompileCap(`
+		S     <- Digit ',' =a
+		Digit <- %a:[0-9]
+	`, nil)))
+	_, _, root, _, err := code.Exec(strings.NewReader("1,1"), memo.NoneTable{})
+	if err != nil || !root.Dummy() || root.NumChildren() != 1 {
+		t.Fatalf("got %v, %v", root, err)
+	}
+	// the definition of a is inside the capture of Digit.
+	digit := root.Child(0).Child(0)
+	if digit == nil || digit.Dummy() || digit.NumChildren() != 0 {
+		t.Errorf("got %v, expected the capture of Digit without children", digit)
+	}
+
+	sym := memo.NewCaptureSymbol(1, 0, 1, nil)
+	scope := memo.NewCaptureScope(0, 1, []*memo.Capture{sym})
+	if sym.Dummy() || scope.Dummy() || !sym.Transparent() || !scope.Transparent() {
+		t.Errorf("symbol and scope captures must be transparent but not dummy")
+	}
+}
+
+func TestReUndefinedSymbol(t *testing.T) {
+	_, err := re.Compile(`%a:'x' =b =c`)
+	perr, ok := err.(vm.ParseError)
+	if !ok || perr.Pos != 7 || perr.Message != "undefined symbol b" {
+		t.Errorf("got error %v, expected undefined symbol b at 7", err)
+	}
+}
+
+// A memoized result that uses a symbol defined before it is not reused after
+// an edit changes the symbol.
+func TestBackReferenceMemo(t *testing.T) {
+	code := vm.Encode(MustCompile(re.MustCompile(`
+		S    <- %d:[a-z] Body {{Elem}}*
+		Body <- {{ (!=d .)* =d }}
+		Elem <- %e:[0-9] =e
+	`)))
+	for _, backend := range []vm.Backend{vm.Interpreter, vm.Closures} {
+		code.SetBackend(backend)
+		tbl := memo.NewTreeTable(0)
+		subject := []byte("xabcx1122")
+		if match, n, _, _, _ := code.Exec(strings.NewReader(string(subject)), tbl); !match || n != 9 {
+			t.Fatalf("got (%t, %d), expected (true, 9)", match, n)
+		}
+		if tbl.Size() == 0 {
+			t.Errorf("results that only use their own symbols were not memoized")
+		}
+
+		subject[0] = 'a'
+		tbl.ApplyEdit(memo.Edit{Start: 0, End: 1, Len: 1})
+		match, n, _, _, _ := code.Exec(strings.NewReader(string(subject)), tbl)
+		if !match || n != 2 {
+			t.Errorf("got (%t, %d), expected (true, 2)", match, n)
+		}
+	}
+}
+
+func TestExecStreamBackReference(t *testing.T) {
+	// Doc  <- %sep:[,;] '\n' {[a-z]+ =sep '\n'}* !.
+	br := isa.NewBackRef()
+	code := vm.Encode(MustCompile(Concat(
+		CheckFlags(Set(charset.New([]byte{',', ';'})), br, 0, int(isa.RefDef)),
+		Literal("\n"),
+		Star(Cap(Concat(
+			Plus(Set(charset.Range('a', 'z'))),
+			CheckFlags(&EmptyNode{}, br, 0, int(isa.RefUse)),
+			Literal("\n"),
+		), 1)),
+		Not(Any(1)),
+	)))
+	subject := ";\n" + strings.Repeat("abc;\n", 1000)
+	var got []string
+	match, n, _, err := code.ExecStream(input.NewStream(strings.NewReader(subject)), func(c *memo.Capture) error {
+		got = append(got, fmt.Sprint(c.Start(), c.End()))
+		return nil
+	})
+	if !match || n != len(subject) || err != nil {
+		t.Fatalf("got (%t, %d, %v), expected (true, %d, nil)", match, n, err, len(subject))
+	}
+	if len(got) != 1000 || got[999] != fmt.Sprint(len(subject)-5, len(subject)) {
+		t.Errorf("got %d captures, last %v", len(got), got[len(got)-1])
+	}
+}
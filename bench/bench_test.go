@@ -0,0 +1,123 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+var backends = []struct {
+	name    string
+	backend vm.Backend
+}{
+	{"Interpreter", vm.Interpreter},
+	{"Closures", vm.Closures},
+}
+
+// benchBackends benchmarks a full parse of in with the grammar in the file
+// named peg using each backend.
+func benchBackends(b *testing.B, peg string, in []byte) {
+	grammar, err := ioutil.ReadFile(peg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(string(grammar))))
+	for _, be := range backends {
+		code.SetBackend(be.backend)
+		b.Run(be.name, func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				match, n, _, _, _ := code.Exec(bytes.NewReader(in), memo.NoneTable{})
+				if !match || n != len(in) {
+					b.Fatalf("parse failed at %d", n)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkJava(b *testing.B) {
+	java, err := ioutil.ReadFile("../testdata/ScriptRuntime.java")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchBackends(b, "../grammars/java.peg", java)
+}
+
+func BenchmarkJSON(b *testing.B) {
+	// a list of objects that use every kind of JSON value
+	var json strings.Builder
+	json.WriteString("[\n")
+	for i := 0; i < 5000; i++ {
+		if i > 0 {
+			json.WriteString(",\n")
+		}
+		fmt.Fprintf(&json, `  {"id": %d, "name": "item \"%d\"", "price": %d.%02de-1, "tags": ["a", "b\n"], "ok": %t, "next": null}`, i, i, i, i%100, i%2 == 0)
+	}
+	json.WriteString("\n]\n")
+	benchBackends(b, "../grammars/json.peg", []byte(json.String()))
+}
+
+// BenchmarkJavaDispatch compares the Java grammar compiled with and without
+// dispatching choices on their first bytes.
+func BenchmarkJavaDispatch(b *testing.B) {
+	java, err := ioutil.ReadFile("../testdata/ScriptRuntime.java")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func(dispatch bool) {
+		pattern.DispatchChoices = dispatch
+	}(pattern.DispatchChoices)
+	for _, dispatch := range []bool{false, true} {
+		pattern.DispatchChoices = dispatch
+		name := "Backtrack"
+		if dispatch {
+			name = "Dispatch"
+		}
+		b.Run(name, func(b *testing.B) {
+			benchBackends(b, "../grammars/java.peg", java)
+		})
+	}
+}
+
+// BenchmarkWordMatch compares matching keywords with a checker and with a
+// trie.
+func BenchmarkWordMatch(b *testing.B) {
+	keywords := []string{"public", "protected", "private", "static", "final", "abstract", "synchronized", "native"}
+	var in strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&in, "%s %s_%d ", keywords[i%len(keywords)], keywords[(i+1)%len(keywords)], i)
+	}
+	words := []byte(in.String())
+
+	for _, m := range []struct {
+		name string
+		patt pattern.Pattern
+	}{
+		{"Checker", WordMatch(keywords...)},
+		{"Trie", WordMatchTrie(keywords...)},
+	} {
+		// keywords are captured, and other words are skipped.
+		code := vm.Encode(pattern.MustCompile(pattern.Star(pattern.Or(
+			pattern.Cap(m.patt, 0),
+			word,
+			pattern.Literal(" "),
+		))))
+		b.Run(m.name, func(b *testing.B) {
+			b.SetBytes(int64(len(words)))
+			for i := 0; i < b.N; i++ {
+				match, n, capt, _, _ := code.Exec(bytes.NewReader(words), memo.NoneTable{})
+				if !match || n != len(words) || capt.NumChildren() != 10000 {
+					b.Fatalf("parse failed at %d", n)
+				}
+			}
+		})
+	}
+}
@@ -86,7 +86,7 @@ func GenerateEdits(data []byte, nedits int) []Edit {
 	tbl := memo.NewTreeTable(512)
 
 	for i := 0; i < nedits; {
-		_, _, ast, _ := java.Exec(r, tbl)
+		_, _, ast, _, _ := java.Exec(r, tbl)
 
 		var e Edit
 		typ := editTypes[rand.Intn(len(editTypes))]
@@ -1,6 +1,8 @@
 package bench
 
 import (
+	"sort"
+
 	"github.com/zyedidia/gpeg/charset"
 	"github.com/zyedidia/gpeg/isa"
 	p "github.com/zyedidia/gpeg/pattern"
@@ -53,6 +55,28 @@ func WordMatch(words ...string) p.Pattern {
 	return p.Check(word, isa.MapChecker(m))
 }
 
+// WordMatchTrie matches any of the given identifiers as a whole word, like
+// WordMatch, but with a choice between the words, which is compiled into a
+// trie, instead of a checker. The words are tried from the longest to the
+// shortest, so that a word is not cut short by another word that it starts
+// with.
+func WordMatchTrie(words ...string) p.Pattern {
+	sorted := append([]string(nil), words...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	lits := make([]p.Pattern, 0, len(sorted))
+	for _, w := range sorted {
+		lits = append(lits, p.Literal(w))
+	}
+
+	return p.Concat(
+		p.Or(lits...),
+		p.Not(p.Or(alnum, p.Literal("_"))),
+	)
+}
+
 // mini java grammar for picking out important pieces
 
 var grammar = p.Grammar("S", map[string]p.Pattern{
@@ -7,6 +7,7 @@ import (
 	"github.com/zyedidia/gpeg/charset"
 	"github.com/zyedidia/gpeg/memo"
 	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
 	"github.com/zyedidia/gpeg/vm"
 )
 
@@ -24,7 +25,7 @@ func TestCaptures(t *testing.T) {
 	)))
 	code := vm.Encode(MustCompile(p))
 	r := strings.NewReader("12 34 56 78 9")
-	_, _, ast, _ := code.Exec(r, memo.NoneTable{})
+	_, _, ast, _, _ := code.Exec(r, memo.NoneTable{})
 
 	expect := [][2]int{
 		{0, 2},
@@ -43,3 +44,34 @@ func TestCaptures(t *testing.T) {
 		i++
 	}
 }
+
+func TestCaptureByName(t *testing.T) {
+	p := re.MustCompileCap(`
+		List   <- Item (',' Item)*
+		Item   <- Key '=' Value
+		Key    <- [a-z]+
+		Value  <- [0-9]+
+	`, nil)
+	code := vm.Encode(MustCompile(p))
+	r := strings.NewReader("a=1,bc=23")
+	match, _, ast, _, _ := code.Exec(r, memo.NoneTable{})
+	if !match {
+		t.Fatal("no match")
+	}
+
+	list := ast.ChildByName("List", &code)
+	if list == nil {
+		t.Fatal("no List capture")
+	}
+	items := list.ChildrenByName("Item", &code)
+	if len(items) != 2 {
+		t.Fatalf("got %d items, expected 2", len(items))
+	}
+	val := items[1].ChildByName("Value", &code)
+	if val == nil || val.Start() != 7 || val.Len() != 2 {
+		t.Errorf("incorrect Value capture: %v", val)
+	}
+	if items[0].ChildByName("Missing", &code) != nil {
+		t.Error("unexpected capture for an unknown name")
+	}
+}
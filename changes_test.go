@@ -0,0 +1,66 @@
+package gpeg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestChangedRanges(t *testing.T) {
+	p := re.MustCompileCap(`
+		List   <- Item (',' Item)*
+		Item   <- {{Key '=' Value}}
+		Key    <- [a-z]+
+		Value  <- [0-9]+ / Key
+	`, nil)
+	code := vm.Encode(pattern.MustCompile(p))
+
+	tbl := memo.NewTreeTable(0)
+	in := []byte("a=1,b=2,c=3,d=4")
+	_, _, old, _, _ := code.Exec(strings.NewReader(string(in)), tbl)
+
+	tests := []struct {
+		edit   memo.Edit
+		text   string
+		expect []memo.Range
+	}{
+		// c=3 -> c=345 changes text but not structure
+		{memo.Edit{Start: 10, End: 11, Len: 3}, "345", nil},
+		// b=2 -> b=x changes the structure of the Value capture
+		{memo.Edit{Start: 6, End: 7, Len: 1}, "x", []memo.Range{{Start: 6, End: 7}}},
+		// a new item is inserted after c=345
+		{memo.Edit{Start: 13, End: 13, Len: 4}, ",e=5", []memo.Range{{Start: 14, End: 17}}},
+		// a=1 is removed, which shifts everything after it
+		{memo.Edit{Start: 0, End: 4, Len: 0}, "", []memo.Range{{Start: 0, End: 3}}},
+	}
+
+	for _, tt := range tests {
+		snap := memo.NewSnapshot(old)
+
+		in = append(in[:tt.edit.Start], append([]byte(tt.text), in[tt.edit.End:]...)...)
+		tbl.ApplyEdit(tt.edit)
+		snap.ApplyEdit(tt.edit)
+
+		_, _, ast, _, _ := code.Exec(strings.NewReader(string(in)), tbl)
+		if got := snap.ChangedRanges(ast); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%q: got %v, expected %v", in, got, tt.expect)
+		}
+
+		// the result must not depend on whether the reparse reused entries
+		_, _, full, _, _ := code.Exec(strings.NewReader(string(in)), memo.NoneTable{})
+		if got := snap.ChangedRanges(full); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%q: full reparse: got %v, expected %v", in, got, tt.expect)
+		}
+		old = ast
+	}
+
+	_, _, same, _, _ := code.Exec(strings.NewReader(string(in)), memo.NoneTable{})
+	if got := memo.ChangedRanges(old, same); got != nil {
+		t.Errorf("identical trees: got %v, expected no changes", got)
+	}
+}
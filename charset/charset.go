@@ -119,6 +119,17 @@ func (c Set) Sub(c1 Set) Set {
 	}
 }
 
+// Fold returns a charset that also matches the other case of the ASCII
+// letters matched by c.
+func (c Set) Fold() Set {
+	// the bits of lower case letters are 32 bits after those of upper case
+	// letters, in the second word.
+	const letters = uint64(0x7fffffe)
+	return Set{
+		Bits: [4]uint64{c.Bits[0], c.Bits[1] | (c.Bits[1]&letters)<<32 | (c.Bits[1]>>32)&letters, c.Bits[2], c.Bits[3]},
+	}
+}
+
 // Size returns the number of chars matched by this Set.
 func (c Set) Size() int {
 	return bits.OnesCount64(c.Bits[0]) + bits.OnesCount64(c.Bits[1]) + bits.OnesCount64(c.Bits[2]) + bits.OnesCount64(c.Bits[3])
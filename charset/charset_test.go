@@ -2,6 +2,7 @@ package charset_test
 
 import (
 	"testing"
+	"unicode"
 
 	"github.com/zyedidia/gpeg/charset"
 )
@@ -55,3 +56,69 @@ func TestBigSet(t *testing.T) {
 
 	inSet(set, in, notin, t)
 }
+
+func TestRuneSet(t *testing.T) {
+	set := charset.NewRuneSet(
+		charset.RuneRange{Lo: 'x', Hi: 'z'},
+		charset.RuneRange{Lo: 'a', Hi: 'c'},
+		charset.RuneRange{Lo: 'b', Hi: 'e'},
+		charset.RuneRange{Lo: 'f', Hi: 'f'},
+		charset.RuneRange{Lo: 'α', Hi: 'ω'},
+	)
+	if got, want := set.String(), `{'a'..'f','x'..'z','\u03b1'..'\u03c9'}`; got != want {
+		t.Errorf("got %s, expected %s", got, want)
+	}
+	for _, r := range "adfyβω" {
+		if !set.Has(r) {
+			t.Errorf("%c returned 'not in set'", r)
+		}
+	}
+	for _, r := range "gw日" {
+		if set.Has(r) {
+			t.Errorf("%c returned 'in set'", r)
+		}
+	}
+
+	comp := set.Complement()
+	if comp.Has('a') || !comp.Has('g') || !comp.Has(0) || !comp.Has(unicode.MaxRune) {
+		t.Errorf("wrong complement %s", comp)
+	}
+	if !comp.Complement().Equal(set) {
+		t.Error("complement of complement differs")
+	}
+
+	if _, ok := set.Bytes(); ok {
+		t.Error("non-ASCII set converted to bytes")
+	}
+	bytes, ok := charset.NewRuneSet(charset.RuneRange{Lo: 'a', Hi: 'c'}).Bytes()
+	if !ok || bytes != charset.Range('a', 'c') {
+		t.Errorf("wrong byte set %v", bytes)
+	}
+}
+
+func TestRuneSetTable(t *testing.T) {
+	set := charset.FromTable(unicode.L)
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if set.Has(r) != unicode.IsLetter(r) {
+			t.Fatalf("%U: got %t", r, set.Has(r))
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	set := charset.Range('a', 'c').Add(charset.New([]byte{'Z', '@', '['})).Fold()
+	if got, want := set.String(), `{'@'..'C','Z'..'[','a'..'c','z'}`; got != want {
+		t.Errorf("got %s, expected %s", got, want)
+	}
+
+	runes := charset.Runes('a', 'z').Add(charset.Runes('ß', 'ß')).Fold()
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		want := r >= 'a' && r <= 'z' || r == 'ß'
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			want = want || f >= 'a' && f <= 'z' || f == 'ß'
+		}
+		if runes.Has(r) != want {
+			t.Fatalf("%U: got %t", r, runes.Has(r))
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package charset
+
+import (
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A RuneRange is the range of code points from Lo to Hi inclusive.
+type RuneRange struct {
+	Lo, Hi rune
+}
+
+// A RuneSet represents a set of Unicode code points.
+type RuneSet struct {
+	// Ranges are the sorted, non-overlapping and non-adjacent ranges of code
+	// points in the set.
+	Ranges []RuneRange
+}
+
+// NewRuneSet returns a rune set which accepts all code points in the given
+// ranges.
+func NewRuneSet(ranges ...RuneRange) RuneSet {
+	rs := make([]RuneRange, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Lo <= r.Hi {
+			rs = append(rs, r)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Lo < rs[j].Lo
+	})
+
+	// merge overlapping and adjacent ranges.
+	var merged []RuneRange
+	for _, r := range rs {
+		if n := len(merged); n > 0 && r.Lo <= merged[n-1].Hi+1 {
+			if r.Hi > merged[n-1].Hi {
+				merged[n-1].Hi = r.Hi
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return RuneSet{
+		Ranges: merged,
+	}
+}
+
+// Runes returns a rune set matching all code points between low and high
+// inclusive.
+func Runes(low, high rune) RuneSet {
+	return NewRuneSet(RuneRange{low, high})
+}
+
+// FromTable returns a rune set matching the code points in the Unicode
+// range table t, such as unicode.L or unicode.Greek.
+func FromTable(t *unicode.RangeTable) RuneSet {
+	var rs []RuneRange
+	for _, r := range t.R16 {
+		for c := rune(r.Lo); c <= rune(r.Hi); c += rune(r.Stride) {
+			if r.Stride == 1 {
+				rs = append(rs, RuneRange{rune(r.Lo), rune(r.Hi)})
+				break
+			}
+			rs = append(rs, RuneRange{c, c})
+		}
+	}
+	for _, r := range t.R32 {
+		for c := rune(r.Lo); c <= rune(r.Hi); c += rune(r.Stride) {
+			if r.Stride == 1 {
+				rs = append(rs, RuneRange{rune(r.Lo), rune(r.Hi)})
+				break
+			}
+			rs = append(rs, RuneRange{c, c})
+		}
+	}
+	return NewRuneSet(rs...)
+}
+
+// Add combines the code points two rune sets match together.
+func (s RuneSet) Add(s1 RuneSet) RuneSet {
+	rs := make([]RuneRange, 0, len(s.Ranges)+len(s1.Ranges))
+	rs = append(rs, s.Ranges...)
+	rs = append(rs, s1.Ranges...)
+	return NewRuneSet(rs...)
+}
+
+// Fold returns a rune set that also matches the code points that are
+// equivalent to those matched by s under Unicode simple case folding.
+func (s RuneSet) Fold() RuneSet {
+	rs := append([]RuneRange(nil), s.Ranges...)
+	// every set of equivalent code points contains a code point with a case
+	// mapping.
+	for _, cr := range unicode.CaseRanges {
+		for c := rune(cr.Lo); c <= rune(cr.Hi); c++ {
+			in := s.Has(c)
+			for f := unicode.SimpleFold(c); f != c && !in; f = unicode.SimpleFold(f) {
+				in = s.Has(f)
+			}
+			if !in {
+				continue
+			}
+			rs = append(rs, RuneRange{c, c})
+			for f := unicode.SimpleFold(c); f != c; f = unicode.SimpleFold(f) {
+				rs = append(rs, RuneRange{f, f})
+			}
+		}
+	}
+	return NewRuneSet(rs...)
+}
+
+// Complement returns a rune set that matches all code points except for
+// those matched by s.
+func (s RuneSet) Complement() RuneSet {
+	var rs []RuneRange
+	next := rune(0)
+	for _, r := range s.Ranges {
+		if r.Lo > next {
+			rs = append(rs, RuneRange{next, r.Lo - 1})
+		}
+		next = r.Hi + 1
+	}
+	if next <= unicode.MaxRune {
+		rs = append(rs, RuneRange{next, unicode.MaxRune})
+	}
+	return RuneSet{
+		Ranges: rs,
+	}
+}
+
+// Has checks if a rune set accepts a code point.
+func (s *RuneSet) Has(r rune) bool {
+	rs := s.Ranges
+	// linear search is faster for small sets.
+	if len(rs) <= 8 {
+		for _, rr := range rs {
+			if r < rr.Lo {
+				return false
+			}
+			if r <= rr.Hi {
+				return true
+			}
+		}
+		return false
+	}
+	lo, hi := 0, len(rs)
+	for lo < hi {
+		m := lo + (hi-lo)/2
+		switch {
+		case r < rs[m].Lo:
+			hi = m
+		case r > rs[m].Hi:
+			lo = m + 1
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Equal returns true if both rune sets match the same code points.
+func (s RuneSet) Equal(s1 RuneSet) bool {
+	if len(s.Ranges) != len(s1.Ranges) {
+		return false
+	}
+	for i := range s.Ranges {
+		if s.Ranges[i] != s1.Ranges[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Bytes returns the charset matching the same characters as s, if s only
+// matches ASCII characters.
+func (s RuneSet) Bytes() (Set, bool) {
+	var set Set
+	for _, r := range s.Ranges {
+		if r.Hi >= utf8.RuneSelf {
+			return Set{}, false
+		}
+		set = set.Add(Range(byte(r.Lo), byte(r.Hi)))
+	}
+	return set, true
+}
+
+// String returns the string representation of the rune set.
+func (s RuneSet) String() string {
+	str := ""
+	for i, r := range s.Ranges {
+		if i > 0 {
+			str += ","
+		}
+		str += strconv.QuoteRuneToASCII(r.Lo)
+		if r.Hi != r.Lo {
+			str += ".." + strconv.QuoteRuneToASCII(r.Hi)
+		}
+	}
+	return "{" + str + "}"
+}
@@ -1,29 +1,57 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp/syntax"
+	"sort"
+	"strings"
 
+	"github.com/zyedidia/gpeg/gen"
+	"github.com/zyedidia/gpeg/memo"
 	"github.com/zyedidia/gpeg/pattern"
 	"github.com/zyedidia/gpeg/re"
 	"github.com/zyedidia/gpeg/rxconv"
+	"github.com/zyedidia/gpeg/vm"
 )
 
 var regex = flag.Bool("regex", false, "compile regex instead of PEG")
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "trace":
+			trace(os.Args[2:])
+			return
+		case "profile":
+			profile(os.Args[2:])
+			return
+		case "gen":
+			generate(os.Args[2:])
+			return
+		case "check":
+			check(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	args := flag.Args()
 
 	var in io.Reader
+	// modules are imported relative to the directory of the grammar.
+	dir := "."
 	if len(args) <= 0 {
 		in = os.Stdin
 	} else {
+		dir = filepath.Dir(args[0])
 		f, err := os.Open(args[0])
 		if err != nil {
 			log.Fatal(err)
@@ -41,7 +69,7 @@ func main() {
 	if *regex {
 		patt, err = rxconv.FromRegexp(string(bytes), syntax.Perl)
 	} else {
-		patt, err = re.Compile(string(bytes))
+		patt, err = re.CompileFS(string(bytes), os.DirFS(dir))
 	}
 	if err != nil {
 		log.Fatal(err)
@@ -52,3 +80,222 @@ func main() {
 	}
 	fmt.Println(prog)
 }
+
+// trace runs a grammar on an input file and prints an indented trace of the
+// rules that are entered and exited.
+func trace(args []string) {
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	steps := flags.Bool("steps", false, "also print every instruction executed and the grammar source it comes from")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gpeg trace [-steps] GRAMMAR INPUT")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	code, in, grammar := load(flags.Arg(0), flags.Arg(1))
+
+	depth := 0
+	match, n, _, _, err := code.ExecContext(context.Background(), strings.NewReader(string(in)), memo.NoneTable{}, vm.ExecOptions{
+		Trace: func(ev vm.Event) {
+			switch ev.Kind {
+			case vm.EventEnter:
+				fmt.Printf("%s%s %d\n", strings.Repeat("  ", depth), ev.Rule, ev.Pos)
+				depth++
+			case vm.EventExit:
+				depth--
+				result := "fail"
+				if ev.Match {
+					result = fmt.Sprint(ev.Pos)
+				}
+				fmt.Printf("%s%s -> %s\n", strings.Repeat("  ", depth), ev.Rule, result)
+			case vm.EventStep:
+				if *steps {
+					src := ""
+					if info, ok := code.Source(ev.Ip); ok && info.Start != -1 {
+						src = "\t" + strings.Join(strings.Fields(grammar[info.Start:info.End]), " ")
+					}
+					fmt.Printf("%s%d: %s @%d%s\n", strings.Repeat("  ", depth), ev.Ip, ev.Op, ev.Pos, src)
+				}
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("match: %t, %d of %d bytes\n", match, n, len(in))
+}
+
+// profile runs a grammar on an input file and prints a report of the time
+// spent in each rule.
+func profile(args []string) {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	sortby := flags.String("sort", "steps", "sort rules by `column`: steps, calls, fails, backtracks, consumed or memo")
+	memoize := flags.Bool("memo", false, "use a memoization table")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gpeg profile [-sort column] [-memo] GRAMMAR INPUT")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var key func(r *vm.RuleProfile) int
+	switch *sortby {
+	case "steps":
+		key = func(r *vm.RuleProfile) int { return r.Steps }
+	case "calls":
+		key = func(r *vm.RuleProfile) int { return r.Calls }
+	case "fails":
+		key = func(r *vm.RuleProfile) int { return r.Fails }
+	case "backtracks":
+		key = func(r *vm.RuleProfile) int { return r.Backtracks }
+	case "consumed":
+		key = func(r *vm.RuleProfile) int { return r.Consumed }
+	case "memo":
+		key = func(r *vm.RuleProfile) int { return r.MemoHits + r.MemoMisses }
+	default:
+		log.Fatalf("unknown sort column %q", *sortby)
+	}
+
+	code, in, _ := load(flags.Arg(0), flags.Arg(1))
+
+	var memtbl memo.Table = memo.NoneTable{}
+	if *memoize {
+		memtbl = memo.NewTreeTable(0)
+	}
+	prof := &vm.Profile{}
+	match, n, _, _, err := code.ExecContext(context.Background(), strings.NewReader(string(in)), memtbl, vm.ExecOptions{
+		Profile: prof,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rules := prof.Rules()
+	sort.SliceStable(rules, func(i, j int) bool {
+		return key(&rules[i]) > key(&rules[j])
+	})
+	if err := vm.WriteReport(os.Stdout, rules); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("match: %t, %d of %d bytes\n", match, n, len(in))
+}
+
+// generate writes the source of a Go package implementing the parser for a
+// grammar.
+func generate(args []string) {
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	pkg := flags.String("pkg", "", "package `name` of the generated code (default: the name of the grammar file)")
+	out := flags.String("o", "", "write the generated code to `file` instead of standard output")
+	regex := flags.Bool("regex", false, "compile regex instead of PEG")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gpeg gen [-pkg name] [-o file] [-regex] GRAMMAR")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	grammar, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var patt pattern.Pattern
+	if *regex {
+		patt, err = rxconv.FromRegexp(string(grammar), syntax.Perl)
+	} else {
+		patt, err = re.CompileFS(string(grammar), os.DirFS(filepath.Dir(flags.Arg(0))))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *pkg
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(flags.Arg(0)), filepath.Ext(flags.Arg(0)))
+	}
+	var buf bytes.Buffer
+	if err := gen.Generate(&buf, name, patt); err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(buf.Bytes())
+	} else if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// check prints the problems found in a grammar by static analysis, and exits
+// with a non-zero status if any of them is an error.
+func check(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: gpeg check GRAMMAR")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	peg := flags.Arg(0)
+	grammar, err := os.ReadFile(peg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	patt, err := re.CompileFS(string(grammar), os.DirFS(filepath.Dir(peg)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for _, d := range pattern.Analyze(patt) {
+		loc := peg
+		if d.Start != -1 {
+			line := 1 + strings.Count(string(grammar[:d.Start]), "\n")
+			col := d.Start - strings.LastIndex(string(grammar[:d.Start]), "\n")
+			loc = fmt.Sprintf("%s:%d:%d", peg, line, col)
+		}
+		severity := "error"
+		if d.Warning() {
+			severity = "warning"
+		} else {
+			failed = true
+		}
+		fmt.Printf("%s: %s: %v\n", loc, severity, d)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// load compiles the grammar in the file named peg and reads the input file.
+// It also returns the source of the grammar.
+func load(peg, input string) (vm.Code, []byte, string) {
+	grammar, err := os.ReadFile(peg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	in, err := os.ReadFile(input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	patt, err := re.CompileFS(string(grammar), os.DirFS(filepath.Dir(peg)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	prog, err := pattern.Compile(patt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return vm.Encode(prog), in, string(grammar)
+}
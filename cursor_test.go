@@ -0,0 +1,93 @@
+package gpeg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestTreeCursor(t *testing.T) {
+	p := re.MustCompileCap(`
+		List   <- Item {{',' Item}}*
+		Item   <- Key '=' Value
+		Key    <- [a-z]+
+		Value  <- [0-9]+
+	`, nil)
+	code := vm.Encode(pattern.MustCompile(p))
+	tbl := memo.NewTreeTable(0)
+	in := "a=1,bc=23,d=4,ef=56"
+	_, _, ast, _, _ := code.Exec(strings.NewReader(in), tbl)
+
+	// a pre-order walk with the cursor must visit the same nodes as a walk
+	// with ChildIterator
+	var expect func(c *memo.Capture, depth int) string
+	expect = func(c *memo.Capture, depth int) string {
+		s := fmt.Sprintf("%d:%d[%d,%d) ", depth, c.Id(), c.Start(), c.End())
+		it := c.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			s += expect(ch, depth+1)
+		}
+		return s
+	}
+	var walk func(tc *memo.TreeCursor) string
+	walk = func(tc *memo.TreeCursor) string {
+		c := tc.Node()
+		s := fmt.Sprintf("%d:%d[%d,%d) ", tc.Depth(), c.Id(), c.Start(), c.End())
+		if tc.GotoFirstChild() {
+			s += walk(tc)
+			for tc.GotoNextSibling() {
+				s += walk(tc)
+			}
+			tc.GotoParent()
+		}
+		return s
+	}
+
+	tc := memo.NewTreeCursor(ast)
+	if got, want := walk(tc), expect(ast, 0); got != want {
+		t.Errorf("got walk %s, expected %s", got, want)
+	}
+	if tc.Node() != ast || tc.GotoParent() || tc.GotoNextSibling() {
+		t.Error("cursor did not return to the root")
+	}
+
+	list := ast.ChildByName("List", &code)
+	value, _ := code.CaptureId("Value")
+	tc.GotoFirstChild()
+	if !tc.GotoLastChild() || tc.Node().End() != len(in) {
+		t.Fatalf("incorrect last child: %v", tc.Node())
+	}
+	n := 1
+	for tc.GotoPrevSibling() {
+		n++
+	}
+	if n != list.NumChildren() {
+		t.Errorf("got %d items going backwards, expected %d", n, list.NumChildren())
+	}
+
+	// descend to the Value capture of "23" and walk back up to the root
+	tc.Reset()
+	node := tc.DescendToPos(8)
+	if node.Id() != value || node.Start() != 7 || node.Len() != 2 {
+		t.Errorf("incorrect node at position 8: %d [%d, %d)", node.Id(), node.Start(), node.End())
+	}
+	if tc.Depth() != 3 || tc.Parent().Start() != 4 {
+		t.Errorf("incorrect parent of Value at depth %d: %v", tc.Depth(), tc.Parent())
+	}
+	for tc.GotoParent() {
+	}
+	if tc.Node() != ast {
+		t.Error("cursor did not return to the root")
+	}
+
+	// ',' is not contained in any capture below List
+	tc.GotoFirstChild()
+	if node := tc.DescendToPos(3); node.Id() != list.Id() {
+		t.Errorf("incorrect node at position 3: %d", node.Id())
+	}
+}
@@ -0,0 +1,221 @@
+package gpeg
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/bench"
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// Returns the number of instructions in prog that push a backtrack entry.
+func countChoices(prog isa.Program) int {
+	n := 0
+	for _, insn := range prog {
+		switch insn.(type) {
+		case isa.Choice, isa.TestChar, isa.TestSet, isa.TestAny:
+			n++
+		}
+	}
+	return n
+}
+
+func TestDispatchChoices(t *testing.T) {
+	tests := []struct {
+		peg     string
+		choices int
+		tests   []PatternTest
+	}{
+		// the alternatives are told apart through the rules they call.
+		{`S <- A / B / C
+		  A <- 'a' [0-9]+ ';'
+		  B <- Word ';'
+		  C <- '(' S ')'
+		  Word <- [b-z]+`, 0, []PatternTest{
+			{"a12;", 4},
+			{"xy;", 3},
+			{"((a1;))", 7},
+			{"a;", -1},
+			{"(x;", -1},
+		}},
+		// alternatives that start with the same byte need a backtrack entry.
+		{`S <- 'ab' / 'ac'`, 1, []PatternTest{
+			{"ac", 2},
+			{"ad", -1},
+		}},
+		// an alternative that can match the empty string is told apart by
+		// what follows the choice, including what follows the rule.
+		{`S <- ('ab' 'c' / 'x'?) 'y'`, 0, []PatternTest{
+			{"abcy", 4},
+			{"y", 1},
+			{"aby", -1},
+		}},
+		{`S <- A 'c'
+		  A <- 'x' ('ab')?`, 0, []PatternTest{
+			{"xabc", 4},
+			{"xc", 2},
+			{"xac", -1},
+		}},
+		{`S <- ('ab')? 'a'`, 1, []PatternTest{
+			{"aba", 3},
+			{"a", 1},
+		}},
+		// what follows a repetition, or the first alternative of a choice
+		// that needs a backtrack entry, may resume at an earlier position.
+		{`S <- ('a' 'b')* 'c'`, 1, []PatternTest{
+			{"ababc", 5},
+			{"abac", -1},
+		}},
+		{`S <- A* 'c'
+		  A <- 'x' ('ab')?`, 2, []PatternTest{
+			{"xabxc", 5},
+			{"xac", -1},
+		}},
+		{`S <- (A / 'xa') 'c'
+		  A <- 'x' ('ab')?`, 2, []PatternTest{
+			{"xabc", 4},
+			{"xac", -1},
+		}},
+		// left-recursive calls are not analyzed.
+		{`S <- E / '#'
+		  E <- E '+' N / N
+		  N <- [0-9]`, 2, []PatternTest{
+			{"1+2", 3},
+			{"#", 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.peg, func(t *testing.T) {
+			p := re.MustCompile(tt.peg)
+			prog := pattern.MustCompile(p)
+			if choices := countChoices(prog); choices != tt.choices {
+				t.Errorf("got %d choices, expected %d:\n%v", choices, tt.choices, prog)
+			}
+			check(p, tt.tests, t)
+		})
+	}
+}
+
+func TestDispatchSyntaxError(t *testing.T) {
+	tests := []struct {
+		peg string
+		in  string
+		msg string
+	}{
+		{`S <- ('ab' / 'cd') 'e'`, "ax", "expected 'b' at 1:2"},
+		{`S <- ('ab' / 'cd') 'e'`, "x", "expected 'a' or 'c' at 1:1"},
+		// alternatives that start with a predicate are not dispatched,
+		// because the predicate can fail farther than the next byte.
+		{`S <- ('ba' / &('bc' 'x') 'q') 'a'`, "bcc", "expected 'x' at 1:3"},
+	}
+	for _, tt := range tests {
+		code := vm.Encode(pattern.MustCompile(re.MustCompile(tt.peg)))
+		_, _, _, err := code.Parse(strings.NewReader(tt.in), memo.NoneTable{})
+		var serr *vm.SyntaxError
+		if !errors.As(err, &serr) || serr.Error() != tt.msg {
+			t.Errorf("%s: %q: got %v, expected %q", tt.peg, tt.in, err, tt.msg)
+		}
+	}
+}
+
+// Returns n random edits of data that insert, remove or replace a few
+// bytes taken from data itself.
+func randomEdits(data []byte, n int) []bench.Edit {
+	edits := make([]bench.Edit, n)
+	for i := range edits {
+		start := rand.Intn(len(data))
+		end := start + rand.Intn(3)
+		if end > len(data) {
+			end = len(data)
+		}
+		from := rand.Intn(len(data))
+		text := data[from : from+rand.Intn(len(data)-from+1)%4]
+		edits[i] = bench.Edit{Start: start, End: end, Text: text}
+	}
+	return edits
+}
+
+// Programs that dispatch on the next byte must give the same results and
+// syntax errors as programs that backtrack.
+func TestDispatchEquivalence(t *testing.T) {
+	rand.Seed(7)
+
+	read := func(file string) []byte {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	java := read("testdata/test.java")
+	runtime := read("testdata/ScriptRuntime.java")
+	tests := []struct {
+		name  string
+		peg   string
+		in    []byte
+		edits []bench.Edit
+	}{
+		{"java", string(read("grammars/java.peg")), java, nil},
+		{"java", string(read("grammars/java.peg")), runtime, append(bench.GenerateEdits(runtime, 5), randomEdits(runtime, 5)...)},
+		{"arith", string(read("grammars/arith.peg")), []byte("(1+2)*34/(5-6*(7+8))-9"), nil},
+		{"peg", string(read("grammars/peg.peg")), []byte("Expr <- Factor ([+\\-] Factor)*\nFactor <- [0-9]+ / '(' Expr ')'\n"), nil},
+		// a failure after a choice must not be caught by the backtrack
+		// entries of the repetitions around it.
+		{"repetition", `S <- ('aaa' ('cca' 'b')*)* 'a'`, []byte("aaacc"), nil},
+		{"optional", `S <- ('x' ('ab' 'c')?)* 'xa'`, []byte("xab"), nil},
+		{"empty", `S <- ('x' ('ab' 'c' / ''))* 'xa'`, []byte("xab"), nil},
+		// the lookahead in the second alternative fails farther than the
+		// first alternative.
+		{"lookahead", `S <- ('ba' / &('bc' 'x') 'q') 'a'`, []byte("bcc"), nil},
+	}
+	for _, tt := range tests {
+		p := re.MustCompileCap(tt.peg, make(map[string]int))
+		pattern.DispatchChoices = false
+		backtrack := vm.Encode(pattern.MustCompile(p))
+		pattern.DispatchChoices = true
+		dispatch := vm.Encode(pattern.MustCompile(p))
+
+		edits := tt.edits
+		if edits == nil {
+			edits = randomEdits(tt.in, 200)
+		}
+		checkEquivalent(t, tt.name, backtrack, dispatch, tt.in, edits)
+	}
+}
+
+// Checks that the programs a and b give the same results, and report syntax
+// errors at the same positions, for in and for each of the edits of in.
+func checkEquivalent(t *testing.T, name string, a, b vm.Code, in []byte, edits []bench.Edit) {
+	edits = append([]bench.Edit{{}}, edits...)
+	for i, e := range edits {
+		if e.End > len(in) {
+			continue
+		}
+		subject := string(in[:e.Start]) + string(e.Text) + string(in[e.End:])
+		amatch, an, acapt, aerrs, aerr := a.Exec(strings.NewReader(subject), memo.NoneTable{})
+		bmatch, bn, bcapt, berrs, berr := b.Exec(strings.NewReader(subject), memo.NoneTable{})
+		if !amatch && !bmatch {
+			// the position where a failing match stops is unspecified.
+			an, bn = 0, 0
+		}
+		want := result(amatch, an, acapt, aerrs, aerr)
+		got := result(bmatch, bn, bcapt, berrs, berr)
+		if got != want {
+			t.Fatalf("%s: edit %d: got %.100s, expected %.100s", name, i, got, want)
+		}
+
+		_, _, _, werr := a.Parse(strings.NewReader(subject), memo.NoneTable{})
+		_, _, _, gerr := b.Parse(strings.NewReader(subject), memo.NoneTable{})
+		var wserr, gserr *vm.SyntaxError
+		if errors.As(werr, &wserr) != errors.As(gerr, &gserr) || wserr != nil && wserr.Pos != gserr.Pos {
+			t.Fatalf("%s: edit %d: got error %v, expected %v", name, i, gerr, werr)
+		}
+	}
+}
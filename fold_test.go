@@ -0,0 +1,62 @@
+package gpeg
+
+import (
+	"testing"
+
+	"github.com/zyedidia/gpeg/isa"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+)
+
+func TestLiteralFold(t *testing.T) {
+	p := LiteralFold("select-1")
+	tests := []PatternTest{
+		{"select-1", 8},
+		{"SELECT-1", 8},
+		{"SeLeCt-1", 8},
+		{"selecd-1", -1},
+		{"select_1", -1},
+	}
+	check(p, tests, t)
+
+	// keywords compile to charset and char instructions only.
+	for _, insn := range MustCompile(p) {
+		switch insn.(type) {
+		case isa.Set, isa.Char, isa.End:
+		default:
+			t.Errorf("unexpected instruction %v", insn)
+		}
+	}
+
+	check(LiteralFold("été\xff"), []PatternTest{
+		{"ÉTÉ\xff", 6},
+		{"éTé\xff", 6},
+		{"ete\xff", -1},
+	}, t)
+	// ASCII letters are only equivalent to ASCII letters.
+	check(LiteralFold("k"), []PatternTest{{"K", 1}, {"K", -1}}, t)
+}
+
+func TestReFold(t *testing.T) {
+	tests := []struct {
+		patt  string
+		tests []PatternTest
+	}{
+		{`'select'i`, []PatternTest{{"SELECT", 6}, {"Select", 6}, {"selec", -1}}},
+		{`"from"i !.`, []PatternTest{{"FrOm", 4}, {"from ", -1}}},
+		{`[a-c]i+`, []PatternTest{{"aBcD", 3}, {"ABC", 3}}},
+		{`[^a-c]i`, []PatternTest{{"B", -1}, {"d", 1}}},
+		{`[é]i`, []PatternTest{{"É", 2}}},
+		{`'ß'i`, []PatternTest{{"ẞ", 3}}},
+		// an identifier after a literal is not a flag.
+		{`A <- 'a'id
+		  id <- 'b'`, []PatternTest{{"ab", 2}, {"Ab", -1}}},
+		{`A <- 'a'i d
+		  d <- 'b'`, []PatternTest{{"Ab", 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patt, func(t *testing.T) {
+			check(re.MustCompile(tt.patt), tt.tests, t)
+		})
+	}
+}
@@ -0,0 +1,745 @@
+// Package gen generates Go source code for parsers. A generated parser is a
+// package that executes a compiled program in the same way as the VM, but
+// with every instruction specialized into Go code, so that no bytecode is
+// decoded at run time. It produces the same capture trees as vm.Code.Exec
+// and supports the same memoization tables for incremental parsing.
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/pattern"
+)
+
+// Generate compiles p and writes the source of a Go package named pkg that
+// implements the resulting parser to w.
+func Generate(w io.Writer, pkg string, p pattern.Pattern) error {
+	prog, err := pattern.Compile(p)
+	if err != nil {
+		return err
+	}
+	return GenerateProgram(w, pkg, prog)
+}
+
+// GenerateProgram writes the source of a Go package named pkg that implements
+// the parser for prog to w. The program's checkers must be created with
+// back references or created with isa.NewMapChecker, because other checkers
+// cannot be written as Go code.
+func GenerateProgram(w io.Writer, pkg string, prog isa.Program) error {
+	g := &generator{
+		Package:  pkg,
+		CapNames: make(map[int]string),
+		leaders:  make(map[int]bool),
+		labels:   make(map[isa.Label]int),
+		rules:    make(map[int]string),
+
+		checkerIds: make(map[uintptr]int),
+	}
+	g.layout(prog)
+	if err := g.generate(); err != nil {
+		return err
+	}
+	g.CapIds = make(map[string]int, len(g.CapNames))
+	for id, name := range g.CapNames {
+		if prev, ok := g.CapIds[name]; !ok || id < prev {
+			g.CapIds[name] = id
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := parser.Execute(&buf, g); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated invalid code: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// maxBlock is the number of instructions after which a new block is started
+// at the next instruction that can be jumped to. The Go compiler needs time
+// and memory that grow faster than the size of a function, so large programs
+// are split into many functions.
+const maxBlock = 256
+
+type generator struct {
+	// fields used by the template
+	Package  string
+	CapNames map[int]string
+	// CapIds maps each capture name to the smallest ID with that name, like
+	// vm.Code.CaptureId.
+	CapIds   map[string]int
+	Sets     []charset.Set
+	RuneSets []charset.RuneSet
+	// Literals are the bodies of the functions that match the strings of
+	// Literals instructions.
+	Literals []string
+	Checkers []string
+	Labels   []string
+	Catches  [][]int
+	Blocks   []block
+	// BlockOf is the list of elements of the table from instructions to
+	// blocks, and BlockType the type of its elements.
+	BlockOf   string
+	BlockType string
+	Empty     bool
+	BackRef   bool
+	LR        bool
+	Memo      bool
+	Throw     bool
+
+	// the instructions of the program without labels and markers, ending
+	// with an End instruction like encoded code
+	insns []isa.Insn
+	// instructions that start a case of the generated switch
+	leaders map[int]bool
+	// index of the instruction that each label refers to
+	labels map[isa.Label]int
+	// names of the rules that start at an instruction
+	rules map[int]string
+	// index in Checkers of each checker, by the address of its data
+	checkerIds map[uintptr]int
+
+	buf strings.Builder
+}
+
+// A block is a function that executes a range of instructions. It returns
+// the index of the next instruction to execute when control leaves the range.
+type block struct {
+	// Rule is the name of the rule that the block starts in, if known.
+	Rule string
+	// Start is the index of the first instruction of the block.
+	Start int
+	// Code is the body of the switch over the instructions of the block.
+	Code string
+	// Src and Stack are true if the code refers to the input and the stack.
+	Src, Stack bool
+}
+
+// layout assigns an index to every instruction and finds the instructions
+// that can be jumped to.
+func (g *generator) layout(prog isa.Program) {
+	for _, insn := range prog {
+		switch t := insn.(type) {
+		case isa.Label:
+			g.labels[t] = len(g.insns)
+		case isa.RuleBegin:
+			g.rules[len(g.insns)] = t.Name
+		case isa.Nop, isa.NodeBegin, isa.NodeEnd:
+		case isa.LRCall:
+			g.LR = true
+			g.insns = append(g.insns, insn)
+		case isa.CheckEnd:
+			if isBackRef(t.Checker) {
+				g.BackRef = true
+			}
+			g.insns = append(g.insns, insn)
+		default:
+			g.insns = append(g.insns, insn)
+		}
+	}
+	g.insns = append(g.insns, isa.End{})
+
+	g.leaders[0] = true
+	for _, i := range g.labels {
+		g.leaders[i] = true
+	}
+	for i, insn := range g.insns {
+		switch insn.(type) {
+		case isa.Call, isa.LRCall, isa.ThrowRecover:
+			// return address
+			g.leaders[i+1] = true
+		case isa.Jump, isa.Commit, isa.PartialCommit, isa.Return, isa.Fail,
+			isa.BackCommit, isa.FailTwice, isa.Throw, isa.End:
+			// the next instruction is only reachable by jumping to it
+			g.leaders[i+1] = true
+		}
+	}
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+var (
+	srcRef   = regexp.MustCompile(`\bsrc\.`)
+	stackRef = regexp.MustCompile(`\bst\.`)
+)
+
+// generate writes the code for every instruction into Blocks.
+func (g *generator) generate() error {
+	rule := ""
+	start := 0
+	for i, insn := range g.insns {
+		if g.leaders[i] {
+			_, isRule := g.rules[i]
+			if i > start && (isRule || i-start >= maxBlock) {
+				if !ends(g.insns[i-1]) {
+					g.printf("return %d", i)
+				}
+				g.endBlock(rule, start)
+				start = i
+			} else if i > 0 && !ends(g.insns[i-1]) {
+				g.printf("fallthrough")
+			}
+			if name, ok := g.rules[i]; ok {
+				if i == start {
+					rule = name
+				} else {
+					g.printf("// %s", name)
+				}
+			}
+			g.printf("case %d:", i)
+		}
+		if err := g.insn(i, insn); err != nil {
+			return err
+		}
+	}
+	g.endBlock(rule, start)
+
+	g.BlockType = "uint8"
+	if len(g.Blocks) > 1<<8 {
+		g.BlockType = "uint16"
+	}
+	if len(g.Blocks) > 1<<16 {
+		g.BlockType = "uint32"
+	}
+	var tbl strings.Builder
+	b := 0
+	for i := range g.insns {
+		if b+1 < len(g.Blocks) && g.Blocks[b+1].Start == i {
+			b++
+		}
+		if i%16 == 0 {
+			tbl.WriteString("\n")
+		} else {
+			tbl.WriteString(" ")
+		}
+		fmt.Fprintf(&tbl, "%d,", b)
+	}
+	g.BlockOf = tbl.String() + "\n"
+	return nil
+}
+
+// endBlock adds a block starting at instruction start with the code written
+// since the last block.
+func (g *generator) endBlock(rule string, start int) {
+	code := g.buf.String()
+	g.buf.Reset()
+	g.Blocks = append(g.Blocks, block{
+		Rule:  rule,
+		Start: start,
+		Code:  code,
+		Src:   srcRef.MatchString(code),
+		Stack: stackRef.MatchString(code),
+	})
+}
+
+// ends returns true if the code for insn never continues with the next
+// instruction.
+func ends(insn isa.Insn) bool {
+	switch insn.(type) {
+	case isa.Jump, isa.Call, isa.LRCall, isa.Commit, isa.PartialCommit,
+		isa.Return, isa.Fail, isa.BackCommit, isa.FailTwice, isa.Throw,
+		isa.ThrowRecover, isa.End:
+		return true
+	}
+	return false
+}
+
+// lbl returns the index of the instruction that l refers to.
+func (g *generator) lbl(l isa.Label) int {
+	return g.labels[l]
+}
+
+// insn writes the code for the instruction at index i.
+func (g *generator) insn(i int, insn isa.Insn) error {
+	next := i + 1
+	switch t := insn.(type) {
+	case isa.Char:
+		g.printf("if c, ok := src.Peek(); ok && c == %d {", t.Byte)
+		g.printf("src.Advance(1)")
+		g.printf("} else {")
+		g.printf("return ipFail")
+		g.printf("}")
+	case isa.Set:
+		g.printf("if c, ok := src.Peek(); ok && sets[%d].Has(c) {", g.set(t.Chars))
+		g.printf("src.Advance(1)")
+		g.printf("} else {")
+		g.printf("return ipFail")
+		g.printf("}")
+	case isa.Any:
+		g.printf("if !src.Advance(%d) {", t.N)
+		g.printf("return ipFail")
+		g.printf("}")
+	case isa.AnyRune:
+		g.printf("if _, n, ok := src.PeekRune(); ok {")
+		g.printf("src.Advance(n)")
+		g.printf("} else {")
+		g.printf("return ipFail")
+		g.printf("}")
+	case isa.RuneSet:
+		g.printf("if r, n, ok := src.PeekRune(); ok && runeSets[%d].Has(r) {", g.runeSet(t.Runes))
+		g.printf("src.Advance(n)")
+		g.printf("} else {")
+		g.printf("return ipFail")
+		g.printf("}")
+	case isa.Literals:
+		g.printf("if !literals%d(src) {", g.literals(t.Strs))
+		g.printf("return ipFail")
+		g.printf("}")
+	case isa.Span:
+		g.printf("for c, ok := src.Peek(); ok && sets[%d].Has(c); c, ok = src.Peek() {", g.set(t.Chars))
+		g.printf("src.Advance(1)")
+		g.printf("}")
+	case isa.Empty:
+		g.Empty = true
+		g.printf("{")
+		g.printf("r1, r2 := rune(-1), rune(-1)")
+		g.printf("if b, ok := src.PeekBefore(); ok {")
+		g.printf("r1 = rune(b)")
+		g.printf("}")
+		g.printf("if b, ok := src.Peek(); ok {")
+		g.printf("r2 = rune(b)")
+		g.printf("}")
+		g.printf("if syntax.EmptyOpContext(r1, r2)&%d == 0 {", uint8(t.Op))
+		g.printf("return ipFail")
+		g.printf("}")
+		g.printf("}")
+	case isa.Jump:
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+	case isa.Choice:
+		g.printf("st.push(stBtrack, 0, stackBacktrack{%d, src.Pos()}, stackMemo{})", g.lbl(t.Lbl))
+	case isa.Call:
+		g.printf("st.push(stRet, %d, stackBacktrack{%d, src.Pos()}, stackMemo{})", next, g.lbl(t.Lbl))
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+	case isa.LRCall:
+		lbl := g.lbl(t.Lbl)
+		g.printf("if seed, ok := p.lrtbl[lrKey{%d, src.Pos()}]; ok {", lbl)
+		g.printf("// recursive call: use the current seed")
+		g.printf("if seed.end == -1 {")
+		g.printf("return ipFail")
+		g.printf("}")
+		g.printf("st.addCapt(seed.capt...)")
+		g.printf("src.SeekTo(seed.end)")
+		g.printf("ip = %d", next)
+		g.printf("} else {")
+		g.printf("if p.lrtbl == nil {")
+		g.printf("p.lrtbl = make(map[lrKey]*lrSeed)")
+		g.printf("}")
+		g.printf("p.lrtbl[lrKey{%d, src.Pos()}] = &lrSeed{end: -1}", lbl)
+		g.printf("st.push(stLR, %d, stackBacktrack{%d, src.Pos()}, stackMemo{})", next, lbl)
+		g.printf("p.lrdepth++")
+		g.printf("ip = %d", lbl)
+		g.printf("}")
+		g.printf("continue")
+	case isa.Commit:
+		g.printf("if st.pop(true) == nil {")
+		g.printf("return ipAbort")
+		g.printf("}")
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+	case isa.Return:
+		if g.LR {
+			g.printf("if ent := st.peek(); ent != nil && ent.stype == stLR {")
+			g.printf("key := lrKey{ent.btrack.ip, ent.btrack.off}")
+			g.printf("seed := p.lrtbl[key]")
+			g.printf("if src.Pos() > seed.end {")
+			g.printf("// the rule made progress: grow the seed by entering the rule again.")
+			g.printf("seed.end = src.Pos()")
+			g.printf("seed.capt = ent.capt[:len(ent.capt):len(ent.capt)]")
+			g.printf("ent.capt = nil")
+			g.printf("src.SeekTo(ent.btrack.off)")
+			g.printf("ip = ent.btrack.ip")
+			g.printf("} else {")
+			g.printf("// no progress: the previous seed is the result.")
+			g.printf("st.pop(false)")
+			g.printf("p.lrdepth--")
+			g.printf("delete(p.lrtbl, key)")
+			g.printf("st.addCapt(seed.capt...)")
+			g.printf("src.SeekTo(seed.end)")
+			g.printf("ip = ent.ret")
+			g.printf("}")
+			g.printf("continue")
+			g.printf("}")
+		}
+		g.printf("if ent := st.pop(true); ent != nil && ent.stype == stRet {")
+		g.printf("ip = ent.ret")
+		g.printf("continue")
+		g.printf("}")
+		g.printf("return ipAbort")
+	case isa.Fail:
+		g.printf("return ipFail")
+	case isa.PartialCommit:
+		g.printf("if ent := st.peek(); ent != nil && ent.stype == stBtrack {")
+		g.printf("ent.btrack.off = src.Pos()")
+		g.printf("st.propCapt()")
+		g.printf("ent.capt = nil")
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+		g.printf("}")
+		g.printf("return ipAbort")
+	case isa.BackCommit:
+		g.printf("if ent := st.pop(true); ent != nil && ent.stype == stBtrack {")
+		g.printf("src.SeekTo(ent.btrack.off)")
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+		g.printf("}")
+		g.printf("return ipAbort")
+	case isa.FailTwice:
+		g.printf("st.pop(false)")
+		g.printf("return ipFail")
+	case isa.TestChar:
+		g.printf("if c, ok := src.Peek(); ok && c == %d {", t.Byte)
+		g.printf("st.push(stBtrack, 0, stackBacktrack{%d, src.Pos()}, stackMemo{})", g.lbl(t.Lbl))
+		g.printf("src.Advance(1)")
+		g.testElse(t.Lbl)
+	case isa.TestCharNoChoice:
+		g.printf("if c, ok := src.Peek(); ok && c == %d {", t.Byte)
+		g.printf("src.Advance(1)")
+		g.testElse(t.Lbl)
+	case isa.TestSet:
+		g.printf("if c, ok := src.Peek(); ok && sets[%d].Has(c) {", g.set(t.Chars))
+		g.printf("st.push(stBtrack, 0, stackBacktrack{%d, src.Pos()}, stackMemo{})", g.lbl(t.Lbl))
+		g.printf("src.Advance(1)")
+		g.testElse(t.Lbl)
+	case isa.TestSetNoChoice:
+		g.printf("if c, ok := src.Peek(); ok && sets[%d].Has(c) {", g.set(t.Chars))
+		g.printf("src.Advance(1)")
+		g.testElse(t.Lbl)
+	case isa.PeekSet:
+		g.printf("if c, ok := src.Peek(); !ok || !sets[%d].Has(c) {", g.set(t.Chars))
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+		g.printf("}")
+	case isa.TestAny:
+		g.printf("if pos := src.Pos(); src.Advance(%d) {", t.N)
+		g.printf("st.push(stBtrack, 0, stackBacktrack{%d, pos}, stackMemo{})", g.lbl(t.Lbl))
+		g.testElse(t.Lbl)
+	case isa.CaptureBegin:
+		g.addCapName(t.Id, t.Name)
+		g.printf("st.push(stCapt, 0, stackBacktrack{}, stackMemo{id: %d, pos: src.Pos()})", t.Id)
+	case isa.CaptureLate:
+		g.addCapName(t.Id, t.Name)
+		g.printf("st.push(stCapt, 0, stackBacktrack{}, stackMemo{id: %d, pos: src.Pos() - %d})", t.Id, t.Back)
+	case isa.CaptureFull:
+		g.addCapName(t.Id, t.Name)
+		g.printf("st.addCapt(memo.NewCaptureNode(%d, src.Pos()-%d, %d, nil))", t.Id, t.Back, t.Back)
+	case isa.CaptureEnd:
+		g.printf("if ent := st.pop(false); ent != nil && ent.stype == stCapt {")
+		g.printf("st.addCapt(memo.%s(ent.memo.id, ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt))", g.captureFunc("NewCaptureNode"))
+		g.printf("} else {")
+		g.printf("return ipAbort")
+		g.printf("}")
+	case isa.RepeatOpen:
+		g.printf("st.push(stRepeat, 0, stackBacktrack{}, stackMemo{count: %d, pos: src.Pos()})", t.N)
+	case isa.RepeatNext:
+		g.printf("if ent := st.peek(); ent == nil || ent.stype != stRepeat {")
+		g.printf("return ipAbort")
+		g.printf("} else if ent.memo.count--; ent.memo.count > 0 {")
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+		g.printf("}")
+		g.printf("st.pop(true)")
+	case isa.RepeatClose:
+		g.printf("if ent := st.pop(true); ent == nil || ent.stype != stRepeat {")
+		g.printf("return ipAbort")
+		g.printf("}")
+	case isa.Catch:
+		g.Throw = true
+		g.printf("st.push(stCatch, %d, stackBacktrack{%d, src.Pos()}, stackMemo{})", g.catch(t.Labels), g.lbl(t.Lbl))
+	case isa.Throw:
+		g.Throw = true
+		g.printf("p.label = %d", g.label(t.Label))
+		g.printf("p.recovery = -1")
+		g.printf("return ipThrow")
+	case isa.ThrowRecover:
+		g.Throw = true
+		g.printf("p.label = %d", g.label(t.Label))
+		g.printf("p.recovery = %d", g.lbl(t.Lbl))
+		g.printf("p.ret = %d", next)
+		g.printf("return ipThrow")
+	case isa.End:
+		g.printf("p.success = %t", !t.Fail)
+		g.printf("return ipEnd")
+	case isa.MemoOpen:
+		g.Memo = true
+		g.printf("if ment, ok := p.memtbl.Get(%d, src.Pos()); ok {", t.Id)
+		g.printf("if ment.Length() == -1 {")
+		g.printf("return ipFail")
+		g.printf("}")
+		g.printf("if capt := ment.Captures(); capt != nil {")
+		g.printf("st.addCapt(capt...)")
+		g.printf("}")
+		g.printf("src.Advance(ment.Length())")
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+		g.printf("}")
+		g.printf("st.push(stMemo, 0, stackBacktrack{}, stackMemo{id: %d, pos: src.Pos()})", t.Id)
+	case isa.MemoClose:
+		g.Memo = true
+		g.printf("if ent := st.pop(true); ent != nil && ent.stype == stMemo {")
+		g.memoize("ent", "p.memoize(ent.memo.id, ent.memo.pos, src.Pos()-ent.memo.pos, 1, ent.capt)")
+		g.printf("} else {")
+		g.printf("return ipAbort")
+		g.printf("}")
+	case isa.MemoTreeOpen:
+		g.Memo = true
+		g.printf("if ment, ok := p.memtbl.Get(%d, src.Pos()); ok {", t.Id)
+		g.printf("if ment.Length() == -1 {")
+		g.printf("return ipFail")
+		g.printf("}")
+		g.printf("st.push(stMemoTree, 0, stackBacktrack{}, stackMemo{id: %d, pos: src.Pos(), count: ment.Count()})", t.Id)
+		g.printf("if capt := ment.Captures(); capt != nil {")
+		g.printf("st.addCapt(capt...)")
+		g.printf("}")
+		g.printf("src.Advance(ment.Length())")
+		g.printf("src.Peek()")
+		g.printf("ip = %d", g.lbl(t.Lbl))
+		g.printf("continue")
+		g.printf("}")
+		g.printf("st.push(stMemoTree, 0, stackBacktrack{}, stackMemo{id: %d, pos: src.Pos()})", t.Id)
+	case isa.MemoTreeClose:
+		g.printf("for p := st.peek(); p != nil && p.stype == stMemoTree && p.memo.id == %d; p = st.peek() {", t.Id)
+		g.printf("st.pop(true)")
+		g.printf("}")
+	case isa.MemoTreeInsert:
+		g.Memo = true
+		g.printf("if ent := st.peek(); ent != nil && ent.stype == stMemoTree {")
+		g.printf("ent.memo.count++")
+		g.memoize("ent", "p.memoize(ent.memo.id, ent.memo.pos, src.Pos()-ent.memo.pos, ent.memo.count, ent.capt)")
+		g.printf("} else {")
+		g.printf("return ipAbort")
+		g.printf("}")
+	case isa.MemoTree:
+		g.Memo = true
+		g.printf("for seen, accum := 0, 0; ; {")
+		g.printf("top := st.peekn(seen)")
+		g.printf("next := st.peekn(seen + 1)")
+		g.printf("if top == nil || next == nil || top.stype != stMemoTree || next.stype != stMemoTree {")
+		g.printf("break")
+		g.printf("}")
+		g.printf("seen++")
+		g.printf("accum += top.memo.count")
+		g.printf("if accum < next.memo.count {")
+		g.printf("continue")
+		g.printf("}")
+		g.printf("for i := 0; i < seen-1; i++ {")
+		g.printf("st.pop(true)")
+		g.printf("}")
+		g.printf("ent := st.pop(false) // next is now top of stack")
+		g.printf("if len(ent.capt) > 0 {")
+		g.printf("st.addCapt(memo.%s(ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt))", g.captureFunc("NewCaptureDummy"))
+		g.printf("}")
+		g.printf("next.memo.count = accum + next.memo.count")
+		g.memoize("next", "p.memoize(next.memo.id, next.memo.pos, src.Pos()-next.memo.pos, next.memo.count, next.capt)")
+		g.printf("accum = 0")
+		g.printf("seen = 0")
+		g.printf("}")
+	case isa.CheckBegin:
+		g.printf("st.push(stCheck, 0, stackBacktrack{}, stackMemo{id: %d, count: %d, pos: src.Pos()})", t.Id, t.Flag)
+	case isa.CheckEnd:
+		if isBackRef(t.Checker) {
+			g.printf("if ent := st.pop(false); ent == nil || ent.stype != stCheck {")
+			g.printf("return ipAbort")
+			g.printf("} else if n := st.backRef(ent, src); n == -1 {")
+			g.printf("return ipFail")
+			g.printf("} else {")
+			g.printf("src.Advance(n)")
+			g.printf("}")
+			break
+		}
+		checker, err := g.checker(t.Checker)
+		if err != nil {
+			return err
+		}
+		g.printf("if ent := st.pop(true); ent == nil || ent.stype != stCheck {")
+		g.printf("return ipAbort")
+		g.printf("} else if n := checkers[%d].Check(src.Slice(ent.memo.pos, src.Pos()), src, ent.memo.id, ent.memo.count); n == -1 {", checker)
+		g.printf("return ipFail")
+		g.printf("} else {")
+		g.printf("src.Advance(n)")
+		g.printf("}")
+	case isa.Error:
+		g.printf("p.errs = append(p.errs, ParseError{Pos: src.Pos(), Message: %q})", t.Message)
+	default:
+		return fmt.Errorf("invalid instruction during generation: %v", t)
+	}
+	return nil
+}
+
+// memoize writes the call that memoizes the result of the stack entry ent,
+// which is skipped if the result depends on a symbol defined before it.
+func (g *generator) memoize(ent, call string) {
+	if g.BackRef {
+		g.printf("if !%s.memo.external {", ent)
+		g.printf("%s", call)
+		g.printf("}")
+		return
+	}
+	g.printf("%s", call)
+}
+
+// captureFunc returns the name of the memo function that builds captures with
+// the constructor fn, which examines the children for symbol definitions if
+// the program has back-references.
+func (g *generator) captureFunc(fn string) string {
+	if g.BackRef {
+		return fn + "Symbols"
+	}
+	return fn
+}
+
+// testElse ends a test instruction, which jumps to l if the test fails.
+func (g *generator) testElse(l isa.Label) {
+	g.printf("} else {")
+	g.printf("ip = %d", g.lbl(l))
+	g.printf("continue")
+	g.printf("}")
+}
+
+// Adds the set to the list of charsets, and returns its index.
+func (g *generator) set(set charset.Set) int {
+	for i, s := range g.Sets {
+		if s == set {
+			return i
+		}
+	}
+	g.Sets = append(g.Sets, set)
+	return len(g.Sets) - 1
+}
+
+func (g *generator) runeSet(set charset.RuneSet) int {
+	for i, s := range g.RuneSets {
+		if s.Equal(set) {
+			return i
+		}
+	}
+	g.RuneSets = append(g.RuneSets, set)
+	return len(g.RuneSets) - 1
+}
+
+// Adds the function that matches the longest of strs to the list of
+// literals, and returns its index. The function is a tree of switches over
+// the next byte, with one level for each byte of the strings.
+func (g *generator) literals(strs []string) int {
+	sorted := append([]string(nil), strs...)
+	sort.Strings(sorted)
+
+	var b strings.Builder
+	b.WriteString("start, end := src.Pos(), -1\n")
+	var walk func(strs []string, depth int)
+	walk = func(strs []string, depth int) {
+		for len(strs) > 0 && len(strs[0]) == depth {
+			b.WriteString("end = src.Pos()\n")
+			strs = strs[1:]
+		}
+		if len(strs) == 0 {
+			return
+		}
+		b.WriteString("if c, ok := src.Peek(); ok {\n")
+		b.WriteString("switch c {\n")
+		for i := 0; i < len(strs); {
+			c := strs[i][depth]
+			j := i + 1
+			for j < len(strs) && strs[j][depth] == c {
+				j++
+			}
+			fmt.Fprintf(&b, "case %d:\n", c)
+			b.WriteString("src.Advance(1)\n")
+			walk(strs[i:j], depth+1)
+			i = j
+		}
+		b.WriteString("}\n")
+		b.WriteString("}\n")
+	}
+	walk(sorted, 0)
+	b.WriteString("if end == -1 {\n")
+	b.WriteString("src.SeekTo(start)\n")
+	b.WriteString("return false\n")
+	b.WriteString("}\n")
+	b.WriteString("src.SeekTo(end)\n")
+	b.WriteString("return true\n")
+
+	code := b.String()
+	for i, l := range g.Literals {
+		if l == code {
+			return i
+		}
+	}
+	g.Literals = append(g.Literals, code)
+	return len(g.Literals) - 1
+}
+
+func (g *generator) label(label string) int {
+	for i, l := range g.Labels {
+		if l == label {
+			return i
+		}
+	}
+	g.Labels = append(g.Labels, label)
+	return len(g.Labels) - 1
+}
+
+func (g *generator) catch(labels []string) int {
+	set := make([]int, 0, len(labels))
+	for _, l := range labels {
+		set = append(set, g.label(l))
+	}
+	g.Catches = append(g.Catches, set)
+	return len(g.Catches) - 1
+}
+
+func (g *generator) addCapName(id int, name string) {
+	if name != "" {
+		g.CapNames[id] = name
+	}
+}
+
+// Adds the Go expression that creates the checker to the list of checkers,
+// and returns its index. Instructions that share a checker share it in the
+// generated code too.
+func (g *generator) checker(c isa.Checker) (int, error) {
+	key := reflect.ValueOf(c).Pointer()
+	if i, ok := g.checkerIds[key]; ok {
+		return i, nil
+	}
+
+	var expr string
+	switch t := c.(type) {
+	case isa.MapChecker:
+		strs := make([]string, 0, len(t))
+		for s := range t {
+			strs = append(strs, fmt.Sprintf("%q", s))
+		}
+		sort.Strings(strs)
+		expr = fmt.Sprintf("isa.NewMapChecker([]string{%s})", strings.Join(strs, ", "))
+	default:
+		return 0, fmt.Errorf("checker %T cannot be generated", c)
+	}
+	g.Checkers = append(g.Checkers, expr)
+	g.checkerIds[key] = len(g.Checkers) - 1
+	return len(g.Checkers) - 1, nil
+}
+
+func isBackRef(c isa.Checker) bool {
+	switch c.(type) {
+	case isa.BackReference, *isa.BackReference:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp/syntax"
+	"strings"
+	"testing"
+	"text/template"
+	"unicode"
+
+	"github.com/zyedidia/gpeg/bench"
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/rxconv"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// A diffParser is a pattern that is both generated and run in the VM on a
+// list of cases.
+type diffParser struct {
+	name  string
+	patt  Pattern
+	cases []diffCase
+}
+
+// A diffCase is a subject that is parsed once, and then parsed again with the
+// same memoization table after each edit.
+type diffCase struct {
+	Parser string
+	Input  string
+	Edits  []diffEdit
+}
+
+type diffEdit struct {
+	Start, End int
+	Text       string
+}
+
+// harness is the main package that runs the cases with the generated
+// parsers. Its run and dump functions must stay the same as the ones below.
+const harness = `package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/vm"
+{{range .}}
+	"gentest/{{.}}"
+{{- end}}
+)
+
+type execFunc func(io.ReaderAt, memo.Table) (bool, int, *memo.Capture, []vm.ParseError)
+
+var parsers = map[string]execFunc{
+{{- range .}}
+	"{{.}}": {{.}}.Exec,
+{{- end}}
+}
+
+type diffCase struct {
+	Parser string
+	Input  string
+	Edits  []struct {
+		Start, End int
+		Text       string
+	}
+}
+
+func main() {
+	var cases []diffCase
+	if err := json.NewDecoder(os.Stdin).Decode(&cases); err != nil {
+		panic(err)
+	}
+	var results [][]string
+	for _, c := range cases {
+		exec := parsers[c.Parser]
+		tbl := memo.NewTreeTable(0)
+		in := []byte(c.Input)
+		res := []string{run(exec, in, tbl)}
+		for _, e := range c.Edits {
+			in = append(in[:e.Start:e.Start], append([]byte(e.Text), in[e.End:]...)...)
+			tbl.ApplyEdit(memo.Edit{Start: e.Start, End: e.End, Len: len(e.Text)})
+			res = append(res, run(exec, in, tbl))
+		}
+		results = append(results, res)
+	}
+	json.NewEncoder(os.Stdout).Encode(results)
+}
+
+func run(exec execFunc, in []byte, tbl memo.Table) string {
+	match, n, capt, errs := exec(bytes.NewReader(in), tbl)
+	return fmt.Sprintf("%t %d %s %v", match, n, dump(capt), errs)
+}
+
+func dump(c *memo.Capture) string {
+	if c == nil {
+		return "nil"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "(%d %d %d", c.Id(), c.Start(), c.End())
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		b.WriteString(" ")
+		b.WriteString(dump(ch))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+`
+
+func run(code vm.Code, in []byte, tbl memo.Table) string {
+	match, n, capt, errs, _ := code.Exec(bytes.NewReader(in), tbl)
+	return fmt.Sprintf("%t %d %s %v", match, n, dump(capt), errs)
+}
+
+func dump(c *memo.Capture) string {
+	if c == nil {
+		return "nil"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "(%d %d %d", c.Id(), c.Start(), c.End())
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		b.WriteString(" ")
+		b.WriteString(dump(ch))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func readFile(t *testing.T, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join("..", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func grammar(t *testing.T, name string) Pattern {
+	return re.MustCompileCap(readFile(t, "grammars/"+name+".peg"), make(map[string]int))
+}
+
+// edits returns random edits to in generated in the same way as for the
+// incremental parsing benchmarks.
+func edits(in string, n int) []diffEdit {
+	rand.Seed(42)
+	var edits []diffEdit
+	for _, e := range bench.ToSingleEdits(bench.GenerateEdits([]byte(in), n)) {
+		edits = append(edits, diffEdit{e.Start, e.End, string(e.Text)})
+	}
+	return edits
+}
+
+func cases(inputs ...string) []diffCase {
+	cases := make([]diffCase, len(inputs))
+	for i, in := range inputs {
+		cases[i].Input = in
+	}
+	return cases
+}
+
+// Checks that generated parsers give the same results as the VM. The
+// generated packages are built in a temporary module that replaces gpeg with
+// this repository.
+func TestDiff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building generated parsers is slow")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	java := readFile(t, "testdata/test.java")
+	word, err := rxconv.FromRegexp(`\b[a-z]+ing\b`, syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := isa.NewBackRef()
+	parsers := []diffParser{
+		{"arith", grammar(t, "arith"), cases("1+2*3", "(4-5)/6*(7+8)", "1+", "")},
+		{"json_memo", grammar(t, "json_memo"), []diffCase{
+			{Input: `{"a": [1, 2.5e3, true, null], "b": {"c": "d\n"}}`, Edits: []diffEdit{
+				{7, 8, "10"}, {1, 4, ""}, {0, 0, "["}, {0, 1, ""}, {20, 21, "false"},
+			}},
+			{Input: `[1, 2,]`},
+		}},
+		{"re", grammar(t, "re"), cases(readFile(t, "grammars/java.peg"), "A <- 'a' /", "[a-z]{2,3} //{x} .")},
+		{"java_memo", grammar(t, "java_memo"), []diffCase{
+			{Input: java, Edits: edits(java, 20)},
+		}},
+		{"leftrec", Grammar("Expr", map[string]Pattern{
+			"Expr":   Cap(Or(Concat(NonTerm("Expr"), Set(charset.New([]byte{'+', '-'})), NonTerm("Term")), NonTerm("Term")), 1),
+			"Term":   Cap(Or(Concat(NonTerm("Term"), Set(charset.New([]byte{'*', '/'})), NonTerm("Factor")), NonTerm("Factor")), 2),
+			"Factor": Or(Memo(NonTerm("Number")), Concat(Literal("("), NonTerm("Expr"), Literal(")"))),
+			"Number": Cap(Plus(Set(charset.Range('0', '9'))), 3),
+		}), []diffCase{
+			{Input: "13+(22-15)*4", Edits: []diffEdit{{0, 2, "7"}, {2, 2, "1+"}}},
+			{Input: "10*(43"},
+		}},
+		{"recover", re.MustCompileCap(`
+			List <- Item (',' Item)* !. / ^list
+			Item <- [a-z]+ / ^item
+			item <- (!',' .)*
+			list <- .*
+		`, make(map[string]int)), cases("one,two,three", "one,2,,three", "", "one two")},
+		{"catch", Or(
+			Catch(Concat(Literal("a"), Or(Literal("b"), Throw("noB"))), Literal("ac"), "noB"),
+			Concat(Literal("x"), Throw("x")),
+		), cases("ab", "ac", "ad", "x")},
+		{"backref", Concat(
+			CheckFlags(Plus(Literal("/")), br, 0, int(isa.RefDef)),
+			Star(Concat(Not(CheckFlags(&EmptyNode{}, br, 0, int(isa.RefUse))), Any(1))),
+			CheckFlags(&EmptyNode{}, br, 0, int(isa.RefUse)),
+		), cases("/// hello world ///", "/// hello world //")},
+		{"xml", re.MustCompileCap(`
+			Elem <- '<' %tag:Name '>' %( {{Elem}}* ) '</' =tag '>'
+			Name <- [a-z]+
+		`, nil), []diffCase{
+			{Input: "<a><b></b><c></c></a>", Edits: []diffEdit{
+				{1, 2, "x"}, {20, 21, "x"}, {4, 5, "c"}, {14, 15, "b"},
+			}},
+			{Input: "<a><b></a></b>"},
+		}},
+		{"dispatch", re.MustCompileCap(`
+			S    <- (Stmt / Expr ';')* !.
+			Stmt <- 'if' Expr '{' S '}' / 'x' '=' Expr ';'
+			Expr <- [0-9]+ / '(' Expr ')'
+		`, make(map[string]int)), cases("if1{x=(2);}3;", "if(1{}", "x=;", "")},
+		{"repeat", Concat(Cap(RepeatRange(Literal("ab"), 2, 4), 1), Repeat(Set(charset.Range('0', '9')), 3)), cases("abab123", "ababababab1234", "ab123", "abab12")},
+		{"regex", Search(Cap(word, 1)), cases("a string of things", "nothing", "ringing bells", "ing")},
+		{"unicode", Star(Or(
+			Cap(Plus(RuneSet(charset.FromTable(unicode.L))), 1),
+			AnyRune(),
+		)), cases("héllo, wörld", "日本語 abc", "ÿ€", "")},
+	}
+
+	dir := t.TempDir()
+	mod := fmt.Sprintf("module gentest\n\ngo 1.16\n\nrequire github.com/zyedidia/gpeg v0.0.0\n\nreplace github.com/zyedidia/gpeg => %s\n", root)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	var all []diffCase
+	var want [][]string
+	for _, p := range parsers {
+		prog := MustCompile(p.patt)
+
+		var buf bytes.Buffer
+		if err := GenerateProgram(&buf, p.name, prog); err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, p.name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, p.name, p.name+".go"), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, p.name)
+
+		code := vm.Encode(prog)
+		for _, c := range p.cases {
+			c.Parser = p.name
+			all = append(all, c)
+
+			tbl := memo.NewTreeTable(0)
+			in := []byte(c.Input)
+			res := []string{run(code, in, tbl)}
+			for _, e := range c.Edits {
+				in = append(in[:e.Start:e.Start], append([]byte(e.Text), in[e.End:]...)...)
+				tbl.ApplyEdit(memo.Edit{Start: e.Start, End: e.End, Len: len(e.Text)})
+				res = append(res, run(code, in, tbl))
+			}
+			want = append(want, res)
+		}
+	}
+
+	var main bytes.Buffer
+	if err := template.Must(template.New("harness").Parse(harness)).Execute(&main, names); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), main.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := json.Marshal(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running generated parsers: %v\n%s", err, stderr.String())
+	}
+
+	var got [][]string
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, expected %d", len(got), len(want))
+	}
+	for i, c := range all {
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Errorf("%s: case %d, parse %d:\ngenerated: %.200s\nvm:        %.200s", c.Parser, i, j, got[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+// Generated code must not depend on the order in which maps are iterated.
+func TestDeterministic(t *testing.T) {
+	prog := MustCompile(grammar(t, "java_memo"))
+	var first []byte
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		if err := GenerateProgram(&buf, "java", prog); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && !bytes.Equal(buf.Bytes(), first) {
+			t.Fatal("generated code differs between runs")
+		}
+		first = buf.Bytes()
+	}
+}
@@ -0,0 +1,486 @@
+package gen
+
+import "text/template"
+
+// parser is the template for a generated package. The code that does not
+// depend on the program is a copy of the stack and the failure handling of
+// the VM. The instructions are split into blocks, one function each, and
+// exec dispatches between them whenever control leaves a block. Sections for
+// features that the program does not use are left out of the output.
+var parser = template.Must(template.New("parser").Parse(`// Code generated by gpeg gen. DO NOT EDIT.
+
+// Package {{.Package}} implements a parser generated from a grammar.
+package {{.Package}}
+
+import (
+	"io"
+{{- if .Empty}}
+	"regexp/syntax"
+{{- end}}
+{{if or .Sets .RuneSets}}
+	"github.com/zyedidia/gpeg/charset"
+{{- end}}
+	"github.com/zyedidia/gpeg/input"
+{{- if or .Checkers .BackRef}}
+	"github.com/zyedidia/gpeg/isa"
+{{- end}}
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// ParseError is an error reported by an error or throw instruction.
+type ParseError = vm.ParseError
+
+// Exec parses the subject in r. It returns whether the parse was a match, the
+// last position in the subject that was matched, and any captures that were
+// created. Results are memoized in memtbl.
+func Exec(r io.ReaderAt, memtbl memo.Table) (bool, int, *memo.Capture, []ParseError) {
+	return exec(input.NewInput(r), memtbl)
+}
+
+// Names maps the capture IDs of the parser to their names.
+var Names memo.Namer = namer{}
+
+type namer struct{}
+
+func (namer) CaptureName(id int) (string, bool) {
+	name, ok := capNames[id]
+	return name, ok
+}
+
+func (namer) CaptureId(name string) (int, bool) {
+	id, ok := capIds[name]
+	return id, ok
+}
+
+var capNames = map[int]string{
+{{- range $id, $name := .CapNames}}
+	{{$id}}: {{printf "%q" $name}},
+{{- end}}
+}
+
+var capIds = map[string]int{
+{{- range $name, $id := .CapIds}}
+	{{printf "%q" $name}}: {{$id}},
+{{- end}}
+}
+{{if .Sets}}
+var sets = [...]charset.Set{
+{{- range .Sets}}
+	{Bits: [4]uint64{ {{- range $i, $b := .Bits}}{{if $i}}, {{end}}{{printf "%#x" $b}}{{end -}} }},
+{{- end}}
+}
+{{end}}
+{{- if .RuneSets}}
+var runeSets = [...]charset.RuneSet{
+{{- range .RuneSets}}
+	{Ranges: []charset.RuneRange{ {{- range $i, $r := .Ranges}}{{if $i}}, {{end}}{ {{- printf "%#x" $r.Lo}}, {{printf "%#x" $r.Hi -}} }{{end -}} }},
+{{- end}}
+}
+{{end}}
+{{- range $i, $code := .Literals}}
+// Advances src past the longest of a set of strings that it starts with, and
+// returns false if there is none.
+func literals{{$i}}(src *input.Input) bool {
+{{$code -}}
+}
+{{end}}
+{{- if .Checkers}}
+var checkers = [...]isa.Checker{
+{{- range .Checkers}}
+	{{.}},
+{{- end}}
+}
+{{end}}
+{{- if .Throw}}
+var labels = [...]string{
+{{- range .Labels}}
+	{{printf "%q" .}},
+{{- end}}
+}
+
+var catchSets = [...][]int{
+{{- range .Catches}}
+	{ {{- range $i, $l := .}}{{if $i}}, {{end}}{{$l}}{{end -}} },
+{{- end}}
+}
+
+// Returns true if the set of labels at index set contains label.
+func catches(set, label int) bool {
+	for _, l := range catchSets[set] {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+{{end}}
+type stack struct {
+	entries []stackEntry
+	capt    []*memo.Capture
+}
+
+func (s *stack) addCapt(capt ...*memo.Capture) {
+	if len(s.entries) == 0 {
+		s.capt = append(s.capt, capt...)
+	} else {
+		s.entries[len(s.entries)-1].addCapt(capt)
+	}
+}
+
+func (s *stack) propCapt() {
+	if len(s.entries) == 0 {
+		return
+	}
+
+	top := s.entries[len(s.entries)-1]
+	if len(top.capt) > 0 {
+		if len(s.entries) == 1 {
+			s.capt = append(s.capt, top.capt...)
+		} else {
+			s.entries[len(s.entries)-2].addCapt(top.capt)
+		}
+	}
+}
+
+const (
+	stRet = iota
+	stBtrack
+	stMemo
+	stMemoTree
+	stCapt
+	stCheck
+	stRepeat
+	stLR
+	stCatch
+)
+
+type stackEntry struct {
+	stype  byte
+	ret    int
+	btrack stackBacktrack
+	memo   stackMemo
+
+	capt []*memo.Capture
+}
+
+func (se *stackEntry) addCapt(capt []*memo.Capture) {
+	if len(capt) == 0 {
+		return
+	}
+	if len(se.capt) == 0 {
+		se.capt = capt
+	} else {
+		se.capt = append(se.capt, capt...)
+	}
+}
+
+type stackBacktrack struct {
+	ip  int
+	off int
+}
+
+type stackMemo struct {
+	id    int
+	pos   int
+	count int
+{{- if .BackRef}}
+
+	external bool
+{{- end}}
+}
+
+type lrKey struct {
+	ip  int
+	pos int
+}
+
+type lrSeed struct {
+	end  int
+	capt []*memo.Capture
+}
+
+func (s *stack) push(stype byte, ret int, b stackBacktrack, m stackMemo) {
+	s.entries = append(s.entries, stackEntry{
+		stype:  stype,
+		ret:    ret,
+		btrack: b,
+		memo:   m,
+	})
+}
+
+func (s *stack) pop(propagate bool) *stackEntry {
+	if len(s.entries) == 0 {
+		return nil
+	}
+
+	ret := &s.entries[len(s.entries)-1]
+	s.entries = s.entries[:len(s.entries)-1]
+	if propagate && ret.capt != nil {
+		s.addCapt(ret.capt...)
+	}
+	return ret
+}
+
+func (s *stack) peek() *stackEntry {
+	return s.peekn(0)
+}
+
+func (s *stack) peekn(n int) *stackEntry {
+	if len(s.entries) <= n {
+		return nil
+	}
+	return &s.entries[len(s.entries)-n-1]
+}
+
+{{- if .BackRef}}
+func (s *stack) backRef(ent *stackEntry, src *input.Input) int {
+	switch isa.RefKind(ent.memo.count) {
+	case isa.RefDef:
+		s.addCapt(memo.NewCaptureSymbol(ent.memo.id, ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt))
+	case isa.RefBlock:
+		if len(ent.capt) > 0 {
+			s.addCapt(memo.NewCaptureScope(ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt))
+		}
+	case isa.RefUse:
+		s.addCapt(ent.capt...)
+		sym := s.lookup(ent.memo.id)
+		if sym == nil {
+			return -1
+		}
+		start := src.Pos()
+		want := src.Slice(sym.Start(), sym.End())
+		for _, b := range want {
+			if c, ok := src.Peek(); !ok || c != b {
+				src.SeekTo(start)
+				return -1
+			}
+			src.Advance(1)
+		}
+		src.SeekTo(start)
+		return len(want)
+	}
+	return 0
+}
+
+func (s *stack) lookup(id int) *memo.Capture {
+	i := len(s.entries) - 1
+	var sym *memo.Capture
+	for ; i >= 0 && sym == nil; i-- {
+		sym = memo.FindSymbol(s.entries[i].capt, id)
+	}
+	if sym == nil {
+		sym = memo.FindSymbol(s.capt, id)
+	} else {
+		i++
+	}
+	for i++; i < len(s.entries); i++ {
+		if t := s.entries[i].stype; t == stMemo || t == stMemoTree {
+			s.entries[i].memo.external = true
+		}
+	}
+	return sym
+}
+{{end}}
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Values returned by blocks in place of an instruction index.
+const (
+	// the program ended
+	ipEnd = -1 - iota
+	// a pattern failed
+	ipFail
+	// a labeled failure was thrown
+	ipThrow
+	// the match failed, or the program is malformed
+	ipAbort
+)
+
+type parser struct {
+	src    *input.Input
+	memtbl memo.Table
+	st     stack
+{{- if .LR}}
+
+	// seeds for the calls to left-recursive rules that are in progress.
+	lrtbl map[lrKey]*lrSeed
+	// number of left-recursive calls on the stack.
+	lrdepth int
+{{- end}}
+{{- if .Throw}}
+
+	// the label, recovery rule and return address of a labeled failure
+	// being thrown.
+	label, recovery, ret int
+{{- end}}
+
+	success bool
+	errs    []ParseError
+}
+
+func exec(src *input.Input, memtbl memo.Table) (bool, int, *memo.Capture, []ParseError) {
+	p := &parser{
+		src:    src,
+		memtbl: memtbl,
+		st: stack{
+			entries: make([]stackEntry, 0, 4),
+			capt:    make([]*memo.Capture, 0),
+		},
+	}
+
+	ip := 0
+	for {
+		switch {
+		case ip >= 0:
+			ip = p.run(ip)
+		case ip == ipFail:
+			ip = p.fail()
+{{- if .Throw}}
+		case ip == ipThrow:
+			ip = p.throw()
+{{- end}}
+		case ip == ipEnd:
+			return p.success, src.Pos(), memo.NewCaptureDummy(0, src.Pos(), p.st.capt), p.errs
+		default:
+			return false, src.Pos(), nil, p.errs
+		}
+	}
+}
+
+// blocks holds the function of each block.
+var blocks = [...]func(*parser, int) int{
+{{- range $i, $b := .Blocks}}
+	(*parser).block{{$i}},
+{{- end}}
+}
+
+// blockOf maps the index of each instruction to the block that contains it.
+var blockOf = [...]{{.BlockType}}{ {{- .BlockOf -}} }
+
+// run executes the block that contains the instruction at ip.
+func (p *parser) run(ip int) int {
+	return blocks[blockOf[ip]](p, ip)
+}
+{{range $i, $b := .Blocks}}
+{{- if $b.Rule}}
+// {{$b.Rule}}
+{{- end}}
+func (p *parser) block{{$i}}(ip int) int {
+{{- if $b.Src}}
+	src := p.src
+{{- end}}
+{{- if $b.Stack}}
+	st := &p.st
+{{- end}}
+	for {
+		switch ip {
+{{$b.Code -}}
+		default:
+			return ip
+		}
+	}
+}
+{{end}}
+{{- if .Memo}}
+func (p *parser) memoize(id, pos, mlen, count int, capt []*memo.Capture) {
+{{- if .LR}}
+	if p.lrdepth > 0 {
+		// results computed while a left-recursive rule is being
+		// grown depend on an intermediate seed and cannot be reused.
+		return
+	}
+{{- end}}
+	mexam := max(p.src.Furthest(), p.src.Pos()) - pos + 1
+	p.memtbl.Put(id, pos, mlen, mexam, count, capt)
+}
+{{end}}
+{{- if .Throw}}
+// throw unwinds the stack to the innermost catch entry that handles the
+// thrown label, or calls the recovery rule if there is none.
+func (p *parser) throw() int {
+	st := &p.st
+	for i := len(st.entries) - 1; i >= 0; i-- {
+		if st.entries[i].stype != stCatch || !catches(st.entries[i].ret, p.label) {
+			continue
+		}
+		for len(st.entries) > i+1 {
+			ent := st.pop(false)
+{{- if .LR}}
+			if ent.stype == stLR {
+				p.lrdepth--
+				delete(p.lrtbl, lrKey{ent.btrack.ip, ent.btrack.off})
+			}
+{{- end}}
+			ent.capt = nil
+		}
+		ent := st.pop(false)
+		ent.capt = nil
+		p.src.SeekTo(ent.btrack.off)
+		return ent.btrack.ip
+	}
+
+	p.errs = append(p.errs, ParseError{
+		Pos:     p.src.Pos(),
+		Message: labels[p.label],
+	})
+	if p.recovery == -1 {
+		// nothing handles the label: the match fails
+		return ipAbort
+	}
+	// call the recovery rule in place of the failure
+	st.push(stRet, p.ret, stackBacktrack{p.recovery, p.src.Pos()}, stackMemo{})
+	return p.recovery
+}
+{{end}}
+// fail pops entries off the stack until it finds a backtrack entry to
+// resume from.
+func (p *parser) fail() int {
+	for {
+		ent := p.st.pop(false)
+		if ent == nil {
+			// match failed
+			return ipAbort
+		}
+
+		switch ent.stype {
+		case stBtrack:
+			p.src.SeekTo(ent.btrack.off)
+			ent.capt = nil
+			return ent.btrack.ip
+{{- if .Memo}}
+		case stMemo:
+			// mark this position in the memo table as a failed match
+{{- if .BackRef}}
+			if !ent.memo.external {
+				p.memoize(ent.memo.id, ent.memo.pos, -1, 0, nil)
+			}
+{{- else}}
+			p.memoize(ent.memo.id, ent.memo.pos, -1, 0, nil)
+{{- end}}
+			ent.capt = nil
+{{- end}}
+{{- if .LR}}
+		case stLR:
+			p.lrdepth--
+			key := lrKey{ent.btrack.ip, ent.btrack.off}
+			seed := p.lrtbl[key]
+			delete(p.lrtbl, key)
+			ent.capt = nil
+			if seed.end != -1 {
+				// growing the seed failed: the previous seed is the result.
+				p.st.addCapt(seed.capt...)
+				p.src.SeekTo(seed.end)
+				return ent.ret
+			}
+{{- end}}
+		default:
+			ent.capt = nil
+		}
+	}
+}
+`))
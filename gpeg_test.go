@@ -24,14 +24,17 @@ type PatternTest struct {
 
 func check(p Pattern, tests []PatternTest, t *testing.T) {
 	code := vm.Encode(MustCompile(p))
-	for _, tt := range tests {
-		name := tt.in[:min(10, len(tt.in))]
-		t.Run(name, func(t *testing.T) {
-			match, off, _, _ := code.Exec(strings.NewReader(tt.in), memo.NoneTable{})
-			if tt.match == -1 && match || tt.match != -1 && !match || tt.match != -1 && tt.match != off {
-				t.Errorf("%s: got: (%t, %d), but expected (%d)\n", tt.in, match, off, tt.match)
-			}
-		})
+	for _, backend := range []vm.Backend{vm.Interpreter, vm.Closures} {
+		code.SetBackend(backend)
+		for _, tt := range tests {
+			name := tt.in[:min(10, len(tt.in))]
+			t.Run(name, func(t *testing.T) {
+				match, off, _, _, _ := code.Exec(strings.NewReader(tt.in), memo.NoneTable{})
+				if tt.match == -1 && match || tt.match != -1 && !match || tt.match != -1 && tt.match != off {
+					t.Errorf("%s: got: (%t, %d), but expected (%d)\n", tt.in, match, off, tt.match)
+				}
+			})
+		}
 	}
 }
 
@@ -124,6 +127,51 @@ func TestRepeat(t *testing.T) {
 	check(p, tests, t)
 }
 
+func TestBoundedRepeat(t *testing.T) {
+	p := Repeat(Literal("ab"), 3)
+	tests := []PatternTest{
+		{"ababab", 6},
+		{"abababab", 6},
+		{"abab", -1},
+	}
+	check(p, tests, t)
+
+	p = RepeatMin(Set(charset.Range('0', '9')), 2)
+	tests = []PatternTest{
+		{"1", -1},
+		{"12", 2},
+		{"12345a", 5},
+	}
+	check(p, tests, t)
+
+	p = Concat(RepeatRange(Literal("a"), 2, 4), Literal("b"))
+	tests = []PatternTest{
+		{"ab", -1},
+		{"aab", 3},
+		{"aaaab", 5},
+		{"aaaaab", -1},
+	}
+	check(p, tests, t)
+
+	// large counts use a counted loop instead of unrolling
+	p = RepeatRange(Or(Literal("x"), Literal("yz")), 100, 200)
+	prog := MustCompile(p)
+	if prog.Size() > 50 {
+		t.Errorf("bounded repetition was unrolled: %d instructions", prog.Size())
+	}
+	tests = []PatternTest{
+		{strings.Repeat("x", 99), -1},
+		{strings.Repeat("yz", 100), 200},
+		{strings.Repeat("x", 150) + "yz", 152},
+		{strings.Repeat("x", 300), 200},
+	}
+	check(p, tests, t)
+
+	if _, err := Compile(RepeatRange(Literal("a"), 3, 2)); err == nil {
+		t.Error("expected an error for invalid repetition bounds")
+	}
+}
+
 func TestPredicate(t *testing.T) {
 	p := Not(Literal("ana"))
 	tests := []PatternTest{
@@ -304,7 +352,7 @@ func BenchmarkBibleSearchFirstEartt(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		match, _, _, _ = code.Exec(bible, memo.NoneTable{})
+		match, _, _, _, _ = code.Exec(bible, memo.NoneTable{})
 	}
 }
 
@@ -314,7 +362,7 @@ func BenchmarkBibleSearchFirstAbram(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		match, _, _, _ = code.Exec(bible, memo.NoneTable{})
+		match, _, _, _, _ = code.Exec(bible, memo.NoneTable{})
 	}
 }
 
@@ -324,7 +372,7 @@ func BenchmarkBibleSearchLastAbram(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		match, _, _, _ = code.Exec(bible, memo.NoneTable{})
+		match, _, _, _, _ = code.Exec(bible, memo.NoneTable{})
 	}
 }
 
@@ -333,7 +381,7 @@ func BenchmarkBibleSearchLastTubalcain(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		match, _, _, _ = code.Exec(bible, memo.NoneTable{})
+		match, _, _, _, _ = code.Exec(bible, memo.NoneTable{})
 	}
 }
 
@@ -343,7 +391,7 @@ func BenchmarkBibleOmegaPattern(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		match, _, _, _ = code.Exec(bible, memo.NoneTable{})
+		match, _, _, _, _ = code.Exec(bible, memo.NoneTable{})
 	}
 }
 
@@ -356,7 +404,7 @@ func BenchmarkBibleOmegaGrammar(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		match, _, _, _ = code.Exec(bible, memo.NoneTable{})
+		match, _, _, _, _ = code.Exec(bible, memo.NoneTable{})
 	}
 }
 
@@ -55,7 +55,7 @@ func TestIncrementalJava(t *testing.T) {
 		code.Exec(r, tbl)
 		// fmt.Println("reparse", time.Since(st), match, off)
 		// st = time.Now()
-		// nmatch, noff, _, _ := code.Exec(r, memo.NoneTable{})
+		// nmatch, noff, _, _, _ := code.Exec(r, memo.NoneTable{})
 		// fmt.Println("full parse", time.Since(st))
 
 		// if match != nmatch || off != noff {
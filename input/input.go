@@ -3,6 +3,7 @@ package input
 
 import (
 	"io"
+	"unicode/utf8"
 )
 
 const bufsz = 4096
@@ -55,6 +56,44 @@ func (i *Input) Peek() (byte, bool) {
 	return i.chunk[i.coff], i.nchunk != 0
 }
 
+// PeekRune decodes the next UTF-8 encoded code point in the stream and
+// returns it with its width in bytes, or 'false' if there are no more bytes.
+// A byte that does not start a valid encoding is returned as
+// utf8.RuneError with width 1.
+func (i *Input) PeekRune() (rune, int, bool) {
+	if i.nchunk == 0 {
+		return 0, 0, false
+	}
+	pos := i.base + i.coff
+	b := i.chunk[i.coff:i.nchunk]
+	if b[0] < utf8.RuneSelf {
+		if pos > i.furthest {
+			i.furthest = pos
+		}
+		return rune(b[0]), 1, true
+	}
+	if !utf8.FullRune(b) {
+		// the encoding continues past the end of the chunk.
+		var buf [utf8.UTFMax]byte
+		n, _ := i.r.ReadAt(buf[:], int64(pos))
+		b = buf[:n]
+	}
+	r, size := utf8.DecodeRune(b)
+	// an invalid encoding may have been detected at any of the bytes that
+	// could belong to it.
+	last := pos + size - 1
+	if r == utf8.RuneError && size == 1 {
+		last = pos + len(b) - 1
+		if len(b) > utf8.UTFMax {
+			last = pos + utf8.UTFMax - 1
+		}
+	}
+	if last > i.furthest {
+		i.furthest = last
+	}
+	return r, size, true
+}
+
 func (i *Input) PeekBefore() (byte, bool) {
 	if i.base+i.coff-1 < 0 {
 		return 0, false
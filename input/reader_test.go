@@ -31,3 +31,33 @@ func TestReaderWrapper(t *testing.T) {
 		t.Errorf("incorrect, n: %v, err: %v", n, err)
 	}
 }
+
+func TestStream(t *testing.T) {
+	s := input.NewStream(bytes.NewBufferString("foo bar baz"))
+	b := make([]byte, 3)
+
+	s.ReadAt(b, 4)
+	if string(b) != "bar" {
+		t.Errorf("want %s, got %s", "bar", string(b))
+	}
+
+	s.Discard(4)
+	if n, err := s.ReadAt(b, 0); n != 0 || err != input.ErrDiscarded {
+		t.Errorf("read discarded data, n: %v, err: %v", n, err)
+	}
+	s.ReadAt(b, 4)
+	if string(b) != "bar" {
+		t.Errorf("want %s, got %s", "bar", string(b))
+	}
+
+	n, err := s.ReadAt(b, 9)
+	if string(b[:n]) != "az" {
+		t.Errorf("want %s, got %s", "az", string(b))
+	}
+	if n != 2 || err != io.EOF {
+		t.Errorf("incorrect, n: %v, err: %v", n, err)
+	}
+	if s.Buffered() != 7 || s.Err() != nil {
+		t.Errorf("incorrect, buffered: %v, err: %v", s.Buffered(), s.Err())
+	}
+}
@@ -0,0 +1,107 @@
+package input
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrDiscarded is returned by Stream.ReadAt for data that has been discarded.
+var ErrDiscarded = errors.New("read of discarded data")
+
+// A Stream implements an io.ReaderAt from an io.Reader like FromReader, but
+// only keeps the data that has not been discarded, so that the memory used
+// for a long input stays bounded as long as the reader of the stream
+// discards what it no longer needs.
+type Stream struct {
+	reader io.Reader
+	// data from base on that has been read and not discarded.
+	buf []byte
+	// position in the stream of the first byte of buf.
+	base int
+	// error returned by the last read, if any.
+	err  error
+	lock sync.Mutex
+}
+
+// NewStream returns a Stream that reads from r.
+func NewStream(r io.Reader) *Stream {
+	return &Stream{
+		reader: r,
+	}
+}
+
+// ReadAt implements the io.ReaderAt interface, reading from the wrapped
+// io.Reader as needed. Reading data before the position passed to Discard
+// returns ErrDiscarded.
+func (s *Stream) ReadAt(b []byte, off int64) (n int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if int(off) < s.base {
+		return 0, ErrDiscarded
+	}
+	start := int(off) - s.base
+	for len(s.buf)-start < len(b) && s.err == nil {
+		if cap(s.buf)-len(s.buf) < bufsz {
+			buf := make([]byte, len(s.buf), 2*cap(s.buf)+bufsz)
+			copy(buf, s.buf)
+			s.buf = buf
+		}
+		n, err := s.reader.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		s.err = err
+	}
+	if start >= len(s.buf) {
+		return 0, s.eof()
+	}
+	n = copy(b, s.buf[start:])
+	if n < len(b) {
+		return n, s.eof()
+	}
+	return n, nil
+}
+
+// eof returns the error to report when the data ends.
+func (s *Stream) eof() error {
+	if s.err == nil {
+		return io.EOF
+	}
+	return s.err
+}
+
+// Discard drops the data before pos. Later reads of that data fail.
+func (s *Stream) Discard(pos int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if pos <= s.base {
+		return
+	}
+	n := pos - s.base
+	if n > len(s.buf) {
+		n = len(s.buf)
+	}
+	// move the data to the front so that the buffer does not grow.
+	s.buf = s.buf[:copy(s.buf, s.buf[n:])]
+	s.base += n
+}
+
+// Buffered returns the number of bytes that have been read and not
+// discarded.
+func (s *Stream) Buffered() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.buf)
+}
+
+// Err returns the error that stopped reading from the wrapped io.Reader, or
+// nil if the reader has not failed or only reached the end of its data.
+func (s *Stream) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
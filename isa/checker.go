@@ -29,38 +29,36 @@ func (m MapChecker) Check(b []byte, src *input.Input, id, flag int) int {
 	return -1
 }
 
+// A RefKind is the flag of a check that uses a BackReference.
 type RefKind uint8
 
 const (
+	// RefDef defines a symbol as the text matched by the checked pattern.
 	RefDef RefKind = iota
+	// RefUse matches the text of the latest visible definition of a symbol.
 	RefUse
+	// RefBlock hides the symbols defined by the checked pattern once it
+	// has matched.
 	RefBlock
 )
 
+// A BackReference is the checker of back-reference checks, whose id is the
+// symbol and whose flag is a RefKind. The VM handles these checks itself:
+// definitions are kept with the captures, so they are undone by
+// backtracking, stored with memoized results and scoped by RefBlock
+// checks, and memoized results that use a symbol defined outside of them
+// are not reused. Check is never called.
 type BackReference struct {
-	Symbols map[int]string
+	// Names are the names of the symbols, by id, for diagnostics.
+	Names map[int]string
 }
 
 func NewBackRef() *BackReference {
 	return &BackReference{
-		Symbols: make(map[int]string),
+		Names: make(map[int]string),
 	}
 }
 
-func (r *BackReference) Check(b []byte, src *input.Input, id, flag int) int {
-	switch RefKind(flag) {
-	case RefDef:
-		r.Symbols[id] = string(b)
-		return 0
-	case RefUse:
-		back := r.Symbols[id]
-		buf := make([]byte, len(back))
-		n, _ := src.ReadAt(buf, int64(src.Pos()))
-		if n == len(buf) && string(buf) == back {
-			return n
-		}
-		return -1
-	case RefBlock:
-	}
+func (r BackReference) Check(b []byte, src *input.Input, id, flag int) int {
 	return 0
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp/syntax"
 	"strconv"
+	"strings"
 
 	"github.com/zyedidia/gpeg/charset"
 )
@@ -23,7 +24,7 @@ func (p Program) Size() int {
 	var sz int
 	for _, i := range p {
 		switch i.(type) {
-		case Label, Nop:
+		case Label, Nop, RuleBegin, NodeBegin, NodeEnd:
 			continue
 		default:
 			sz++
@@ -54,6 +55,29 @@ func NewLabel() Label {
 	}
 }
 
+// RuleBegin marks the start of the code for the grammar rule called Name. It
+// does not generate any code and is only used to keep track of rule names.
+type RuleBegin struct {
+	Name string
+	basic
+}
+
+// NodeBegin marks the start of the code generated for a pattern node whose
+// location in the source of a grammar is known. Like RuleBegin, it does not
+// generate any code and is only used to keep track of where code comes from.
+type NodeBegin struct {
+	// Node is the pattern node that generated the code.
+	Node interface{}
+	// Start and End are the byte offsets of the node in the grammar source.
+	Start, End int
+	basic
+}
+
+// NodeEnd marks the end of the code for the innermost NodeBegin.
+type NodeEnd struct {
+	basic
+}
+
 // Char consumes the next byte of the subject if it matches Byte and
 // fails otherwise.
 type Char struct {
@@ -81,6 +105,18 @@ type Call struct {
 	jump
 }
 
+// LRCall calls the left-recursive rule at Lbl. The first call of a rule at a
+// given position fails any recursive calls made at that same position. Each
+// time the rule then returns after consuming more input than before, the
+// result is saved and the rule is re-entered so that recursive calls at the
+// position return the saved result. Once the rule stops making progress, the
+// longest saved result is returned. This implements the bounded left
+// recursion of Medeiros, Mascarenhas and Ierusalimschy.
+type LRCall struct {
+	Lbl Label
+	jump
+}
+
 // Commit jumps to Lbl and removes the top entry from the stack
 type Commit struct {
 	Lbl Label
@@ -110,6 +146,28 @@ type Any struct {
 	basic
 }
 
+// AnyRune consumes the next UTF-8 encoded code point, or a single byte if
+// the input is not valid UTF-8 at this point, and fails at the end of the
+// input.
+type AnyRune struct {
+	basic
+}
+
+// RuneSet consumes the next UTF-8 encoded code point if it is in the set of
+// code points defined by Runes. A byte that does not start a valid encoding
+// is treated as the code point utf8.RuneError of width 1.
+type RuneSet struct {
+	Runes charset.RuneSet
+	basic
+}
+
+// Literals consumes the longest of the strings Strs that the subject starts
+// with, and fails if there is none.
+type Literals struct {
+	Strs []string
+	basic
+}
+
 // PartialCommit modifies the backtrack entry on the top of the stack to
 // point to the current subject offset, and jumps to Lbl.
 type PartialCommit struct {
@@ -180,6 +238,16 @@ type TestSetNoChoice struct {
 	jump
 }
 
+// PeekSet jumps to Lbl if the next byte is not in the set Chars, without
+// consuming it. No backtrack entry is pushed to the stack. It dispatches
+// between alternatives whose first bytes are disjoint, and when it jumps, the
+// first alternative is reported as failing at the current position.
+type PeekSet struct {
+	Chars charset.Set
+	Lbl   Label
+	jump
+}
+
 // TestAny consumes the next N bytes and jumps to Lbl if that is not possible.
 // If the consumption is possible, a backtrack entry referring to Lbl and
 // the subject position from before consumption is pushed to the stack.
@@ -189,6 +257,25 @@ type TestAny struct {
 	jump
 }
 
+// RepeatOpen pushes a repetition counter initialized to N onto the stack.
+type RepeatOpen struct {
+	N int
+	basic
+}
+
+// RepeatNext decrements the repetition counter on the top of the stack. If
+// the counter is still positive it jumps to Lbl, otherwise the counter is
+// popped from the stack.
+type RepeatNext struct {
+	Lbl Label
+	jump
+}
+
+// RepeatClose pops the repetition counter off the top of the stack.
+type RepeatClose struct {
+	basic
+}
+
 // End immediately completes the pattern as a match.
 type End struct {
 	basic
@@ -239,9 +326,11 @@ type MemoTreeClose struct {
 	basic
 }
 
-// CaptureBegin begins capturing the given ID.
+// CaptureBegin begins capturing the given ID. Name is the optional name of
+// the ID.
 type CaptureBegin struct {
-	Id int
+	Id   int
+	Name string
 	basic
 }
 
@@ -250,6 +339,7 @@ type CaptureBegin struct {
 type CaptureLate struct {
 	Back byte
 	Id   int
+	Name string
 	basic
 }
 
@@ -265,6 +355,7 @@ type CaptureEnd struct {
 type CaptureFull struct {
 	Back byte
 	Id   int
+	Name string
 	basic
 }
 
@@ -288,6 +379,32 @@ type Error struct {
 	Message string
 }
 
+// Throw raises a failure labeled with Label. A labeled failure is not
+// handled by ordinary choices: it unwinds the stack to the innermost Catch
+// entry that handles Label. If there is no such entry the match fails.
+type Throw struct {
+	basic
+	Label string
+}
+
+// ThrowRecover is like Throw, except that if no Catch entry handles Label,
+// the failure is logged as an error and the recovery rule at Lbl is called in
+// place of the failure.
+type ThrowRecover struct {
+	Label string
+	Lbl   Label
+	jump
+}
+
+// Catch pushes a catch entry onto the stack. It behaves like Choice, except
+// that the alternative at Lbl is only taken for failures that are labeled
+// with one of Labels.
+type Catch struct {
+	Lbl    Label
+	Labels []string
+	jump
+}
+
 type basic struct{}
 
 func (b basic) insn() {}
@@ -303,6 +420,21 @@ func (i Label) String() string {
 	return fmt.Sprintf("L%v", i.Id)
 }
 
+// String returns the string representation of this instruction.
+func (i RuleBegin) String() string {
+	return i.Name
+}
+
+// String returns the string representation of this instruction.
+func (i NodeBegin) String() string {
+	return fmt.Sprintf("NodeBegin %d-%d", i.Start, i.End)
+}
+
+// String returns the string representation of this instruction.
+func (i NodeEnd) String() string {
+	return "NodeEnd"
+}
+
 // String returns the string representation of this instruction.
 func (i Char) String() string {
 	return fmt.Sprintf("Char %v", strconv.QuoteRune(rune(i.Byte)))
@@ -323,6 +455,11 @@ func (i Call) String() string {
 	return fmt.Sprintf("Call %v", i.Lbl)
 }
 
+// String returns the string representation of this instruction.
+func (i LRCall) String() string {
+	return fmt.Sprintf("LRCall %v", i.Lbl)
+}
+
 // String returns the string representation of this instruction.
 func (i Commit) String() string {
 	return fmt.Sprintf("Commit %v", i.Lbl)
@@ -348,6 +485,21 @@ func (i Any) String() string {
 	return fmt.Sprintf("Any %v", i.N)
 }
 
+// String returns the string representation of this instruction.
+func (i AnyRune) String() string {
+	return "AnyRune"
+}
+
+// String returns the string representation of this instruction.
+func (i RuneSet) String() string {
+	return fmt.Sprintf("RuneSet %v", i.Runes)
+}
+
+// String returns the string representation of this instruction.
+func (i Literals) String() string {
+	return fmt.Sprintf("Literals %q", i.Strs)
+}
+
 // String returns the string representation of this instruction.
 func (i PartialCommit) String() string {
 	return fmt.Sprintf("PartialCommit %v", i.Lbl)
@@ -388,11 +540,31 @@ func (i TestSetNoChoice) String() string {
 	return fmt.Sprintf("TestSetNoChoice %v %v", i.Chars, i.Lbl)
 }
 
+// String returns the string representation of this instruction.
+func (i PeekSet) String() string {
+	return fmt.Sprintf("PeekSet %v %v", i.Chars, i.Lbl)
+}
+
 // String returns the string representation of this instruction.
 func (i TestAny) String() string {
 	return fmt.Sprintf("TestAny %v %v", i.N, i.Lbl)
 }
 
+// String returns the string representation of this instruction.
+func (i RepeatOpen) String() string {
+	return fmt.Sprintf("RepeatOpen %v", i.N)
+}
+
+// String returns the string representation of this instruction.
+func (i RepeatNext) String() string {
+	return fmt.Sprintf("RepeatNext %v", i.Lbl)
+}
+
+// String returns the string representation of this instruction.
+func (i RepeatClose) String() string {
+	return "RepeatClose"
+}
+
 // String returns the string representation of this instruction.
 func (i End) String() string {
 	var result string
@@ -474,6 +646,21 @@ func (i Error) String() string {
 	return fmt.Sprintf("Error %s", strconv.QuoteToASCII(i.Message))
 }
 
+// String returns the string representation of this instruction.
+func (i Throw) String() string {
+	return fmt.Sprintf("Throw %s", i.Label)
+}
+
+// String returns the string representation of this instruction.
+func (i ThrowRecover) String() string {
+	return fmt.Sprintf("ThrowRecover %s %v", i.Label, i.Lbl)
+}
+
+// String returns the string representation of this instruction.
+func (i Catch) String() string {
+	return fmt.Sprintf("Catch %v {%s}", i.Lbl, strings.Join(i.Labels, ","))
+}
+
 // String returns the string representation of this instruction.
 func (i Empty) String() string {
 	return fmt.Sprintf("Empty %s", emptyToString(i.Op))
@@ -485,8 +672,10 @@ func (p Program) String() string {
 	var last Insn
 	for _, insn := range p {
 		switch insn.(type) {
-		case Nop:
+		case Nop, NodeBegin, NodeEnd:
 			continue
+		case RuleBegin:
+			s += fmt.Sprintf("%v:\n", insn)
 		case Label:
 			if _, ok := last.(Label); ok {
 				s += fmt.Sprintf("\n%v:", insn)
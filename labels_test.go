@@ -0,0 +1,89 @@
+package gpeg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestThrowCatch(t *testing.T) {
+	p := Catch(
+		Concat(Literal("a"), Or(Literal("b"), Throw("noB"))),
+		Literal("ac"),
+		"noB",
+	)
+	tests := []PatternTest{
+		{"ab", 2},
+		{"ac", 2},
+		{"ad", -1},
+		{"x", -1},
+	}
+	check(p, tests, t)
+
+	// labeled failures are not handled by ordinary choices
+	p = Or(Concat(Literal("a"), Throw("e")), Literal("ab"))
+	tests = []PatternTest{
+		{"ab", -1},
+		{"b", -1},
+	}
+	check(p, tests, t)
+
+	p = re.MustCompile("('a' ^x / 'ab') //{y, x} 'abc'")
+	tests = []PatternTest{
+		{"abc", 3},
+		{"ab", -1},
+	}
+	check(p, tests, t)
+}
+
+func TestRecoveryRule(t *testing.T) {
+	p := re.MustCompile(`
+		List <- Item (',' Item)* !.
+		Item <- [a-z]+ / ^item
+		item <- (!',' .)*
+	`)
+	code := vm.Encode(MustCompile(p))
+
+	match, n, _, errs, _ := code.Exec(strings.NewReader("ab,12,cd,+"), memo.NoneTable{})
+	if !match || n != 10 {
+		t.Errorf("got (%t, %d), expected (true, 10)", match, n)
+	}
+	if len(errs) != 2 || errs[0].Pos != 3 || errs[1].Pos != 9 || errs[0].Message != "item" {
+		t.Errorf("incorrect list of errors: %v", errs)
+	}
+
+	// a catch takes priority over the recovery rule
+	p = re.MustCompile(`
+		S    <- (Item //{item} '?') (',' Item)*
+		Item <- [a-z]+ / ^item
+		item <- (!',' .)*
+	`)
+	code = vm.Encode(MustCompile(p))
+	match, n, _, errs, _ = code.Exec(strings.NewReader("?,12,cd"), memo.NoneTable{})
+	if !match || n != 7 || len(errs) != 1 || errs[0].Pos != 2 {
+		t.Errorf("got (%t, %d, %v), expected (true, 7) and one error", match, n, errs)
+	}
+}
+
+func TestRecoveryTable(t *testing.T) {
+	g := Grammar("S", map[string]Pattern{
+		"S":    Concat(Literal("("), Or(Literal("x"), Throw("missingX")), Literal(")")),
+		"Skip": Star(Concat(Not(Literal(")")), Any(1))),
+	}).(*GrammarNode)
+	g.Recover = map[string]string{"missingX": "Skip"}
+	code := vm.Encode(MustCompile(g))
+
+	match, n, _, errs, _ := code.Exec(strings.NewReader("(yy)"), memo.NoneTable{})
+	if !match || n != 4 || len(errs) != 1 || errs[0].Message != "missingX" {
+		t.Errorf("got (%t, %d, %v), expected (true, 4) and one error", match, n, errs)
+	}
+
+	g.Recover = map[string]string{"missingX": "Missing"}
+	if _, err := Compile(g); err == nil {
+		t.Error("expected an error for a missing recovery rule")
+	}
+}
@@ -0,0 +1,117 @@
+package gpeg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestLeftRecursion(t *testing.T) {
+	// grammar:
+	// Expr   <- Expr [+-] Term / Term
+	// Term   <- Term [*/] Factor / Factor
+	// Factor <- Number / '(' Expr ')'
+	// Number <- [0-9]+
+	p := Grammar("Expr", map[string]Pattern{
+		"Expr":   Or(Concat(NonTerm("Expr"), Set(charset.New([]byte{'+', '-'})), NonTerm("Term")), NonTerm("Term")),
+		"Term":   Or(Concat(NonTerm("Term"), Set(charset.New([]byte{'*', '/'})), NonTerm("Factor")), NonTerm("Factor")),
+		"Factor": Or(NonTerm("Number"), Concat(Literal("("), NonTerm("Expr"), Literal(")"))),
+		"Number": Plus(Set(charset.Range('0', '9'))),
+	})
+	tests := []PatternTest{
+		{"13+(22-15)", 10},
+		{"24*5+3", 6},
+		{"1-2-3*4/5", 9},
+		{"word 5*3", -1},
+		{"10*(43", 2},
+		{"7+", 1},
+	}
+	check(p, tests, t)
+}
+
+func TestIndirectLeftRecursion(t *testing.T) {
+	p := re.MustCompile(`
+		A <- B 'a' / 'x'
+		B <- A 'b' / 'y'
+	`)
+	tests := []PatternTest{
+		{"x", 1},
+		{"ya", 2},
+		{"xba", 3},
+		{"xbaba", 5},
+		{"yaba", 4},
+		{"xbb", 1},
+		{"b", -1},
+	}
+	check(p, tests, t)
+}
+
+func TestLeftRecursionCaptures(t *testing.T) {
+	ids := make(map[string]int)
+	p := re.MustCompileCap(`
+		Expr   <- Expr '-' Number / Number
+		Number <- [0-9]+
+	`, ids)
+	code := vm.Encode(MustCompile(p))
+
+	in := "1-2-3"
+	match, n, ast, _, _ := code.Exec(strings.NewReader(in), memo.NoneTable{})
+	if !match || n != len(in) {
+		t.Fatalf("got (%t, %d), but expected (true, %d)", match, n, len(in))
+	}
+
+	// the tree must be left associative: ((1-2)-3)
+	var show func(c *memo.Capture) string
+	show = func(c *memo.Capture) string {
+		if c.Id() == ids["Number"] {
+			return in[c.Start():c.End()]
+		}
+		s := "("
+		it := c.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			if len(s) > 1 {
+				s += " "
+			}
+			s += show(ch)
+		}
+		return s + ")"
+	}
+	if got := show(ast.Child(0)); got != "(((1) 2) 3)" {
+		t.Errorf("got tree %s", got)
+	}
+}
+
+func TestLeftRecursionMemo(t *testing.T) {
+	p := re.MustCompile(`
+		List <- {{List ',' Item}} / Item
+		Item <- [a-z]+
+	`)
+	code := vm.Encode(MustCompile(p))
+
+	in := []byte(strings.Repeat("abc,", 200) + "xyz")
+	tbl := memo.NewTreeTable(0)
+	for i := 0; i < 2; i++ {
+		match, n, _, _, _ := code.Exec(strings.NewReader(string(in)), tbl)
+		if !match || n != len(in) {
+			t.Fatalf("parse %d: got (%t, %d), but expected (true, %d)", i, match, n, len(in))
+		}
+	}
+
+	// edit the list and reparse with the old memo table
+	in[10] = ','
+	tbl.ApplyEdit(memo.Edit{
+		Start: 10,
+		End:   11,
+		Len:   1,
+	})
+	match, n, _, _, _ := code.Exec(strings.NewReader(string(in)), tbl)
+	nmatch, nn, _, _, _ := code.Exec(strings.NewReader(string(in)), memo.NoneTable{})
+	if match != nmatch || n != nn {
+		t.Errorf("incremental (%t, %d) != full (%t, %d)", match, n, nmatch, nn)
+	}
+}
@@ -0,0 +1,112 @@
+package gpeg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestExecLimits(t *testing.T) {
+	p := Star(Cap(Literal("ab"), 0))
+	code := vm.Encode(MustCompile(p))
+	in := strings.Repeat("ab", 100000)
+
+	_, _, _, _, err := code.ExecContext(context.Background(), strings.NewReader(in), memo.NoneTable{}, vm.ExecOptions{
+		MaxSteps: 1000,
+	})
+	var aerr *vm.AbortError
+	if !errors.As(err, &aerr) || !errors.Is(err, vm.ErrStepLimit) {
+		t.Fatalf("got error %v, expected step limit", err)
+	}
+	if aerr.Steps != 1000 || aerr.Pos == 0 || aerr.Capture.NumChildren() == 0 {
+		t.Errorf("incorrect partial state: %d steps, position %d", aerr.Steps, aerr.Pos)
+	}
+	if n := aerr.Capture.NumChildren(); aerr.Capture.Child(n-1).End() > aerr.Pos {
+		t.Errorf("partial capture ends after position %d", aerr.Pos)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, _, err = code.ExecContext(ctx, strings.NewReader(in), memo.NoneTable{}, vm.ExecOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, expected cancellation", err)
+	}
+
+	_, _, _, _, err = code.ExecContext(context.Background(), strings.NewReader(in), memo.NoneTable{}, vm.ExecOptions{
+		Timeout: time.Nanosecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, expected timeout", err)
+	}
+
+	match, n, ast, _, err := code.ExecContext(context.Background(), strings.NewReader(in), memo.NoneTable{}, vm.ExecOptions{})
+	if err != nil || !match || n != len(in) || ast.NumChildren() != len(in)/2 {
+		t.Errorf("unlimited execution: got (%t, %d, %v)", match, n, err)
+	}
+}
+
+func TestStackDepthLimit(t *testing.T) {
+	code := vm.Encode(MustCompile(re.MustCompile(`
+		Value <- '[' Value? ']' / [0-9]+
+	`)))
+	opts := vm.ExecOptions{
+		MaxStackDepth: 1000,
+	}
+
+	shallow := strings.Repeat("[", 100) + "1" + strings.Repeat("]", 100)
+	match, n, _, _, err := code.ExecContext(context.Background(), strings.NewReader(shallow), memo.NoneTable{}, opts)
+	if err != nil || !match || n != len(shallow) {
+		t.Errorf("shallow input: got (%t, %d, %v)", match, n, err)
+	}
+
+	deep := strings.Repeat("[", 100000) + "1" + strings.Repeat("]", 100000)
+	_, _, _, _, err = code.ExecContext(context.Background(), strings.NewReader(deep), memo.NoneTable{}, opts)
+	if !errors.Is(err, vm.ErrStackOverflow) {
+		t.Errorf("deep input: got error %v, expected stack overflow", err)
+	}
+
+	// the limit of the code applies to every execution.
+	code.SetMaxStackDepth(1000)
+	for _, backend := range []vm.Backend{vm.Interpreter, vm.Closures} {
+		code.SetBackend(backend)
+		match, n, _, _, err = code.Exec(strings.NewReader(shallow), memo.NoneTable{})
+		if err != nil || !match || n != len(shallow) {
+			t.Errorf("backend %d: shallow input: got (%t, %d, %v)", backend, match, n, err)
+		}
+		_, _, _, _, err = code.Exec(strings.NewReader(deep), memo.NoneTable{})
+		if !errors.Is(err, vm.ErrStackOverflow) {
+			t.Errorf("backend %d: deep input: got error %v, expected stack overflow", backend, err)
+		}
+	}
+	_, _, _, err = code.Parse(strings.NewReader(deep), memo.NoneTable{})
+	if !errors.Is(err, vm.ErrStackOverflow) {
+		t.Errorf("Parse: got error %v, expected stack overflow", err)
+	}
+}
+
+func TestExecError(t *testing.T) {
+	code := vm.Encode(isa.Program{
+		isa.Return{},
+		isa.End{},
+	})
+	_, _, _, _, err := code.ExecContext(context.Background(), strings.NewReader(""), memo.NoneTable{}, vm.ExecOptions{})
+	var eerr *vm.ExecError
+	if !errors.As(err, &eerr) || eerr.Ip != 0 {
+		t.Errorf("got error %v, expected an ExecError", err)
+	}
+	for _, backend := range []vm.Backend{vm.Interpreter, vm.Closures} {
+		code.SetBackend(backend)
+		match, _, _, _, err := code.Exec(strings.NewReader(""), memo.NoneTable{})
+		if !errors.As(err, &eerr) || eerr.Ip != 0 || match {
+			t.Errorf("backend %d: got (%t, %v), expected an ExecError", backend, match, err)
+		}
+	}
+}
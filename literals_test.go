@@ -0,0 +1,120 @@
+package gpeg
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/bench"
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestLiterals(t *testing.T) {
+	tests := []struct {
+		peg   string
+		strs  []string
+		tests []PatternTest
+	}{
+		// the first literal that matches wins, even if a later one is
+		// longer.
+		{`S <- 'a' / 'ab' / 'abc'`, []string{"a"}, []PatternTest{
+			{"abc", 1},
+			{"b", -1},
+		}},
+		{`S <- 'abc' / 'ab' / 'a'`, []string{"abc", "ab", "a"}, []PatternTest{
+			{"abc", 3},
+			{"abd", 2},
+			{"ax", 1},
+			{"", -1},
+		}},
+		{`S <- ('double' / 'do' / 'while' / 'int') ';'`, []string{"double", "do", "while", "int"}, []PatternTest{
+			{"double;", 7},
+			{"do;", 3},
+			{"dou;", -1},
+			{"int;", 4},
+			{"in;", -1},
+		}},
+		// the alternatives after the literals are tried if none of them
+		// match.
+		{`S <- 'if' / 'in' / 'for' / [a-z]+ / ''`, []string{"if", "in", "for"}, []PatternTest{
+			{"if", 2},
+			{"fox", 3},
+			{"1", 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.peg, func(t *testing.T) {
+			p := re.MustCompile(tt.peg)
+			var strs []string
+			for _, insn := range pattern.MustCompile(p) {
+				if l, ok := insn.(isa.Literals); ok {
+					strs = l.Strs
+				}
+			}
+			if strings.Join(strs, " ") != strings.Join(tt.strs, " ") {
+				t.Errorf("got literals %q, expected %q", strs, tt.strs)
+			}
+			check(p, tt.tests, t)
+		})
+	}
+}
+
+func TestLiteralsSyntaxError(t *testing.T) {
+	p := re.MustCompile(`S <- ('abstract' / 'assert' / 'break' / 'assume') ';'`)
+	code := vm.Encode(pattern.MustCompile(p))
+
+	tests := []struct {
+		in  string
+		msg string
+	}{
+		{"x", "expected 'a' or 'b' at 1:1"},
+		{"asx", "expected 's' at 1:3"},
+		{"assx", "expected 'e' or 'u' at 1:4"},
+		{"break", "expected ';' at 1:6"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := code.Parse(strings.NewReader(tt.in), memo.NoneTable{})
+		var serr *vm.SyntaxError
+		if !errors.As(err, &serr) || serr.Error() != tt.msg {
+			t.Errorf("%q: got %v, expected %q", tt.in, err, tt.msg)
+		}
+	}
+}
+
+// Programs that match literals with a trie must give the same results as
+// programs that try them one after the other.
+func TestLiteralsEquivalence(t *testing.T) {
+	rand.Seed(11)
+
+	java, err := ioutil.ReadFile("testdata/test.java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime, err := ioutil.ReadFile("testdata/ScriptRuntime.java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar, err := ioutil.ReadFile("grammars/java.peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := re.MustCompileCap(string(grammar), make(map[string]int))
+
+	defer func(threshold int) {
+		pattern.LiteralsThreshold = threshold
+	}(pattern.LiteralsThreshold)
+	pattern.LiteralsThreshold = 1 << 30
+	choices := vm.Encode(pattern.MustCompile(p))
+	pattern.LiteralsThreshold = 3
+	trie := vm.Encode(pattern.MustCompile(p))
+
+	checkEquivalent(t, "testdata/test.java", choices, trie, java, randomEdits(java, 200))
+	edits := append(bench.GenerateEdits(runtime, 5), randomEdits(runtime, 5)...)
+	checkEquivalent(t, "testdata/ScriptRuntime.java", choices, trie, runtime, edits)
+}
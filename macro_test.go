@@ -0,0 +1,129 @@
+package gpeg
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+var macros = fstest.MapFS{
+	"lists.peg": {Data: []byte(`
+		List(elem, sep) <- elem (sep elem)*
+		Block(open, close) <- open (!close .)* close
+		Comma <- ',' ' '*
+		CSV(elem) <- List(elem, Comma)
+	`)},
+	"bad.peg": {Data: []byte(`import "lists.peg" as l  P(x) <- l.Missing x`)},
+}
+
+func TestReMacro(t *testing.T) {
+	tests := []struct {
+		patt  string
+		tests []PatternTest
+	}{
+		{`S <- List([0-9]+, ',') !.
+		  List(elem, sep) <- elem (sep elem)*`, []PatternTest{
+			{"1,22,333", 8},
+			{"1,", -1},
+		}},
+		// arguments can refer to parameters and call other rules with
+		// parameters.
+		{`S <- Pair(Num) !.
+		  Pair(x) <- '(' List(x, ' ') ')'
+		  List(elem, sep) <- elem (sep elem)*
+		  Num <- [0-9]+`, []PatternTest{
+			{"(1 2 3)", 7},
+			{"(1  2)", -1},
+		}},
+		// rules with parameters can be recursive.
+		{`S <- Nest('(', ')') !.
+		  Nest(open, close) <- open Nest(open, close)* close`, []PatternTest{
+			{"(()(()))", 8},
+			{"(()", -1},
+		}},
+		// the same expansion is shared, and parameters shadow rules.
+		{`S <- Opt(x) Opt(x) !.
+		  Opt(x) <- x?
+		  x <- 'x'`, []PatternTest{
+			{"xx", 2},
+			{"", 0},
+		}},
+		{`import "lists.peg" as l
+		  S <- l.CSV([a-z]+) ';' l.Block('/*', '*/') !.`, []PatternTest{
+			{"a, bc,d;/* x */", 15},
+			{"a;/* x", -1},
+		}},
+		{`import "lists.peg" as l
+		  S <- l.CSV('a') !.
+		  l.Comma <- ';'`, []PatternTest{
+			{"a;a", 3},
+			{"a,a", -1},
+		}},
+		{`import "lists.peg" as l
+		  S <- l.List('a', ',') !.
+		  l.List(elem, sep) += elem elem`, []PatternTest{
+			{"aa", 2},
+			{"a,a", 3},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patt, func(t *testing.T) {
+			p, err := re.CompileFS(tt.patt, macros)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(p, tt.tests, t)
+		})
+	}
+}
+
+func TestReMacroError(t *testing.T) {
+	tests := []struct {
+		patt string
+		msg  string
+	}{
+		{`S <- List('a') List(e, s) <- e (s e)*`, "5: List takes 2 arguments, got 1"},
+		{`S <- List List(e, s) <- e (s e)*`, "5: List takes 2 arguments"},
+		{`S <- T('a') T <- 'a'`, "5: T is not a rule with parameters"},
+		{`S <- 'a' P(x, x) <- x`, "14: duplicate parameter x"},
+		{`P(x) <- x`, "0: a grammar needs a rule without parameters"},
+		{`S <- R('a') R(x) <- x R((x))`, "22: too many nested expansions of R"},
+		{`import "lists.peg" as l  S <- l.List('a')`, "30: l.List takes 2 arguments, got 1"},
+		{`import "lists.peg" as l  S <- l.Comma('a')`, "30: l.Comma is not a rule with parameters"},
+		{`import "lists.peg" as l  S <- 'a'  l.List(x) <- x`, "35: l.List takes 2 arguments"},
+		{`import "bad.peg" as b  S <- b.P('a')`, "bad.peg: 33: undefined rule l.Missing"},
+	}
+	for _, tt := range tests {
+		_, err := re.CompileFS(tt.patt, macros)
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("%q: got error %v, expected %q", tt.patt, err, tt.msg)
+		}
+	}
+}
+
+// Expanded rules are part of the rules that call them and are not captured.
+func TestReMacroCapture(t *testing.T) {
+	ids := make(map[string]int)
+	p, err := re.CompileCap(`S <- List(Num, ',')
+		List(elem, sep) <- elem (sep elem)*
+		Num <- [0-9]+`, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range ids {
+		if strings.Contains(name, "(") {
+			t.Errorf("capture id for expanded rule %s", name)
+		}
+	}
+
+	code := vm.Encode(pattern.MustCompile(p))
+	_, _, caps, _, _ := code.Exec(strings.NewReader("1,2"), memo.NoneTable{})
+	if caps.NumChildren() != 1 || caps.Child(0).NumChildren() != 2 {
+		t.Errorf("unexpected captures %v", caps)
+	}
+}
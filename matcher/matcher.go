@@ -0,0 +1,815 @@
+// Package matcher provides a regexp-like API for searching and replacing
+// with patterns. A Matcher finds the leftmost match of its pattern in a
+// subject, and offers the searching, replacing and splitting methods of
+// regexp.Regexp with the same names and behavior.
+//
+// Since patterns are not backtracking regular expressions, a match at a
+// given position is the one found by the pattern's ordered choices and
+// possessive repetitions, rather than the leftmost-first match of a regular
+// expression.
+package matcher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/rxconv"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// MatchId is the capture ID of the whole match. It is the largest ID that
+// can be encoded, and is reserved: New returns an error for a pattern that
+// captures with it.
+const MatchId = 1<<15 - 1
+
+// A Matcher is a compiled pattern that searches subjects for matches. A
+// Matcher is safe for concurrent use by multiple goroutines.
+type Matcher struct {
+	code vm.Code
+	// the names of the subexpressions, indexed by their IDs, with the whole
+	// match at index 0.
+	names []string
+	// groups is true if the subexpressions are the groups of a regexp,
+	// which are marked by the captures of the pattern.
+	groups bool
+}
+
+// New returns a Matcher that searches for p. The subexpressions of the
+// matcher are the captures of p, and subexpression i is the first capture
+// with ID i in a match.
+func New(p pattern.Pattern) (*Matcher, error) {
+	prog, err := pattern.Compile(pattern.Search(pattern.Cap(p, MatchId)))
+	if err != nil {
+		return nil, err
+	}
+	opened := captureIds(prog)
+	if opened[MatchId] > 1 {
+		return nil, fmt.Errorf("capture ID %d is reserved for the match", MatchId)
+	}
+	m := &Matcher{
+		code: vm.Encode(prog),
+	}
+	nsub := 0
+	for id := range opened {
+		if id != MatchId && id > nsub {
+			nsub = id
+		}
+	}
+	m.names = make([]string, nsub+1)
+	for i := 1; i <= nsub; i++ {
+		m.names[i], _ = m.code.CaptureName(i)
+	}
+	return m, nil
+}
+
+// captureIds returns the number of captures that prog opens with each ID.
+func captureIds(prog isa.Program) map[int]int {
+	opened := make(map[int]int)
+	for _, insn := range prog {
+		switch t := insn.(type) {
+		case isa.CaptureBegin:
+			opened[t.Id]++
+		case isa.CaptureLate:
+			opened[t.Id]++
+		case isa.CaptureFull:
+			opened[t.Id]++
+		}
+	}
+	return opened
+}
+
+// MustNew is like New but panics if p cannot be compiled.
+func MustNew(p pattern.Pattern) *Matcher {
+	m, err := New(p)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Compile returns a Matcher that searches for the grammar or expression s
+// in re syntax. Every rule of a grammar is captured, so that replacement
+// templates may refer to rules by name.
+func Compile(s string) (*Matcher, error) {
+	p, err := re.CompileCap(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(p)
+}
+
+// MustCompile is like Compile but panics if s cannot be compiled.
+func MustCompile(s string) *Matcher {
+	m, err := Compile(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// CompileRegexp returns a Matcher that searches for the Perl regular
+// expression s. The subexpressions of the matcher are the groups of s, as in
+// regexp, so that replacement templates may refer to groups by number or
+// name.
+func CompileRegexp(s string) (*Matcher, error) {
+	p, names, err := rxconv.ConvertGroups(s, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	if rxconv.GroupEnd(len(names)-1) >= MatchId {
+		return nil, fmt.Errorf("invalid regexp (too many capturing groups)")
+	}
+	m, err := New(p)
+	if err != nil {
+		return nil, err
+	}
+	m.names = names
+	m.groups = true
+	return m, nil
+}
+
+// MustCompileRegexp is like CompileRegexp but panics if s cannot be
+// compiled.
+func MustCompileRegexp(s string) *Matcher {
+	m, err := CompileRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Code returns the compiled code of the matcher, which names the capture IDs
+// of its pattern.
+func (m *Matcher) Code() *vm.Code {
+	return &m.code
+}
+
+// exec returns the capture of the first match at or after pos, or nil if
+// there is none.
+func (m *Matcher) exec(r io.ReaderAt, pos int) *memo.Capture {
+	match, _, capt, _, _ := m.code.ExecAt(r, pos, memo.NoneTable{})
+	if !match {
+		return nil
+	}
+	c := capt.ChildById(MatchId)
+	if m.groups {
+		c = m.groupCaptures(c)
+	}
+	return c
+}
+
+// groupCaptures returns the match c of a regexp with the marks of its groups
+// replaced by captures of the groups. A group that was matched more than
+// once spans its last match, as in regexp.
+func (m *Matcher) groupCaptures(c *memo.Capture) *memo.Capture {
+	start := make([]int, len(m.names))
+	end := make([]int, len(m.names))
+	for i := range start {
+		start[i] = -1
+	}
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		i := ch.Id() / 2
+		if ch.Id() == rxconv.GroupStart(i) {
+			start[i] = ch.Start()
+		} else {
+			end[i] = ch.Start()
+		}
+	}
+	var groups []*memo.Capture
+	for i := 1; i < len(m.names); i++ {
+		if start[i] >= 0 {
+			groups = append(groups, memo.NewCaptureNode(i, start[i], end[i]-start[i], nil))
+		}
+	}
+	return memo.NewCaptureNode(MatchId, c.Start(), c.Len(), groups)
+}
+
+// namer returns the names of the subexpressions used by templates.
+func (m *Matcher) namer() memo.Namer {
+	if m.groups {
+		return groupNames(m.names)
+	}
+	return &m.code
+}
+
+// groupNames names the groups of a regexp by their numbers.
+type groupNames []string
+
+func (g groupNames) CaptureName(id int) (string, bool) {
+	if id <= 0 || id >= len(g) || g[id] == "" {
+		return "", false
+	}
+	return g[id], true
+}
+
+func (g groupNames) CaptureId(name string) (int, bool) {
+	for id, n := range g {
+		if n != "" && n == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func reader(b []byte, s string) io.ReaderAt {
+	if b != nil {
+		return bytes.NewReader(b)
+	}
+	return strings.NewReader(s)
+}
+
+// FindCapture returns the capture of the leftmost match in b, whose children
+// are the captures made by the pattern, or nil if there is no match.
+func (m *Matcher) FindCapture(b []byte) *memo.Capture {
+	return m.exec(bytes.NewReader(b), 0)
+}
+
+// Match reports whether b contains any match of the pattern.
+func (m *Matcher) Match(b []byte) bool {
+	return m.exec(bytes.NewReader(b), 0) != nil
+}
+
+// MatchString reports whether s contains any match of the pattern.
+func (m *Matcher) MatchString(s string) bool {
+	return m.exec(strings.NewReader(s), 0) != nil
+}
+
+// Find returns the text of the leftmost match in b, or nil if there is no
+// match.
+func (m *Matcher) Find(b []byte) []byte {
+	c := m.exec(bytes.NewReader(b), 0)
+	if c == nil {
+		return nil
+	}
+	return b[c.Start():c.End():c.End()]
+}
+
+// FindString returns the text of the leftmost match in s, or the empty
+// string if there is no match.
+func (m *Matcher) FindString(s string) string {
+	c := m.exec(strings.NewReader(s), 0)
+	if c == nil {
+		return ""
+	}
+	return s[c.Start():c.End()]
+}
+
+// FindIndex returns the location of the leftmost match in b as a pair of
+// indices, or nil if there is no match.
+func (m *Matcher) FindIndex(b []byte) []int {
+	c := m.exec(bytes.NewReader(b), 0)
+	if c == nil {
+		return nil
+	}
+	return []int{c.Start(), c.End()}
+}
+
+// FindStringIndex returns the location of the leftmost match in s as a pair
+// of indices, or nil if there is no match.
+func (m *Matcher) FindStringIndex(s string) []int {
+	c := m.exec(strings.NewReader(s), 0)
+	if c == nil {
+		return nil
+	}
+	return []int{c.Start(), c.End()}
+}
+
+// NumSubexp returns the number of subexpressions of the matcher.
+func (m *Matcher) NumSubexp() int {
+	return len(m.names) - 1
+}
+
+// SubexpNames returns the names of the subexpressions of the matcher. The
+// name of subexpression i is at index i, and index 0 is the whole match. As
+// in regexp, the slice should not be modified.
+func (m *Matcher) SubexpNames() []string {
+	return m.names
+}
+
+// SubexpIndex returns the index of the first subexpression with the given
+// name, or -1 if there is none.
+func (m *Matcher) SubexpIndex(name string) int {
+	if name != "" {
+		for i, n := range m.names {
+			if n == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// submatches returns the locations of the match c and of its
+// subexpressions as pairs of indices, where -1 marks a subexpression that is
+// not part of the match.
+func (m *Matcher) submatches(c *memo.Capture) []int {
+	loc := make([]int, 2*len(m.names))
+	loc[0], loc[1] = c.Start(), c.End()
+	for i := 1; i < len(m.names); i++ {
+		if sub := find(c, i); sub != nil {
+			loc[2*i], loc[2*i+1] = sub.Start(), sub.End()
+		} else {
+			loc[2*i], loc[2*i+1] = -1, -1
+		}
+	}
+	return loc
+}
+
+func subslices(b []byte, loc []int) [][]byte {
+	result := make([][]byte, len(loc)/2)
+	for i := range result {
+		if loc[2*i] >= 0 {
+			result[i] = b[loc[2*i]:loc[2*i+1]:loc[2*i+1]]
+		}
+	}
+	return result
+}
+
+func substrings(s string, loc []int) []string {
+	result := make([]string, len(loc)/2)
+	for i := range result {
+		if loc[2*i] >= 0 {
+			result[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return result
+}
+
+// FindSubmatch returns the text of the leftmost match in b and of its
+// subexpressions, or nil if there is no match. A subexpression that is not
+// part of the match is nil.
+func (m *Matcher) FindSubmatch(b []byte) [][]byte {
+	c := m.exec(bytes.NewReader(b), 0)
+	if c == nil {
+		return nil
+	}
+	return subslices(b, m.submatches(c))
+}
+
+// FindStringSubmatch returns the text of the leftmost match in s and of its
+// subexpressions, or nil if there is no match. A subexpression that is not
+// part of the match is the empty string.
+func (m *Matcher) FindStringSubmatch(s string) []string {
+	c := m.exec(strings.NewReader(s), 0)
+	if c == nil {
+		return nil
+	}
+	return substrings(s, m.submatches(c))
+}
+
+// FindSubmatchIndex returns the locations of the leftmost match in b and of
+// its subexpressions as pairs of indices, or nil if there is no match.
+func (m *Matcher) FindSubmatchIndex(b []byte) []int {
+	c := m.exec(bytes.NewReader(b), 0)
+	if c == nil {
+		return nil
+	}
+	return m.submatches(c)
+}
+
+// FindStringSubmatchIndex returns the locations of the leftmost match in s
+// and of its subexpressions as pairs of indices, or nil if there is no
+// match.
+func (m *Matcher) FindStringSubmatchIndex(s string) []int {
+	c := m.exec(strings.NewReader(s), 0)
+	if c == nil {
+		return nil
+	}
+	return m.submatches(c)
+}
+
+// allMatches calls deliver for at most n successive non-overlapping matches
+// in the subject, or all of them if n < 0. As in regexp, an empty match
+// directly after a previous match is ignored.
+func (m *Matcher) allMatches(b []byte, s string, n int, deliver func(c *memo.Capture)) {
+	var end int
+	if b == nil {
+		end = len(s)
+	} else {
+		end = len(b)
+	}
+	r := reader(b, s)
+
+	for pos, i, prevMatchEnd := 0, 0, -1; i < n && pos <= end; {
+		c := m.exec(r, pos)
+		if c == nil {
+			break
+		}
+
+		accept := true
+		if c.End() == pos {
+			// empty match: move to the next rune.
+			if c.Start() == prevMatchEnd {
+				accept = false
+			}
+			var width int
+			if b == nil {
+				_, width = utf8.DecodeRuneInString(s[pos:end])
+			} else {
+				_, width = utf8.DecodeRune(b[pos:end])
+			}
+			if width > 0 {
+				pos += width
+			} else {
+				pos = end + 1
+			}
+		} else {
+			pos = c.End()
+		}
+		prevMatchEnd = c.End()
+
+		if accept {
+			deliver(c)
+			i++
+		}
+	}
+}
+
+// FindAll returns the text of at most n successive matches in b, or all of
+// them if n < 0. It returns nil if there is no match.
+func (m *Matcher) FindAll(b []byte, n int) [][]byte {
+	if n < 0 {
+		n = len(b) + 1
+	}
+	var result [][]byte
+	m.allMatches(b, "", n, func(c *memo.Capture) {
+		result = append(result, b[c.Start():c.End():c.End()])
+	})
+	return result
+}
+
+// FindAllString returns the text of at most n successive matches in s, or
+// all of them if n < 0. It returns nil if there is no match.
+func (m *Matcher) FindAllString(s string, n int) []string {
+	if n < 0 {
+		n = len(s) + 1
+	}
+	var result []string
+	m.allMatches(nil, s, n, func(c *memo.Capture) {
+		result = append(result, s[c.Start():c.End()])
+	})
+	return result
+}
+
+// FindAllIndex returns the locations of at most n successive matches in b,
+// or all of them if n < 0. It returns nil if there is no match.
+func (m *Matcher) FindAllIndex(b []byte, n int) [][]int {
+	if n < 0 {
+		n = len(b) + 1
+	}
+	var result [][]int
+	m.allMatches(b, "", n, func(c *memo.Capture) {
+		result = append(result, []int{c.Start(), c.End()})
+	})
+	return result
+}
+
+// FindAllStringIndex returns the locations of at most n successive matches
+// in s, or all of them if n < 0. It returns nil if there is no match.
+func (m *Matcher) FindAllStringIndex(s string, n int) [][]int {
+	if n < 0 {
+		n = len(s) + 1
+	}
+	var result [][]int
+	m.allMatches(nil, s, n, func(c *memo.Capture) {
+		result = append(result, []int{c.Start(), c.End()})
+	})
+	return result
+}
+
+// FindAllSubmatch returns the text of at most n successive matches in b and
+// of their subexpressions, or all of them if n < 0. It returns nil if there
+// is no match.
+func (m *Matcher) FindAllSubmatch(b []byte, n int) [][][]byte {
+	if n < 0 {
+		n = len(b) + 1
+	}
+	var result [][][]byte
+	m.allMatches(b, "", n, func(c *memo.Capture) {
+		result = append(result, subslices(b, m.submatches(c)))
+	})
+	return result
+}
+
+// FindAllStringSubmatch returns the text of at most n successive matches in
+// s and of their subexpressions, or all of them if n < 0. It returns nil if
+// there is no match.
+func (m *Matcher) FindAllStringSubmatch(s string, n int) [][]string {
+	if n < 0 {
+		n = len(s) + 1
+	}
+	var result [][]string
+	m.allMatches(nil, s, n, func(c *memo.Capture) {
+		result = append(result, substrings(s, m.submatches(c)))
+	})
+	return result
+}
+
+// FindAllSubmatchIndex returns the locations of at most n successive matches
+// in b and of their subexpressions, or all of them if n < 0. It returns nil
+// if there is no match.
+func (m *Matcher) FindAllSubmatchIndex(b []byte, n int) [][]int {
+	if n < 0 {
+		n = len(b) + 1
+	}
+	var result [][]int
+	m.allMatches(b, "", n, func(c *memo.Capture) {
+		result = append(result, m.submatches(c))
+	})
+	return result
+}
+
+// FindAllStringSubmatchIndex returns the locations of at most n successive
+// matches in s and of their subexpressions, or all of them if n < 0. It
+// returns nil if there is no match.
+func (m *Matcher) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	if n < 0 {
+		n = len(s) + 1
+	}
+	var result [][]int
+	m.allMatches(nil, s, n, func(c *memo.Capture) {
+		result = append(result, m.submatches(c))
+	})
+	return result
+}
+
+// FindAllCaptures returns the captures of at most n successive matches in b,
+// or all of them if n < 0. It returns nil if there is no match.
+func (m *Matcher) FindAllCaptures(b []byte, n int) []*memo.Capture {
+	if n < 0 {
+		n = len(b) + 1
+	}
+	var result []*memo.Capture
+	m.allMatches(b, "", n, func(c *memo.Capture) {
+		result = append(result, c)
+	})
+	return result
+}
+
+// replaceAll returns a copy of the subject in which every match has been
+// replaced by the text that repl appends to dst.
+func (m *Matcher) replaceAll(b []byte, s string, repl func(dst []byte, c *memo.Capture) []byte) []byte {
+	var end int
+	if b == nil {
+		end = len(s)
+	} else {
+		end = len(b)
+	}
+	r := reader(b, s)
+
+	lastMatchEnd := 0
+	searchPos := 0
+	var buf []byte
+	for searchPos <= end {
+		c := m.exec(r, searchPos)
+		if c == nil {
+			break
+		}
+
+		// copy the unmatched text before this match.
+		if b == nil {
+			buf = append(buf, s[lastMatchEnd:c.Start()]...)
+		} else {
+			buf = append(buf, b[lastMatchEnd:c.Start()]...)
+		}
+
+		// an empty match directly after a previous match is not replaced.
+		if c.End() > lastMatchEnd || c.Start() == 0 {
+			buf = repl(buf, c)
+		}
+		lastMatchEnd = c.End()
+
+		// advance past this match, or by one rune for an empty match.
+		var width int
+		if b == nil {
+			_, width = utf8.DecodeRuneInString(s[searchPos:])
+		} else {
+			_, width = utf8.DecodeRune(b[searchPos:])
+		}
+		if searchPos+width > c.End() {
+			searchPos += width
+		} else if searchPos+1 > c.End() {
+			searchPos++
+		} else {
+			searchPos = c.End()
+		}
+	}
+
+	if b == nil {
+		buf = append(buf, s[lastMatchEnd:]...)
+	} else {
+		buf = append(buf, b[lastMatchEnd:]...)
+	}
+	return buf
+}
+
+// ReplaceAll returns a copy of src in which every match has been replaced
+// by the expansion of template, as done by Expand.
+func (m *Matcher) ReplaceAll(src, template []byte) []byte {
+	tmpl := string(template)
+	b := m.replaceAll(src, "", func(dst []byte, c *memo.Capture) []byte {
+		return m.expand(dst, tmpl, src, "", c)
+	})
+	return b
+}
+
+// ReplaceAllString returns a copy of src in which every match has been
+// replaced by the expansion of template, as done by Expand.
+func (m *Matcher) ReplaceAllString(src, template string) string {
+	b := m.replaceAll(nil, src, func(dst []byte, c *memo.Capture) []byte {
+		return m.expand(dst, template, nil, src, c)
+	})
+	return string(b)
+}
+
+// ReplaceAllLiteral returns a copy of src in which every match has been
+// replaced by repl, without expansion.
+func (m *Matcher) ReplaceAllLiteral(src, repl []byte) []byte {
+	return m.replaceAll(src, "", func(dst []byte, c *memo.Capture) []byte {
+		return append(dst, repl...)
+	})
+}
+
+// ReplaceAllLiteralString returns a copy of src in which every match has
+// been replaced by repl, without expansion.
+func (m *Matcher) ReplaceAllLiteralString(src, repl string) string {
+	return string(m.replaceAll(nil, src, func(dst []byte, c *memo.Capture) []byte {
+		return append(dst, repl...)
+	}))
+}
+
+// ReplaceAllFunc returns a copy of src in which every match has been
+// replaced by the return value of repl applied to the matched text.
+func (m *Matcher) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
+	return m.replaceAll(src, "", func(dst []byte, c *memo.Capture) []byte {
+		return append(dst, repl(src[c.Start():c.End()])...)
+	})
+}
+
+// ReplaceAllStringFunc returns a copy of src in which every match has been
+// replaced by the return value of repl applied to the matched text.
+func (m *Matcher) ReplaceAllStringFunc(src string, repl func(string) string) string {
+	return string(m.replaceAll(nil, src, func(dst []byte, c *memo.Capture) []byte {
+		return append(dst, repl(src[c.Start():c.End()])...)
+	}))
+}
+
+// Expand appends template to dst with the references to captures of the
+// match c in src replaced by their text, and returns the result. c must be a
+// capture returned by FindCapture or FindAllCaptures.
+//
+// As in regexp, $name or ${name} refers to a capture, where name is a
+// non-empty sequence of letters, digits and underscores. A number refers to
+// the first capture with that ID within the match, and $0 to the whole
+// match, while a name refers to the first capture with the ID that the name
+// has in the matcher's code, such as a grammar rule compiled by Compile, or
+// to the group of a regexp with that name. A
+// reference to a missing capture is replaced by the empty string, and $$ is
+// replaced by a literal $.
+func (m *Matcher) Expand(dst []byte, template []byte, src []byte, c *memo.Capture) []byte {
+	return m.expand(dst, string(template), src, "", c)
+}
+
+func (m *Matcher) expand(dst []byte, template string, bsrc []byte, src string, c *memo.Capture) []byte {
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i+1:]
+		if template != "" && template[0] == '$' {
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+		name, rest, ok := extract(template)
+		if !ok {
+			// malformed; treat $ as raw text.
+			dst = append(dst, '$')
+			continue
+		}
+		template = rest
+
+		ref := lookup(c, name, m.namer())
+		if ref == nil {
+			continue
+		}
+		if bsrc != nil {
+			dst = append(dst, bsrc[ref.Start():ref.End()]...)
+		} else {
+			dst = append(dst, src[ref.Start():ref.End()]...)
+		}
+	}
+	return append(dst, template...)
+}
+
+// extract returns the name from a leading "name" or "{name}" in str.
+func extract(str string) (name string, rest string, ok bool) {
+	if str == "" {
+		return
+	}
+	brace := false
+	if str[0] == '{' {
+		brace = true
+		str = str[1:]
+	}
+	i := 0
+	for i < len(str) {
+		r, size := utf8.DecodeRuneInString(str[i:])
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			break
+		}
+		i += size
+	}
+	if i == 0 {
+		// empty name is not okay
+		return
+	}
+	name = str[:i]
+	if brace {
+		if i >= len(str) || str[i] != '}' {
+			// missing closing brace
+			return
+		}
+		i++
+	}
+	return name, str[i:], true
+}
+
+// lookup returns the capture in the match c that name refers to, or nil if
+// there is none.
+func lookup(c *memo.Capture, name string, n memo.Namer) *memo.Capture {
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		var ok bool
+		if id, ok = n.CaptureId(name); !ok {
+			return nil
+		}
+	} else if id == 0 {
+		return c
+	}
+	return find(c, id)
+}
+
+// find returns the first descendant of c with the given ID, in depth-first
+// order, or nil if there is none.
+func find(c *memo.Capture, id int) *memo.Capture {
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		if ch.Id() == id {
+			return ch
+		}
+		if d := find(ch, id); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// Split slices s into substrings separated by the matches and returns the
+// substrings between them, in the same way as regexp.Regexp.Split. The count
+// n determines the number of substrings to return: if n > 0, at most n
+// substrings are returned and the last one is the unsplit remainder; if n ==
+// 0, the result is nil; if n < 0, all substrings are returned.
+func (m *Matcher) Split(s string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	if len(s) == 0 {
+		return []string{""}
+	}
+
+	matches := m.FindAllStringIndex(s, n)
+	strs := make([]string, 0, len(matches))
+
+	beg := 0
+	end := 0
+	for _, match := range matches {
+		if n > 0 && len(strs) == n-1 {
+			break
+		}
+
+		end = match[0]
+		if match[1] != 0 {
+			strs = append(strs, s[beg:end])
+		}
+		beg = match[1]
+	}
+
+	if end != len(s) {
+		strs = append(strs, s[beg:])
+	}
+
+	return strs
+}
@@ -0,0 +1,180 @@
+package matcher_test
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/matcher"
+	"github.com/zyedidia/gpeg/pattern"
+)
+
+// regexps whose leftmost-first matches are the same as the matches of the
+// converted patterns.
+var regexps = []string{
+	`[0-9]+`,
+	`a*`,
+	`x*`,
+	`,\s*`,
+	`\bfoo\b`,
+	`^`,
+	`$`,
+	`b|ab`,
+	`é`,
+	`[a-z]+ing`,
+	`.`,
+	`[^a]`,
+	`[à-ÿ]+`,
+	`\pL+`,
+	`\p{Greek}|\d`,
+	`(?s).`,
+	`(?i)straße|k+`,
+	`(?i)[a-c]+x`,
+	`(\d)(\d*)`,
+	`([a-z]+)(ing)?`,
+	`(?P<first>\w)(\w)?`,
+	`(?:(a)|(b))+`,
+	`(x)*`,
+	`((a)(b)?)+`,
+}
+
+var subjects = []string{
+	"",
+	"abc",
+	"foo, bar,baz,  foo1 foobar foo",
+	"12 apples and 345 oranges",
+	"aaa baab xx x",
+	"café éé",
+	"singing, bringing, ing",
+	"日本語 αβγ 123\n",
+	"a\xffb\xe6\x97c",
+	"STRASSE STRAẞE straße kK\u212a ABCX",
+}
+
+// Checks that the regexp-style methods behave like those of regexp.Regexp.
+func TestRegexp(t *testing.T) {
+	for _, expr := range regexps {
+		rx := regexp.MustCompile(expr)
+		m := matcher.MustCompileRegexp(expr)
+		if got, want := m.SubexpNames(), rx.SubexpNames(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: SubexpNames = %q, expected %q", expr, got, want)
+		}
+		for _, s := range subjects {
+			if got, want := m.MatchString(s), rx.MatchString(s); got != want {
+				t.Errorf("%q in %q: MatchString = %v, expected %v", expr, s, got, want)
+			}
+			if got, want := m.FindStringIndex(s), rx.FindStringIndex(s); !reflect.DeepEqual(got, want) {
+				t.Errorf("%q in %q: FindStringIndex = %v, expected %v", expr, s, got, want)
+			}
+			if got, want := m.Find([]byte(s)), rx.Find([]byte(s)); !reflect.DeepEqual(got, want) {
+				t.Errorf("%q in %q: Find = %q, expected %q", expr, s, got, want)
+			}
+			if got, want := m.FindStringSubmatchIndex(s), rx.FindStringSubmatchIndex(s); !reflect.DeepEqual(got, want) {
+				t.Errorf("%q in %q: FindStringSubmatchIndex = %v, expected %v", expr, s, got, want)
+			}
+			if got, want := m.FindSubmatch([]byte(s)), rx.FindSubmatch([]byte(s)); !reflect.DeepEqual(got, want) {
+				t.Errorf("%q in %q: FindSubmatch = %q, expected %q", expr, s, got, want)
+			}
+			for _, n := range []int{-1, 0, 1, 2} {
+				if got, want := m.FindAllStringIndex(s, n), rx.FindAllStringIndex(s, n); !reflect.DeepEqual(got, want) {
+					t.Errorf("%q in %q: FindAllStringIndex(%d) = %v, expected %v", expr, s, n, got, want)
+				}
+				if got, want := m.FindAll([]byte(s), n), rx.FindAll([]byte(s), n); !reflect.DeepEqual(got, want) {
+					t.Errorf("%q in %q: FindAll(%d) = %q, expected %q", expr, s, n, got, want)
+				}
+				if got, want := m.FindAllStringSubmatch(s, n), rx.FindAllStringSubmatch(s, n); !reflect.DeepEqual(got, want) {
+					t.Errorf("%q in %q: FindAllStringSubmatch(%d) = %q, expected %q", expr, s, n, got, want)
+				}
+				if got, want := m.Split(s, n), rx.Split(s, n); !reflect.DeepEqual(got, want) {
+					t.Errorf("%q in %q: Split(%d) = %q, expected %q", expr, s, n, got, want)
+				}
+			}
+			for _, tmpl := range []string{"<$0>", "${0}x", "$$", "$", "$1", "$2.$1", "${first}-"} {
+				if got, want := m.ReplaceAllString(s, tmpl), rx.ReplaceAllString(s, tmpl); got != want {
+					t.Errorf("%q in %q: ReplaceAllString(%q) = %q, expected %q", expr, s, tmpl, got, want)
+				}
+				if got, want := m.ReplaceAll([]byte(s), []byte(tmpl)), rx.ReplaceAll([]byte(s), []byte(tmpl)); string(got) != string(want) {
+					t.Errorf("%q in %q: ReplaceAll(%q) = %q, expected %q", expr, s, tmpl, got, want)
+				}
+			}
+			if got, want := m.ReplaceAllStringFunc(s, strings.ToUpper), rx.ReplaceAllStringFunc(s, strings.ToUpper); got != want {
+				t.Errorf("%q in %q: ReplaceAllStringFunc = %q, expected %q", expr, s, got, want)
+			}
+			if got, want := m.ReplaceAllLiteralString(s, "$0"), rx.ReplaceAllLiteralString(s, "$0"); got != want {
+				t.Errorf("%q in %q: ReplaceAllLiteralString = %q, expected %q", expr, s, got, want)
+			}
+		}
+	}
+}
+
+func TestReplaceGrammar(t *testing.T) {
+	m := matcher.MustCompile(`
+		Assign <- Name ' '* '=' ' '* Value
+		Name   <- [a-z]+
+		Value  <- [0-9]+
+	`)
+	got := m.ReplaceAllString("x = 1; long=42; 7 = y", "${Value}=$Name")
+	if want := "1=x; 42=long; 7 = y"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+
+	got = m.ReplaceAllString("a=1", "[$Missing$$${Name]")
+	if want := "[$${Name]"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+func TestCaptureIds(t *testing.T) {
+	m := matcher.MustNew(pattern.Concat(
+		pattern.Cap(pattern.Literal("a"), 1),
+		pattern.Cap(pattern.Plus(pattern.Literal("b")), 2),
+	))
+	got := m.ReplaceAllString("xabbyab", "$2$1")
+	if want := "xbbayba"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+
+	if got, want := m.FindStringSubmatch("xab"), []string{"ab", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+
+	caps := m.FindAllCaptures([]byte("abab"), -1)
+	if len(caps) != 2 || caps[1].Start() != 2 || caps[1].ChildById(2).End() != 4 {
+		t.Errorf("unexpected captures %v", caps)
+	}
+}
+
+func TestRegexpGroups(t *testing.T) {
+	m := matcher.MustCompileRegexp(`(?P<user>\w+)@(\w+)`)
+	got := m.ReplaceAllString("mail bob@example now", "$2 $user")
+	if want := "mail example bob now"; got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+	if got := m.SubexpIndex("user"); got != 1 {
+		t.Errorf("SubexpIndex = %d, expected 1", got)
+	}
+
+	// groups that are matched more than once span their last match.
+	m = matcher.MustCompileRegexp(`(?:(\w)\.)+`)
+	if got, want := m.FindStringSubmatch("a.b.c"), []string{"a.b.", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+}
+
+// The ID of the match is reserved.
+func TestReservedId(t *testing.T) {
+	_, err := matcher.New(pattern.Cap(pattern.Literal("a"), matcher.MatchId))
+	if err == nil {
+		t.Errorf("the reserved capture ID was accepted")
+	}
+}
+
+// The previous byte is visible to assertions when searching from an offset.
+func TestSearchOffset(t *testing.T) {
+	m := matcher.MustCompileRegexp(`\bx`)
+	got := m.FindAllStringIndex("xx x", -1)
+	if want := [][]int{{0, 1}, {3, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+}
@@ -8,11 +8,16 @@ import (
 const (
 	tNode = iota
 	tDummy
+	tSymbol
+	tScope
 )
 
 type Capture struct {
 	id  int32
 	typ int32
+	// whether a symbol definition that is not hidden by a scope is among
+	// the descendants of this capture.
+	syms bool
 
 	off      int
 	length   int
@@ -42,6 +47,98 @@ func NewCaptureDummy(start, length int, children []*Capture) *Capture {
 	return c
 }
 
+// NewCaptureNodeSymbols is like NewCaptureNode, but also records whether a
+// symbol definition is among the descendants of the capture, so that
+// FindSymbol finds it. It is used instead of NewCaptureNode by programs that
+// define symbols, so that the children of the captures of other programs are
+// not examined.
+func NewCaptureNodeSymbols(id int, start, length int, children []*Capture) *Capture {
+	c := NewCaptureNode(id, start, length, children)
+	c.syms = hasSymbols(children)
+	return c
+}
+
+// NewCaptureDummySymbols is like NewCaptureDummy, but records the symbol
+// definitions among the descendants of the capture like
+// NewCaptureNodeSymbols.
+func NewCaptureDummySymbols(start, length int, children []*Capture) *Capture {
+	c := NewCaptureDummy(start, length, children)
+	c.syms = hasSymbols(children)
+	return c
+}
+
+// NewCaptureSymbol returns a capture that defines the symbol id as the text
+// it spans, for back-references. Like a dummy capture, it is transparent
+// when iterating over the children of its parent.
+func NewCaptureSymbol(id int, start, length int, children []*Capture) *Capture {
+	c := &Capture{
+		id:       int32(id),
+		typ:      tSymbol,
+		off:      start,
+		length:   length,
+		children: children,
+		syms:     true,
+	}
+	return c
+}
+
+// NewCaptureScope returns a transparent capture that hides the symbols
+// defined by its descendants from FindSymbol.
+func NewCaptureScope(start, length int, children []*Capture) *Capture {
+	c := &Capture{
+		id:       0,
+		typ:      tScope,
+		off:      start,
+		length:   length,
+		children: children,
+	}
+	return c
+}
+
+func hasSymbols(capt []*Capture) bool {
+	for _, c := range capt {
+		if c.syms {
+			return true
+		}
+	}
+	return false
+}
+
+// FindSymbol returns the symbol capture with the given id that was defined
+// last among capt and their descendants, or nil if there is none. Symbols
+// inside scope captures are not visible.
+func FindSymbol(capt []*Capture, id int) *Capture {
+	for i := len(capt) - 1; i >= 0; i-- {
+		c := capt[i]
+		if !c.syms {
+			continue
+		}
+		// a definition ends after the definitions it contains.
+		if c.typ == tSymbol && int(c.id) == id {
+			return c
+		}
+		if s := FindSymbol(c.children, id); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// VisibleSymbols calls fn with each symbol capture among capt and their
+// descendants that is not inside a scope capture, in the order in which they
+// were defined.
+func VisibleSymbols(capt []*Capture, fn func(s *Capture)) {
+	for _, c := range capt {
+		if !c.syms {
+			continue
+		}
+		VisibleSymbols(c.children, fn)
+		if c.typ == tSymbol {
+			fn(c)
+		}
+	}
+}
+
 func (c *Capture) ChildIterator(start int) func() *Capture {
 	i := 0
 	var subit, ret func() *Capture
@@ -50,7 +147,7 @@ func (c *Capture) ChildIterator(start int) func() *Capture {
 			return nil
 		}
 		ch := c.children[i]
-		if ch.Dummy() && subit == nil {
+		if ch.Transparent() && subit == nil {
 			subit = ch.ChildIterator(ch.off)
 		}
 		if subit != nil {
@@ -83,7 +180,7 @@ func (c *Capture) Child(n int) *Capture {
 func (c *Capture) NumChildren() int {
 	nchild := 0
 	for _, ch := range c.children {
-		if ch.Dummy() {
+		if ch.Transparent() {
 			nchild += ch.NumChildren()
 		} else {
 			nchild++
@@ -92,6 +189,56 @@ func (c *Capture) NumChildren() int {
 	return nchild
 }
 
+// A Namer resolves the names of capture IDs. It is implemented by vm.Code.
+type Namer interface {
+	CaptureName(id int) (string, bool)
+	CaptureId(name string) (int, bool)
+}
+
+// ChildById returns the first child of this node with the given ID, or nil if
+// there is none.
+func (c *Capture) ChildById(id int) *Capture {
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		if ch.Id() == id {
+			return ch
+		}
+	}
+	return nil
+}
+
+// ChildrenById returns all children of this node with the given ID.
+func (c *Capture) ChildrenById(id int) []*Capture {
+	var children []*Capture
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		if ch.Id() == id {
+			children = append(children, ch)
+		}
+	}
+	return children
+}
+
+// ChildByName returns the first child of this node whose ID has the given
+// name in n, or nil if there is none.
+func (c *Capture) ChildByName(name string, n Namer) *Capture {
+	id, ok := n.CaptureId(name)
+	if !ok {
+		return nil
+	}
+	return c.ChildById(id)
+}
+
+// ChildrenByName returns all children of this node whose ID has the given
+// name in n.
+func (c *Capture) ChildrenByName(name string, n Namer) []*Capture {
+	id, ok := n.CaptureId(name)
+	if !ok {
+		return nil
+	}
+	return c.ChildrenById(id)
+}
+
 func (c *Capture) Start() int {
 	if c.ment != nil {
 		return c.ment.pos.Pos() + c.off
@@ -111,6 +258,13 @@ func (c *Capture) Dummy() bool {
 	return c.typ == tDummy
 }
 
+// Transparent returns true if iterating over the children of the parent of
+// the capture yields the children of the capture in its place. Dummy
+// captures, and the captures that define and scope symbols, are transparent.
+func (c *Capture) Transparent() bool {
+	return c.typ != tNode
+}
+
 func (c *Capture) Id() int {
 	return int(c.id)
 }
@@ -0,0 +1,179 @@
+package memo
+
+import "sort"
+
+// A Range is the interval [Start, End) of the subject string.
+type Range struct {
+	Start, End int
+}
+
+// A Snapshot records the layout of a capture tree at the time it was taken.
+// The positions of captures that belong to memoization entries move when the
+// memoization table is edited, so a tree must be snapshotted before the edit
+// if it is to be compared with the tree produced by a reparse.
+type Snapshot struct {
+	root snapNode
+}
+
+type snapNode struct {
+	capt     *Capture
+	id       int
+	start    int
+	end      int
+	children []snapNode
+}
+
+// NewSnapshot records the layout of the capture tree rooted at root. A nil
+// root is treated as a tree with no captures.
+func NewSnapshot(root *Capture) *Snapshot {
+	if root == nil {
+		return &Snapshot{}
+	}
+	return &Snapshot{
+		root: snapshot(root),
+	}
+}
+
+func snapshot(c *Capture) snapNode {
+	n := snapNode{
+		capt:  c,
+		id:    c.Id(),
+		start: c.Start(),
+		end:   c.End(),
+	}
+	it := c.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		n.children = append(n.children, snapshot(ch))
+	}
+	return n
+}
+
+// ApplyEdit updates the positions in the snapshot to account for an edit of
+// the subject string. Edits should be applied to the snapshot in the same
+// order as they are applied to the memoization table.
+func (s *Snapshot) ApplyEdit(e Edit) {
+	s.root.applyEdit(e)
+}
+
+func (n *snapNode) applyEdit(e Edit) {
+	if n.end < e.Start {
+		return
+	}
+	// a capture that touches the edit may have been changed by it and can no
+	// longer be identified with a capture in the new tree
+	if n.start <= e.End {
+		n.capt = nil
+	}
+	n.start = shiftPos(n.start, e)
+	n.end = shiftPos(n.end, e)
+	for i := range n.children {
+		n.children[i].applyEdit(e)
+	}
+}
+
+// shiftPos returns the position that pos moves to after the edit e. Positions
+// inside the modified interval are moved to the end of the replacement text.
+func shiftPos(pos int, e Edit) int {
+	if pos <= e.Start {
+		return pos
+	} else if pos >= e.End {
+		return pos + e.Len - (e.End - e.Start)
+	}
+	return e.Start + e.Len
+}
+
+// ChangedRanges compares the snapshotted tree with the tree rooted at root,
+// which should be the result of reparsing the subject after the edits that
+// were applied to the snapshot. It returns a minimal sorted list of disjoint
+// ranges (in the coordinates of the new subject) in which the structure of
+// the captures differs between the two trees. Subtrees that were reused from
+// the memoization table and were not touched by an edit are skipped without
+// being examined. Changes in the subject that did not affect any capture are
+// not reported.
+func (s *Snapshot) ChangedRanges(root *Capture) []Range {
+	var ranges []Range
+	if root == nil {
+		root = NewCaptureDummy(0, 0, nil)
+	}
+	s.root.diffChildren(root, &ranges)
+	return mergeRanges(ranges)
+}
+
+// ChangedRanges returns the ranges in which the structure of the captures in
+// new differs from old. Since captures belonging to memoization entries are
+// shifted by edits, old must not have been edited since it was produced; use
+// a Snapshot to compare trees across an edit.
+func ChangedRanges(old, new *Capture) []Range {
+	return NewSnapshot(old).ChangedRanges(new)
+}
+
+func (n *snapNode) diff(c *Capture, ranges *[]Range) {
+	if n.capt == c && n.start == c.Start() && n.end == c.End() {
+		// the capture was reused from the memoization table
+		return
+	}
+	n.diffChildren(c, ranges)
+	if n.end != c.End() {
+		*ranges = append(*ranges, Range{min(n.end, c.End()), max(n.end, c.End())})
+	}
+}
+
+// diffChildren matches up the children of n and c by start position and ID
+// and records the ranges of children that only occur in one of the trees.
+func (n *snapNode) diffChildren(c *Capture, ranges *[]Range) {
+	it := c.ChildIterator(0)
+	ch := it()
+	i := 0
+	for i < len(n.children) && ch != nil {
+		och := &n.children[i]
+		switch {
+		case och.start == ch.Start() && och.id == ch.Id():
+			och.diff(ch, ranges)
+			i++
+			ch = it()
+		case och.start < ch.Start():
+			*ranges = append(*ranges, Range{och.start, och.end})
+			i++
+		case och.start > ch.Start():
+			*ranges = append(*ranges, Range{ch.Start(), ch.End()})
+			ch = it()
+		default:
+			*ranges = append(*ranges, Range{och.start, max(och.end, ch.End())})
+			i++
+			ch = it()
+		}
+	}
+	for ; i < len(n.children); i++ {
+		*ranges = append(*ranges, Range{n.children[i].start, n.children[i].end})
+	}
+	for ; ch != nil; ch = it() {
+		*ranges = append(*ranges, Range{ch.Start(), ch.End()})
+	}
+}
+
+// mergeRanges sorts the ranges and coalesces any that overlap or touch.
+func mergeRanges(ranges []Range) []Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End {
+			last.End = max(last.End, r.End)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
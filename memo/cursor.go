@@ -0,0 +1,212 @@
+package memo
+
+import "sort"
+
+// A TreeCursor walks a capture tree. Captures may be shared between trees
+// when they are reused from the memoization table, so they do not store
+// parent links. Instead the cursor records the path from the root to the
+// current node. Like ChildIterator, the cursor skips over dummy nodes, so the
+// children of a node are the children of its dummy children spliced in place.
+type TreeCursor struct {
+	root   *Capture
+	frames []frame
+}
+
+// A frame refers to the child at index idx of parent. If flat is true, the
+// parent is a dummy node that has been spliced into the frame below it.
+type frame struct {
+	parent *Capture
+	idx    int
+	flat   bool
+}
+
+// NewTreeCursor returns a cursor positioned at root.
+func NewTreeCursor(root *Capture) *TreeCursor {
+	return &TreeCursor{
+		root: root,
+	}
+}
+
+// Node returns the capture that the cursor is positioned at.
+func (t *TreeCursor) Node() *Capture {
+	if len(t.frames) == 0 {
+		return t.root
+	}
+	f := t.frames[len(t.frames)-1]
+	return f.parent.children[f.idx]
+}
+
+// Depth returns the number of ancestors of the current node.
+func (t *TreeCursor) Depth() int {
+	depth := 0
+	for _, f := range t.frames {
+		if !f.flat {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Reset positions the cursor at the root.
+func (t *TreeCursor) Reset() {
+	t.frames = t.frames[:0]
+}
+
+// Parent returns the parent of the current node, or nil if the cursor is at
+// the root.
+func (t *TreeCursor) Parent() *Capture {
+	for i := len(t.frames) - 1; i >= 0; i-- {
+		if !t.frames[i].flat {
+			return t.frames[i].parent
+		}
+	}
+	return nil
+}
+
+// GotoParent moves the cursor to the parent of the current node. Returns
+// false if the cursor is at the root.
+func (t *TreeCursor) GotoParent() bool {
+	for i := len(t.frames) - 1; i >= 0; i-- {
+		if !t.frames[i].flat {
+			t.frames = t.frames[:i]
+			return true
+		}
+	}
+	return false
+}
+
+// GotoFirstChild moves the cursor to the first child of the current node.
+// Returns false if the current node has no children.
+func (t *TreeCursor) GotoFirstChild() bool {
+	return t.gotoChild(-1, (*TreeCursor).next)
+}
+
+// GotoLastChild moves the cursor to the last child of the current node.
+// Returns false if the current node has no children.
+func (t *TreeCursor) GotoLastChild() bool {
+	return t.gotoChild(len(t.Node().children), (*TreeCursor).prev)
+}
+
+func (t *TreeCursor) gotoChild(idx int, move func(*TreeCursor) bool) bool {
+	n := len(t.frames)
+	t.frames = append(t.frames, frame{
+		parent: t.Node(),
+		idx:    idx,
+	})
+	if !move(t) {
+		t.frames = t.frames[:n]
+		return false
+	}
+	return true
+}
+
+// GotoNextSibling moves the cursor to the next sibling of the current node.
+// Returns false if there is no next sibling.
+func (t *TreeCursor) GotoNextSibling() bool {
+	return t.gotoSibling((*TreeCursor).next)
+}
+
+// GotoPrevSibling moves the cursor to the previous sibling of the current
+// node. Returns false if there is no previous sibling.
+func (t *TreeCursor) GotoPrevSibling() bool {
+	return t.gotoSibling((*TreeCursor).prev)
+}
+
+func (t *TreeCursor) gotoSibling(move func(*TreeCursor) bool) bool {
+	if len(t.frames) == 0 {
+		return false
+	}
+	saved := append([]frame(nil), t.frames...)
+	if !move(t) {
+		t.frames = saved
+		return false
+	}
+	return true
+}
+
+// next advances the top frame to the next non-dummy node, entering and
+// leaving dummy nodes as necessary. The frames are left in an unspecified
+// state if there is no such node.
+func (t *TreeCursor) next() bool {
+	for {
+		f := &t.frames[len(t.frames)-1]
+		f.idx++
+		if f.idx < len(f.parent.children) {
+			ch := f.parent.children[f.idx]
+			if !ch.Transparent() {
+				return true
+			}
+			t.frames = append(t.frames, frame{
+				parent: ch,
+				idx:    -1,
+				flat:   true,
+			})
+		} else if f.flat {
+			t.frames = t.frames[:len(t.frames)-1]
+		} else {
+			return false
+		}
+	}
+}
+
+// prev is like next but moves backwards.
+func (t *TreeCursor) prev() bool {
+	for {
+		f := &t.frames[len(t.frames)-1]
+		f.idx--
+		if f.idx >= 0 {
+			ch := f.parent.children[f.idx]
+			if !ch.Transparent() {
+				return true
+			}
+			t.frames = append(t.frames, frame{
+				parent: ch,
+				idx:    len(ch.children),
+				flat:   true,
+			})
+		} else if f.flat {
+			t.frames = t.frames[:len(t.frames)-1]
+		} else {
+			return false
+		}
+	}
+}
+
+// GotoFirstChildForPos moves the cursor to the child of the current node that
+// contains the position pos. Since the children of a node are sorted and do
+// not overlap, the child is found with a binary search at each level of dummy
+// nodes. Returns false if no child contains pos.
+func (t *TreeCursor) GotoFirstChildForPos(pos int) bool {
+	n := len(t.frames)
+	parent := t.Node()
+	flat := false
+	for {
+		children := parent.children
+		i := sort.Search(len(children), func(i int) bool {
+			return children[i].Start() > pos
+		}) - 1
+		if i < 0 || children[i].End() <= pos {
+			t.frames = t.frames[:n]
+			return false
+		}
+		t.frames = append(t.frames, frame{
+			parent: parent,
+			idx:    i,
+			flat:   flat,
+		})
+		if !children[i].Transparent() {
+			return true
+		}
+		parent = children[i]
+		flat = true
+	}
+}
+
+// DescendToPos moves the cursor to the smallest node below the current node
+// that contains the position pos and returns it. If no child of the current
+// node contains pos, the cursor does not move.
+func (t *TreeCursor) DescendToPos(pos int) *Capture {
+	for t.GotoFirstChildForPos(pos) {
+	}
+	return t.Node()
+}
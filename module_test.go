@@ -0,0 +1,120 @@
+package gpeg
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+var modules = fstest.MapFS{
+	"lib/common.peg": {Data: []byte(`
+		import "chars.peg" as ch
+		Identifier <- !Keyword ch.Letter+ Spacing
+		Keyword    <- ('if' / 'else') !ch.Letter
+		Number     <- [0-9]+ Spacing
+		Spacing    <- ' '*
+	`)},
+	"lib/chars.peg": {Data: []byte(`
+		Letter <- [a-z]
+	`)},
+	"cycle.peg":  {Data: []byte(`import "cycle2.peg" as c  A <- c.B`)},
+	"cycle2.peg": {Data: []byte(`import "cycle.peg" as c  B <- c.A`)},
+	"bad.peg":    {Data: []byte("A <- 'a'\nB <- =x")},
+	"expr.peg":   {Data: []byte(`'a'`)},
+}
+
+func TestReImport(t *testing.T) {
+	tests := []struct {
+		patt  string
+		tests []PatternTest
+	}{
+		{`import "lib/common.peg" as c
+		  Assign <- c.Identifier '=' c.Spacing c.Number !.`, []PatternTest{
+			{"x = 1", 5},
+			{"if = 1", -1},
+			{"let = 1", 7},
+		}},
+		// extensions are tried before the rule they extend, and are used
+		// by the rules of the module.
+		{`import "lib/common.peg" as c
+		  Assign <- c.Identifier '=' c.Spacing c.Number !.
+		  c.Keyword += 'let' !c.ch.Letter`, []PatternTest{
+			{"x = 1", 5},
+			{"let = 1", -1},
+			{"lets = 1", 8},
+		}},
+		{`import "lib/common.peg" as c
+		  List <- c.Number (',' c.Spacing c.Number)* !.
+		  c.Spacing <- [ \t]*`, []PatternTest{
+			{"1,\t2 , 3", 8},
+		}},
+		// rules of different modules and instances of a module do not clash.
+		{`import "lib/common.peg" as c
+		  import "lib/chars.peg" as d
+		  S <- Letter c.ch.Letter d.Letter
+		  Letter <- [A-Z]
+		  c.ch.Letter <- [0-9]`, []PatternTest{
+			{"A1b", 3},
+			{"Abb", -1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patt, func(t *testing.T) {
+			p, err := re.CompileFS(tt.patt, modules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(p, tt.tests, t)
+		})
+	}
+
+	ids := make(map[string]int)
+	if _, err := re.CompileCapFS(`import "lib/common.peg" as c  S <- c.Number`, modules, ids); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ids["c.ch.Letter"]; !ok {
+		t.Errorf("no capture id for c.ch.Letter in %v", ids)
+	}
+}
+
+func TestReImportError(t *testing.T) {
+	tests := []struct {
+		patt string
+		msg  string
+	}{
+		{`import "lib/common.peg" as c  S <- d.Number`, "35: unknown module d"},
+		{`import "lib/common.peg" as c  S <- c.Numbers`, "35: undefined rule c.Numbers"},
+		{`import "lib/common.peg" as c  c.Other <- 'a'`, "30: undefined rule c.Other"},
+		{`import "lib/common.peg" as c  import "expr.peg" as c  S <- 'a'`, "51: duplicate import name c"},
+		{`import "lib/common.peg" as c  c.Number`, "30: only a grammar can import modules"},
+		{`import "expr.peg" as e  S <- 'a'`, "0: module expr.peg is not a grammar"},
+		{`import "cycle.peg" as c  S <- c.A`, "cycle.peg: cycle2.peg: 0: import cycle through cycle.peg"},
+		{`import "bad.peg" as b  S <- b.A`, "bad.peg: 14: undefined symbol x"},
+	}
+	for _, tt := range tests {
+		_, err := re.CompileFS(tt.patt, modules)
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("%q: got error %v, expected %q", tt.patt, err, tt.msg)
+		}
+	}
+
+	_, err := re.CompileFS(`import "missing.peg" as m  S <- 'a'`, modules)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, expected %v", err, fs.ErrNotExist)
+	}
+
+	_, err = re.CompileFS(`import "bad.peg" as b  S <- b.A`, modules)
+	var ierr *re.ImportError
+	var perr vm.ParseError
+	if !errors.As(err, &ierr) || ierr.Path != "bad.peg" || !errors.As(err, &perr) || perr.Pos != 14 {
+		t.Errorf("got error %v, expected an import error for bad.peg", err)
+	}
+
+	if _, err := re.Compile(`import "lib/common.peg" as c  S <- c.Number`); err == nil {
+		t.Errorf("imported a module without a file system")
+	}
+}
@@ -0,0 +1,285 @@
+package pattern
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A DiagnosticKind is a kind of problem found by Analyze.
+type DiagnosticKind int
+
+const (
+	// InfiniteLoop is an unbounded repetition of a pattern that can succeed
+	// without consuming any input, which never terminates.
+	InfiniteLoop DiagnosticKind = iota
+	// UndefinedRule is a reference to a rule that the grammar does not
+	// define.
+	UndefinedRule
+	// LeftRecursion is a rule that can call itself before consuming any
+	// input. Left-recursive rules are supported, but they are slower than
+	// repetitions and are often written by accident.
+	LeftRecursion
+	// UnreachableRule is a rule that cannot be reached from the start rule
+	// of its grammar.
+	UnreachableRule
+)
+
+func (k DiagnosticKind) String() string {
+	switch k {
+	case InfiniteLoop:
+		return "infinite loop"
+	case UndefinedRule:
+		return "undefined rule"
+	case LeftRecursion:
+		return "left recursion"
+	case UnreachableRule:
+		return "unreachable rule"
+	}
+	return fmt.Sprintf("DiagnosticKind(%d)", int(k))
+}
+
+// A Diagnostic is a problem found in a pattern by Analyze.
+type Diagnostic struct {
+	Kind DiagnosticKind
+	// Rule is the name of the rule that the problem is in, or the empty
+	// string if it is not in a grammar. For undefined rules, Name is the
+	// name of the rule that is referred to.
+	Rule, Name string
+	// Start and End are the source location of the problem, as recorded by
+	// the closest enclosing SourceNode, or -1 if it is not known.
+	Start, End int
+}
+
+// Warning returns true if the pattern can still be compiled and run despite
+// the problem.
+func (d Diagnostic) Warning() bool {
+	return d.Kind == LeftRecursion || d.Kind == UnreachableRule
+}
+
+// Error returns the message of the diagnostic.
+func (d Diagnostic) Error() string {
+	msg := d.Kind.String()
+	switch d.Kind {
+	case InfiniteLoop:
+		msg = "repetition of a pattern that can match the empty string"
+	case UndefinedRule:
+		msg += " " + d.Name
+	}
+	if d.Rule != "" {
+		msg = "rule " + d.Rule + ": " + msg
+	}
+	return msg
+}
+
+// Nullable returns true if p can succeed without consuming any input. The
+// analysis is conservative: patterns such as back-references, which may or
+// may not consume input, are considered nullable.
+func Nullable(p Pattern) bool {
+	return nullable(p, nil)
+}
+
+// Analyze checks that p is well-formed and returns the problems that it
+// finds, sorted by source location. Repetitions of nullable patterns and
+// references to undefined rules are errors, while left-recursive and
+// unreachable rules are warnings. Since nullability is conservative, a
+// repetition that only makes progress through error recovery, or through
+// back-references, is also reported.
+func Analyze(p Pattern) []Diagnostic {
+	a := &analyzer{
+		start: -1,
+		end:   -1,
+	}
+	a.walk(p)
+	sort.SliceStable(a.diags, func(i, j int) bool {
+		if a.diags[i].Start != a.diags[j].Start {
+			return a.diags[i].Start < a.diags[j].Start
+		}
+		return a.diags[i].Kind < a.diags[j].Kind
+	})
+	return a.diags
+}
+
+// An analyzer walks a pattern while tracking the innermost grammar, rule and
+// source location.
+type analyzer struct {
+	diags []Diagnostic
+
+	g        *GrammarNode
+	nullable map[string]bool
+	rule     string
+	// the rules referred to by each rule of g.
+	refs       map[string]map[string]bool
+	start, end int
+}
+
+func (a *analyzer) report(kind DiagnosticKind, name string) {
+	a.diags = append(a.diags, Diagnostic{
+		Kind:  kind,
+		Rule:  a.rule,
+		Name:  name,
+		Start: a.start,
+		End:   a.end,
+	})
+}
+
+func (a *analyzer) walk(p Pattern) {
+	switch t := p.(type) {
+	case *SourceNode:
+		start, end := a.start, a.end
+		a.start, a.end = t.Start, t.End
+		a.walk(t.Patt)
+		a.start, a.end = start, end
+	case *AltNode:
+		a.walk(t.Left)
+		a.walk(t.Right)
+	case *SeqNode:
+		a.walk(t.Left)
+		a.walk(t.Right)
+	case *StarNode:
+		a.loop(t.Patt)
+	case *PlusNode:
+		a.loop(t.Patt)
+	case *RepeatNode:
+		if t.Max == -1 {
+			a.loop(t.Patt)
+		} else {
+			a.walk(t.Patt)
+		}
+	case *OptionalNode:
+		a.walk(t.Patt)
+	case *NotNode:
+		a.walk(t.Patt)
+	case *AndNode:
+		a.walk(t.Patt)
+	case *CapNode:
+		a.walk(t.Patt)
+	case *MemoNode:
+		a.walk(t.Patt)
+	case *SearchNode:
+		a.walk(t.Patt)
+	case *CheckNode:
+		a.walk(t.Patt)
+	case *ErrorNode:
+		if t.Recover != nil {
+			a.walk(t.Recover)
+		}
+	case *CatchNode:
+		a.walk(t.Patt)
+		a.walk(t.Handler)
+	case *ThrowNode:
+		if a.g != nil {
+			if rule, ok := a.g.recovery(t.Label); ok {
+				a.ref(rule)
+			}
+		}
+	case *NonTermNode:
+		a.ref(t.Name)
+	case *GrammarNode:
+		a.grammar(t)
+	}
+}
+
+// Checks the repeated pattern p.
+func (a *analyzer) loop(p Pattern) {
+	if nullable(p, a.nullable) {
+		a.report(InfiniteLoop, "")
+	}
+	a.walk(p)
+}
+
+// Records a reference to the rule name of the current grammar.
+func (a *analyzer) ref(name string) {
+	if a.g == nil {
+		a.report(UndefinedRule, name)
+		return
+	}
+	if _, ok := a.g.Defs[name]; !ok {
+		a.report(UndefinedRule, name)
+		return
+	}
+	a.refs[a.rule][name] = true
+}
+
+func (a *analyzer) grammar(g *GrammarNode) {
+	outer := *a
+	a.g = g
+	a.nullable = g.nullableRules()
+	a.refs = make(map[string]map[string]bool)
+
+	names := make([]string, 0, len(g.Defs))
+	for name := range g.Defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if _, ok := g.Defs[g.Start]; !ok {
+		a.report(UndefinedRule, g.Start)
+	}
+	for _, label := range sortedKeys(g.Recover) {
+		if _, ok := g.Defs[g.Recover[label]]; !ok {
+			a.report(UndefinedRule, g.Recover[label])
+		}
+	}
+
+	locs := make(map[string][2]int)
+	for _, name := range names {
+		a.rule = name
+		a.start, a.end = location(g.Defs[name], outer.start, outer.end)
+		locs[name] = [2]int{a.start, a.end}
+		a.refs[name] = make(map[string]bool)
+		a.walk(g.Defs[name])
+	}
+
+	lr := g.leftRecursive()
+	reached := map[string]bool{g.Start: true}
+	work := []string{g.Start}
+	for len(work) > 0 {
+		next := work[len(work)-1]
+		work = work[:len(work)-1]
+		for callee := range a.refs[next] {
+			if !reached[callee] {
+				reached[callee] = true
+				work = append(work, callee)
+			}
+		}
+	}
+	for _, name := range names {
+		a.rule = name
+		a.start, a.end = locs[name][0], locs[name][1]
+		if lr[name] {
+			a.report(LeftRecursion, "")
+		}
+		if !reached[name] {
+			a.report(UnreachableRule, "")
+		}
+	}
+
+	diags := a.diags
+	*a = outer
+	a.diags = diags
+}
+
+// Returns the source location of the definition of a rule, which is recorded
+// by a SourceNode that may be wrapped in captures, or start and end if there
+// is none.
+func location(p Pattern, start, end int) (int, int) {
+	for {
+		switch t := p.(type) {
+		case *SourceNode:
+			return t.Start, t.End
+		case *CapNode:
+			p = t.Patt
+		default:
+			return start, end
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,6 +2,8 @@ package pattern
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/zyedidia/gpeg/charset"
 	"github.com/zyedidia/gpeg/isa"
@@ -16,16 +18,31 @@ type NotFoundError struct {
 // Error returns the error message.
 func (e *NotFoundError) Error() string { return "non-terminal " + e.Name + ": not found" }
 
+// A RepeatError means a bounded repetition was given invalid bounds.
+type RepeatError struct {
+	Min, Max int
+}
+
+// Error returns the error message.
+func (e *RepeatError) Error() string {
+	return fmt.Sprintf("repetition {%d,%d}: invalid bounds", e.Min, e.Max)
+}
+
 // Compile takes an input pattern and returns the result of compiling it into a
-// parsing program, and optimizing the program.
+// parsing program, and optimizing the program. Compile does not check that
+// the program terminates: use Analyze to find problems such as repetitions of
+// patterns that match the empty string before compiling.
 func Compile(p Pattern) (isa.Program, error) {
-	c, err := p.Compile()
+	c := &compiler{
+		disp: dispatchChoices(p),
+	}
+	code, err := c.node(p)
 	if err != nil {
 		return nil, err
 	}
 
-	Optimize(c)
-	return c, nil
+	Optimize(code)
+	return code, nil
 }
 
 // MustCompile is the same as Compile but panics if there is an error during
@@ -49,8 +66,69 @@ func (i openCall) String() string {
 	return fmt.Sprintf("OpenCall %v", i.name)
 }
 
+// A compiler holds what is known about a pattern while it is compiled: how
+// its choices can be dispatched on the next byte. It is kept apart from the
+// nodes of the pattern, which may be shared with other patterns and other
+// compilations.
+type compiler struct {
+	disp map[Pattern]*dispatch
+}
+
+// A node that compiles its subpatterns with a compiler.
+type compilable interface {
+	compile(c *compiler) (isa.Program, error)
+}
+
+// Compiles p, which has been read with Get.
+func (c *compiler) node(p Pattern) (isa.Program, error) {
+	if t, ok := p.(compilable); ok {
+		return t.compile(c)
+	}
+	return p.Compile()
+}
+
+// Compiles p. Unlike c.node(Get(p)), this keeps the source locations recorded
+// by SourceNodes.
+func (c *compiler) compile(p Pattern) (isa.Program, error) {
+	if t, ok := p.(*SourceNode); ok {
+		sub, err := c.compile(t.Patt)
+		return t.mark(sub), err
+	}
+	return c.node(Get(p))
+}
+
+// Compile this node.
+func (p *SourceNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *SourceNode) compile(c *compiler) (isa.Program, error) {
+	sub, err := c.node(p.Patt)
+	return p.mark(sub), err
+}
+
+// Surrounds the code for this node with markers that record its location.
+func (p *SourceNode) mark(sub isa.Program) isa.Program {
+	if sub.Size() == 0 {
+		return sub
+	}
+	code := make(isa.Program, 0, len(sub)+2)
+	code = append(code, isa.NodeBegin{
+		Node:  p.Patt,
+		Start: p.Start,
+		End:   p.End,
+	})
+	code = append(code, sub...)
+	code = append(code, isa.NodeEnd{})
+	return code
+}
+
 // Compile this node.
 func (p *AltNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *AltNode) compile(c *compiler) (isa.Program, error) {
 	// optimization: if Left and Right are charsets/single chars, return the union
 	set, ok := combine(Get(p.Left), Get(p.Right))
 	if ok {
@@ -59,8 +137,15 @@ func (p *AltNode) Compile() (isa.Program, error) {
 		}, nil
 	}
 
-	l, err1 := Get(p.Left).Compile()
-	r, err2 := Get(p.Right).Compile()
+	// optimization: leading alternatives that are literals are matched by
+	// a trie.
+	strs, rest := literalChoice(p)
+	if len(strs) >= LiteralsThreshold {
+		return c.literals(strs, rest)
+	}
+
+	l, err1 := c.compile(p.Left)
+	r, err2 := c.compile(p.Right)
 	if err1 != nil {
 		return nil, err1
 	}
@@ -98,8 +183,16 @@ func (p *AltNode) Compile() (isa.Program, error) {
 	L2 := isa.NewLabel()
 	code := make(isa.Program, 0, len(l)+len(r)+5)
 	if disjoint {
+		// the test instruction replaces the first instruction of l.
+		i := skipMarkers(l)
+		code = append(code, l[:i]...)
 		code = append(code, testinsn)
-		code = append(code, l[1:]...)
+		code = append(code, l[i+1:]...)
+		code = append(code, isa.Jump{Lbl: L2})
+	} else if d := c.disp[p]; d.disjoint() {
+		// optimization: the next byte decides which alternative to match.
+		code = append(code, isa.PeekSet{Chars: d.left, Lbl: L1})
+		code = append(code, l...)
 		code = append(code, isa.Jump{Lbl: L2})
 	} else {
 		code = append(code, isa.Choice{Lbl: L1})
@@ -112,10 +205,75 @@ func (p *AltNode) Compile() (isa.Program, error) {
 	return code, nil
 }
 
+// Returns the strings of the leading alternatives of p that are literals,
+// and the choice between the alternatives that follow them, which is nil if
+// there are none.
+func literalChoice(p *AltNode) ([]string, Pattern) {
+	var strs []string
+	var rest Pattern = p
+	for rest != nil {
+		left, right := rest, Pattern(nil)
+		if alt, ok := unwrap(rest).(*AltNode); ok {
+			left, right = alt.Left, alt.Right
+		}
+		lit, ok := Get(left).(*LiteralNode)
+		if !ok {
+			break
+		}
+		strs = append(strs, lit.Str)
+		rest = right
+	}
+	return strs, rest
+}
+
+// Compiles the ordered choice between the literals strs and rest, which may
+// be nil.
+func (c *compiler) literals(strs []string, rest Pattern) (isa.Program, error) {
+	// a string that starts with an earlier string can never be matched, and
+	// the other strings that the subject starts with are prefixes of each
+	// other, so the first of them is also the longest.
+	var reachable []string
+	for _, s := range strs {
+		shadowed := false
+		for _, prev := range reachable {
+			if strings.HasPrefix(s, prev) {
+				shadowed = true
+				break
+			}
+		}
+		if !shadowed {
+			reachable = append(reachable, s)
+		}
+	}
+	lits := isa.Literals{Strs: reachable}
+	if rest == nil {
+		return isa.Program{lits}, nil
+	}
+
+	r, err := c.compile(rest)
+	if err != nil {
+		return nil, err
+	}
+	L1 := isa.NewLabel()
+	L2 := isa.NewLabel()
+	code := make(isa.Program, 0, len(r)+5)
+	code = append(code, isa.Choice{Lbl: L1})
+	code = append(code, lits)
+	code = append(code, isa.Commit{Lbl: L2})
+	code = append(code, L1)
+	code = append(code, r...)
+	code = append(code, L2)
+	return code, nil
+}
+
 // Compile this node.
 func (p *SeqNode) Compile() (isa.Program, error) {
-	l, err1 := Get(p.Left).Compile()
-	r, err2 := Get(p.Right).Compile()
+	return p.compile(&compiler{})
+}
+
+func (p *SeqNode) compile(c *compiler) (isa.Program, error) {
+	l, err1 := c.compile(p.Left)
+	r, err2 := c.compile(p.Right)
 	if err1 != nil {
 		return nil, err1
 	}
@@ -128,6 +286,10 @@ func (p *SeqNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *StarNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *StarNode) compile(c *compiler) (isa.Program, error) {
 	switch t := Get(p.Patt).(type) {
 	case *ClassNode:
 		// optimization: repeating a charset uses the dedicated instruction 'span'
@@ -138,7 +300,7 @@ func (p *StarNode) Compile() (isa.Program, error) {
 		// optimization: if the pattern we are repeating is a memoization
 		// entry, we should use special instructions to memoize it as a tree to
 		// get logarithmic saving when reparsing.
-		sub, err := Get(t.Patt).Compile()
+		sub, err := c.compile(t.Patt)
 		code := make(isa.Program, 0, len(sub)+7)
 		L1 := isa.NewLabel()
 		L2 := isa.NewLabel()
@@ -161,7 +323,7 @@ func (p *StarNode) Compile() (isa.Program, error) {
 		return code, err
 	}
 
-	sub, err := Get(p.Patt).Compile()
+	sub, err := c.compile(p.Patt)
 	code := make(isa.Program, 0, len(sub)+4)
 
 	L1 := isa.NewLabel()
@@ -176,9 +338,13 @@ func (p *StarNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *PlusNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *PlusNode) compile(c *compiler) (isa.Program, error) {
 	starp := Star(Get(p.Patt))
-	star, err1 := starp.Compile()
-	sub, err2 := Get(p.Patt).Compile()
+	star, err1 := c.node(starp)
+	sub, err2 := c.compile(p.Patt)
 	if err1 != nil {
 		return nil, err1
 	}
@@ -192,8 +358,123 @@ func (p *PlusNode) Compile() (isa.Program, error) {
 	return code, nil
 }
 
+// the largest count that can be encoded in a RepeatOpen instruction.
+const maxRepeat = 1 << 24
+
+// Compile this node.
+func (p *RepeatNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *RepeatNode) compile(c *compiler) (isa.Program, error) {
+	if p.Min < 0 || p.Min >= maxRepeat || p.Max >= maxRepeat || p.Max != -1 && p.Max < p.Min {
+		return nil, &RepeatError{
+			Min: p.Min,
+			Max: p.Max,
+		}
+	}
+
+	sub, err := c.compile(p.Patt)
+	if err != nil {
+		return nil, err
+	}
+
+	code := make(isa.Program, 0, len(sub)+8)
+	if p.Min > 0 {
+		var mandatory isa.Program
+		if p.Min*sub.Size() <= RepeatUnrollThreshold {
+			mandatory, err = c.unroll(p.Patt, sub, p.Min)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// the mandatory repetitions use a counter that loops over a
+			// single copy of the pattern.
+			L1 := isa.NewLabel()
+			mandatory = make(isa.Program, 0, len(sub)+3)
+			mandatory = append(mandatory, isa.RepeatOpen{N: p.Min})
+			mandatory = append(mandatory, L1)
+			mandatory = append(mandatory, sub...)
+			mandatory = append(mandatory, isa.RepeatNext{Lbl: L1})
+		}
+		code = append(code, mandatory...)
+		// the first copy of sub has been used so further copies must be
+		// recompiled to get fresh labels.
+		sub = nil
+	}
+
+	if p.Max == -1 {
+		star, err := c.node(Star(Get(p.Patt)))
+		if err != nil {
+			return nil, err
+		}
+		return append(code, star...), nil
+	}
+
+	n := p.Max - p.Min
+	if n == 0 {
+		return code, nil
+	}
+
+	if sub == nil {
+		sub, err = c.compile(p.Patt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if n*sub.Size() <= RepeatUnrollThreshold {
+		// p{0,n} is equivalent to (p (p (p ...)?)?)?
+		opt := Optional(Get(p.Patt))
+		for i := 1; i < n; i++ {
+			opt = Optional(Concat(Get(p.Patt), opt))
+		}
+		optional, err := c.compile(opt)
+		if err != nil {
+			return nil, err
+		}
+		return append(code, optional...), nil
+	}
+
+	L1 := isa.NewLabel()
+	L2 := isa.NewLabel()
+	L3 := isa.NewLabel()
+	L4 := isa.NewLabel()
+	code = append(code, isa.RepeatOpen{N: n})
+	code = append(code, L1)
+	code = append(code, isa.Choice{Lbl: L2})
+	code = append(code, sub...)
+	code = append(code, isa.Commit{Lbl: L3})
+	code = append(code, L3)
+	code = append(code, isa.RepeatNext{Lbl: L1})
+	code = append(code, isa.Jump{Lbl: L4})
+	code = append(code, L2)
+	code = append(code, isa.RepeatClose{})
+	code = append(code, L4)
+	return code, nil
+}
+
+// Returns n copies of the program for p. The program sub must be a
+// compiled version of p and is used as the first copy.
+func (c *compiler) unroll(p Pattern, sub isa.Program, n int) (isa.Program, error) {
+	code := make(isa.Program, 0, len(sub)*n)
+	code = append(code, sub...)
+	for i := 1; i < n; i++ {
+		next, err := c.compile(p)
+		if err != nil {
+			return nil, err
+		}
+		code = append(code, next...)
+	}
+	return code, nil
+}
+
 // Compile this node.
 func (p *OptionalNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *OptionalNode) compile(c *compiler) (isa.Program, error) {
 	// optimization: if the pattern is a class node or single char literal, we
 	// can use the Test*NoChoice instructions.
 	switch t := Get(p.Patt).(type) {
@@ -214,16 +495,23 @@ func (p *OptionalNode) Compile() (isa.Program, error) {
 		return prog, nil
 	}
 
-	a := AltNode{
+	a := &AltNode{
 		Left:  Get(p.Patt),
 		Right: &EmptyNode{},
 	}
-	return a.Compile()
+	if d, ok := c.disp[p]; ok {
+		c.disp[a] = d
+	}
+	return a.compile(c)
 }
 
 // Compile this node.
 func (p *NotNode) Compile() (isa.Program, error) {
-	sub, err := Get(p.Patt).Compile()
+	return p.compile(&compiler{})
+}
+
+func (p *NotNode) compile(c *compiler) (isa.Program, error) {
+	sub, err := c.compile(p.Patt)
 	L1 := isa.NewLabel()
 	code := make(isa.Program, 0, len(sub)+3)
 	code = append(code, isa.Choice{Lbl: L1})
@@ -235,7 +523,11 @@ func (p *NotNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *AndNode) Compile() (isa.Program, error) {
-	sub, err := Get(p.Patt).Compile()
+	return p.compile(&compiler{})
+}
+
+func (p *AndNode) compile(c *compiler) (isa.Program, error) {
+	sub, err := c.compile(p.Patt)
 	code := make(isa.Program, 0, len(sub)+5)
 	L1 := isa.NewLabel()
 	L2 := isa.NewLabel()
@@ -251,37 +543,53 @@ func (p *AndNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *CapNode) Compile() (isa.Program, error) {
-	sub, err := Get(p.Patt).Compile()
+	return p.compile(&compiler{})
+}
+
+func (p *CapNode) compile(c *compiler) (isa.Program, error) {
+	sub, err := c.compile(p.Patt)
 	if err != nil {
 		return nil, err
 	}
 	code := make(isa.Program, 0, len(sub)+2)
 
-	i := 0
+	// i is the index after the leading instructions that consume a fixed
+	// number of bytes, of which there are n.
+	i, n := 0, 0
 	back := 0
 loop:
 	for _, insn := range sub {
 		switch t := insn.(type) {
 		case isa.Char, isa.Set:
 			back++
+			n++
 		case isa.Any:
 			back += int(t.N)
+			n++
+		case isa.NodeBegin, isa.NodeEnd:
 		default:
 			break loop
 		}
 		i++
 	}
+	// markers for the node that stopped the loop stay with its code.
+	for i > 0 && i < len(sub) {
+		if _, ok := sub[i-1].(isa.NodeBegin); !ok {
+			break
+		}
+		i--
+	}
 
-	if i == 0 || back >= 256 {
-		code = append(code, isa.CaptureBegin{Id: p.Id})
+	if n == 0 || back >= 256 {
+		code = append(code, isa.CaptureBegin{Id: p.Id, Name: p.Name})
 		i = 0
 	} else if i == len(sub) && back < 256 {
 		code = append(code, sub...)
-		code = append(code, isa.CaptureFull{Back: byte(back), Id: p.Id})
+		code = append(code, isa.CaptureFull{Back: byte(back), Id: p.Id, Name: p.Name})
 		return code, nil
 	} else {
 		code = append(code, sub[:i]...)
-		code = append(code, isa.CaptureLate{Back: byte(back), Id: p.Id})
+		code = append(code, isa.CaptureLate{Back: byte(back), Id: p.Id, Name: p.Name})
 	}
 	code = append(code, sub[i:]...)
 	code = append(code, isa.CaptureEnd{})
@@ -290,8 +598,12 @@ loop:
 
 // Compile this node.
 func (p *MemoNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *MemoNode) compile(c *compiler) (isa.Program, error) {
 	L1 := isa.NewLabel()
-	sub, err := Get(p.Patt).Compile()
+	sub, err := c.compile(p.Patt)
 	code := make(isa.Program, 0, len(sub)+3)
 	code = append(code, isa.MemoOpen{Lbl: L1, Id: p.Id})
 	code = append(code, sub...)
@@ -302,8 +614,12 @@ func (p *MemoNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *CheckNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *CheckNode) compile(c *compiler) (isa.Program, error) {
 	L1 := isa.NewLabel()
-	sub, err := Get(p.Patt).Compile()
+	sub, err := c.compile(p.Patt)
 	code := make(isa.Program, 0, len(sub)+3)
 	code = append(code, isa.CheckBegin{
 		Id:   p.Id,
@@ -317,11 +633,15 @@ func (p *CheckNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *SearchNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *SearchNode) compile(c *compiler) (isa.Program, error) {
 	var rsearch Pattern
 	var set charset.Set
 	opt := false
 
-	sub, err := Get(p.Patt).Compile()
+	sub, err := c.compile(p.Patt)
 	if err != nil {
 		return nil, err
 	}
@@ -350,9 +670,14 @@ func (p *SearchNode) Compile() (isa.Program, error) {
 		rsearch = NonTerm("S")
 	}
 
-	return Grammar("S", map[string]Pattern{
-		"S": Or(Get(p.Patt), Concat(Any(1), rsearch)),
-	}).Compile()
+	g := &GrammarNode{
+		Defs: map[string]Pattern{
+			"S": Or(Get(p.Patt), Concat(Any(1), rsearch)),
+		},
+		Start:     "S",
+		anonymous: true,
+	}
+	return g.compile(c)
 }
 
 // Compile this node.
@@ -366,6 +691,10 @@ func (p *EmptyOpNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *GrammarNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *GrammarNode) compile(c *compiler) (isa.Program, error) {
 	p.Inline()
 
 	used := make(map[string]bool)
@@ -376,29 +705,49 @@ func (p *GrammarNode) Compile() (isa.Program, error) {
 				if t.Inlined == nil {
 					used[t.Name] = true
 				}
+			case *ThrowNode:
+				if rule, ok := p.recovery(t.Label); ok {
+					used[rule] = true
+				}
 			}
 		})
 	}
 
 	if len(used) == 0 {
-		return p.Defs[p.Start].Compile()
+		return c.node(p.Defs[p.Start])
 	}
 
+	// calls to left-recursive rules must use a dedicated instruction that
+	// grows the match of the rule one iteration at a time.
+	lr := p.leftRecursive()
+
 	code := make(isa.Program, 0)
 	LEnd := isa.NewLabel()
 	code = append(code, openCall{name: p.Start}, isa.Jump{Lbl: LEnd})
 
+	// rules are compiled in order of their names so that the same grammar
+	// always produces the same program.
+	names := make([]string, 0, len(p.Defs))
+	for k := range p.Defs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
 	labels := make(map[string]isa.Label)
-	for k, v := range p.Defs {
+	for _, k := range names {
+		v := p.Defs[k]
 		if k != p.Start && !used[k] {
 			continue
 		}
 		label := isa.NewLabel()
 		labels[k] = label
-		fn, err := v.Compile()
+		fn, err := c.node(v)
 		if err != nil {
 			return nil, err
 		}
+		if !p.anonymous {
+			code = append(code, isa.RuleBegin{Name: k})
+		}
 		code = append(code, label)
 		code = append(code, fn...)
 		code = append(code, isa.Return{})
@@ -415,6 +764,14 @@ func (p *GrammarNode) Compile() (isa.Program, error) {
 				}
 			}
 
+			if lr[oc.name] {
+				// left-recursive calls cannot be tail call optimized
+				// because the rule may need to be re-entered after it
+				// returns.
+				code[i] = isa.LRCall{Lbl: lbl}
+				continue
+			}
+
 			// replace this placeholder instruction with a normal call
 			var replace isa.Insn = isa.Call{Lbl: lbl}
 			// if a call is immediately followed by a return, optimize to
@@ -434,6 +791,20 @@ func (p *GrammarNode) Compile() (isa.Program, error) {
 
 			// perform the replacement of the opencall by either a call or jump
 			code[i] = replace
+		} else if t, ok := insn.(isa.Throw); ok {
+			// labels with a recovery rule in this grammar call the rule
+			// if they are not caught.
+			rule, ok := p.recovery(t.Label)
+			if !ok {
+				continue
+			}
+			lbl, ok := labels[rule]
+			if !ok {
+				return nil, &NotFoundError{
+					Name: rule,
+				}
+			}
+			code[i] = isa.ThrowRecover{Label: t.Label, Lbl: lbl}
 		}
 	}
 
@@ -442,6 +813,17 @@ func (p *GrammarNode) Compile() (isa.Program, error) {
 	return code, nil
 }
 
+// Returns the name of the rule that recovers from failures labeled with label.
+func (p *GrammarNode) recovery(label string) (string, bool) {
+	if rule, ok := p.Recover[label]; ok {
+		return rule, true
+	}
+	if _, ok := p.Defs[label]; ok {
+		return label, true
+	}
+	return "", false
+}
+
 // Compile this node.
 func (p *ClassNode) Compile() (isa.Program, error) {
 	return isa.Program{
@@ -449,6 +831,13 @@ func (p *ClassNode) Compile() (isa.Program, error) {
 	}, nil
 }
 
+// Compile this node.
+func (p *RuneClassNode) Compile() (isa.Program, error) {
+	return isa.Program{
+		isa.RuneSet{Runes: p.Runes},
+	}, nil
+}
+
 // Compile this node.
 func (p *LiteralNode) Compile() (isa.Program, error) {
 	code := make(isa.Program, len(p.Str))
@@ -460,8 +849,12 @@ func (p *LiteralNode) Compile() (isa.Program, error) {
 
 // Compile this node.
 func (p *NonTermNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *NonTermNode) compile(c *compiler) (isa.Program, error) {
 	if p.Inlined != nil {
-		return p.Inlined.Compile()
+		return c.node(p.Inlined)
 	}
 	return isa.Program{
 		openCall{name: p.Name},
@@ -475,8 +868,19 @@ func (p *DotNode) Compile() (isa.Program, error) {
 	}, nil
 }
 
+// Compile this node.
+func (p *RuneDotNode) Compile() (isa.Program, error) {
+	return isa.Program{
+		isa.AnyRune{},
+	}, nil
+}
+
 // Compile this node.
 func (p *ErrorNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *ErrorNode) compile(c *compiler) (isa.Program, error) {
 	var recovery isa.Program
 	var err error
 
@@ -485,7 +889,7 @@ func (p *ErrorNode) Compile() (isa.Program, error) {
 			isa.End{Fail: true},
 		}
 	} else {
-		recovery, err = Get(p.Recover).Compile()
+		recovery, err = c.compile(p.Recover)
 	}
 
 	code := make(isa.Program, 0, len(recovery)+1)
@@ -494,6 +898,40 @@ func (p *ErrorNode) Compile() (isa.Program, error) {
 	return code, err
 }
 
+// Compile this node.
+func (p *ThrowNode) Compile() (isa.Program, error) {
+	return isa.Program{
+		isa.Throw{Label: p.Label},
+	}, nil
+}
+
+// Compile this node.
+func (p *CatchNode) Compile() (isa.Program, error) {
+	return p.compile(&compiler{})
+}
+
+func (p *CatchNode) compile(c *compiler) (isa.Program, error) {
+	L1 := isa.NewLabel()
+	L2 := isa.NewLabel()
+	left, err := c.compile(p.Patt)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compile(p.Handler)
+	if err != nil {
+		return nil, err
+	}
+
+	code := make(isa.Program, 0, len(left)+len(right)+5)
+	code = append(code, isa.Catch{Lbl: L1, Labels: p.Labels})
+	code = append(code, left...)
+	code = append(code, isa.Commit{Lbl: L2})
+	code = append(code, L1)
+	code = append(code, right...)
+	code = append(code, L2)
+	return code, nil
+}
+
 // Compile this node.
 func (p *EmptyNode) Compile() (isa.Program, error) {
 	return isa.Program{}, nil
@@ -0,0 +1,379 @@
+package pattern
+
+import (
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/isa"
+)
+
+// A dispatch records how an ordered choice, or an optional pattern, can be
+// decided by the next byte. Left is the FIRST set of the first alternative.
+// Right is the FIRST set of the second alternative and, if the second
+// alternative can match the empty string, the FOLLOW set of the choice: the
+// bytes that what comes after the choice can start with.
+//
+// If the first alternative cannot match the empty string and the sets are
+// disjoint, no backtrack entry is needed. A byte outside of left makes the
+// first alternative fail at once. A byte in left is not in right, so if the
+// first alternative fails after starting with it, the second alternative
+// fails too, or matches the empty string and then what follows fails at that
+// byte. Either way, the failure reaches the backtrack entry that was on top of
+// the stack before the choice.
+type dispatch struct {
+	left, right charset.Set
+	nullable    bool
+}
+
+// Returns d updated with the sets of the choice in another context, and
+// whether d changed. The code for a node is shared by all the places where it
+// is used, so the sets are the unions of the sets in every context.
+func (d *dispatch) add(left, right charset.Set, nullable bool) (*dispatch, bool) {
+	if d == nil {
+		return &dispatch{
+			left:     left,
+			right:    right,
+			nullable: nullable,
+		}, true
+	}
+	changed := d.left.Add(left) != d.left || d.right.Add(right) != d.right || nullable && !d.nullable
+	d.left = d.left.Add(left)
+	d.right = d.right.Add(right)
+	d.nullable = d.nullable || nullable
+	return d, changed
+}
+
+// Returns true if the choice can be decided by testing the next byte
+// against d.left.
+func (d *dispatch) disjoint() bool {
+	return d != nil && !d.nullable && d.left.Sub(d.right) == d.left
+}
+
+var fullSet = charset.Set{}.Complement()
+
+// The FIRST sets of the rules of a grammar.
+type firstSets struct {
+	defs     map[string]Pattern
+	first    map[string]charset.Set
+	nullable map[string]bool
+	// left-recursive rules are grown from a failing seed, so calls to them
+	// are not analyzed.
+	lr map[string]bool
+}
+
+// Computes the FIRST sets of the rules of g.
+func newFirstSets(g *GrammarNode) *firstSets {
+	fs := &firstSets{
+		defs:     g.Defs,
+		first:    make(map[string]charset.Set),
+		nullable: make(map[string]bool),
+		lr:       g.leftRecursive(),
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, def := range g.Defs {
+			set, null := fs.firstOf(def)
+			set = set.Add(fs.first[name])
+			null = null || fs.nullable[name]
+			if set != fs.first[name] || null != fs.nullable[name] {
+				fs.first[name] = set
+				fs.nullable[name] = null
+				changed = true
+			}
+		}
+	}
+	return fs
+}
+
+// Returns the set of bytes that a match of p can start with, and whether p
+// can match the empty string. The set is conservative: a byte outside of the
+// set is sure to make p fail, or to make it match the empty string if it is
+// nullable.
+func (fs *firstSets) firstOf(p Pattern) (charset.Set, bool) {
+	switch t := Get(p).(type) {
+	case *LiteralNode:
+		if len(t.Str) == 0 {
+			return charset.Set{}, true
+		}
+		return charset.New([]byte{t.Str[0]}), false
+	case *ClassNode:
+		return t.Chars, false
+	case *RuneClassNode:
+		return runeFirst(t.Runes), false
+	case *DotNode:
+		if t.N == 0 {
+			return charset.Set{}, true
+		}
+		return fullSet, false
+	case *RuneDotNode:
+		return fullSet, false
+	case *SeqNode:
+		left, lnull := fs.firstOf(t.Left)
+		if !lnull {
+			return left, false
+		}
+		right, rnull := fs.firstOf(t.Right)
+		return left.Add(right), rnull
+	case *AltNode:
+		left, lnull := fs.firstOf(t.Left)
+		right, rnull := fs.firstOf(t.Right)
+		return left.Add(right), lnull || rnull
+	case *CatchNode:
+		left, lnull := fs.firstOf(t.Patt)
+		right, rnull := fs.firstOf(t.Handler)
+		return left.Add(right), lnull || rnull
+	case *StarNode:
+		set, _ := fs.firstOf(t.Patt)
+		return set, true
+	case *OptionalNode:
+		set, _ := fs.firstOf(t.Patt)
+		return set, true
+	case *PlusNode:
+		return fs.firstOf(t.Patt)
+	case *RepeatNode:
+		set, null := fs.firstOf(t.Patt)
+		return set, null || t.Min == 0
+	case *EmptyNode, *EmptyOpNode:
+		// assertions do not consume any input.
+		return charset.Set{}, true
+	case *CapNode:
+		return fs.firstOf(t.Patt)
+	case *MemoNode:
+		return fs.firstOf(t.Patt)
+	case *CheckNode:
+		if isBackRef(t.Checker) {
+			// a back-reference can match anything that its symbol was
+			// defined as.
+			return fullSet, true
+		}
+		return fs.firstOf(t.Patt)
+	case *NonTermNode:
+		if _, ok := fs.defs[t.Name]; !ok || fs.lr[t.Name] {
+			return fullSet, true
+		}
+		return fs.first[t.Name], fs.nullable[t.Name]
+	case *GrammarNode:
+		sub := newFirstSets(t)
+		if _, ok := t.Defs[t.Start]; !ok || sub.lr[t.Start] {
+			return fullSet, true
+		}
+		return sub.first[t.Start], sub.nullable[t.Start]
+	}
+	// predicates can look past the next byte, so a choice that starts with
+	// one may fail farther than it, and is not dispatched so that syntax
+	// errors are the same. The others are searches, errors and labeled
+	// failures.
+	return fullSet, true
+}
+
+// Returns the set of bytes that a code point in rs can start with.
+func runeFirst(rs charset.RuneSet) charset.Set {
+	var set charset.Set
+	for _, r := range rs.Ranges {
+		if r.Lo < 0x80 {
+			hi := r.Hi
+			if hi >= 0x80 {
+				hi = 0x7f
+			}
+			set = set.Add(charset.Range(byte(r.Lo), byte(hi)))
+		}
+		if r.Hi >= 0x80 {
+			// the lead bytes of multi-byte encodings.
+			set = set.Add(charset.Range(0xc2, 0xf4))
+		}
+	}
+	if rs.Has(0xfffd) {
+		// bytes that do not start a valid encoding are decoded as
+		// utf8.RuneError.
+		set = fullSet
+	}
+	return set
+}
+
+func isBackRef(c isa.Checker) bool {
+	switch c.(type) {
+	case isa.BackReference, *isa.BackReference:
+		return true
+	}
+	return false
+}
+
+// A dispatcher computes the dispatches of the choices of a pattern.
+type dispatcher struct {
+	disp    map[Pattern]*dispatch
+	changed bool
+	// the FIRST sets of the grammars of the pattern.
+	firsts map[*GrammarNode]*firstSets
+	// the nodes returned by Get, which may be new nodes, so that the same
+	// ones are analyzed every time.
+	got map[Pattern]Pattern
+}
+
+// The FOLLOW sets of the rules of the grammar that is being analyzed.
+type follows struct {
+	fs     *firstSets
+	follow map[string]charset.Set
+}
+
+// Returns the dispatches of the choices of p, or nil if DispatchChoices is
+// false.
+func dispatchChoices(p Pattern) map[Pattern]*dispatch {
+	if !DispatchChoices {
+		return nil
+	}
+	d := &dispatcher{
+		disp:   make(map[Pattern]*dispatch),
+		firsts: make(map[*GrammarNode]*firstSets),
+		got:    make(map[Pattern]Pattern),
+	}
+	// deciding not to dispatch a choice makes what follows its first
+	// alternative unknown, which can grow other sets, so the choices are
+	// analyzed until none of them change.
+	for d.changed = true; d.changed; {
+		d.changed = false
+		d.annotate(p, &follows{fs: &firstSets{}}, fullSet)
+	}
+	return d.disp
+}
+
+// Records how p can be dispatched where what follows p starts with a byte in
+// follow. The follow set must be full if something that follows p, before it
+// consumes a byte, may commit or update a backtrack entry that was pushed
+// before p: a failure in p would go to that entry as it was before p, and
+// give a different result from a failure after it.
+func (d *dispatcher) annotate(p Pattern, f *follows, follow charset.Set) {
+	switch t := p.(type) {
+	case *SourceNode:
+		d.annotate(t.Patt, f, follow)
+		return
+	case *NonTermNode:
+		if _, ok := f.fs.defs[t.Name]; ok {
+			f.follow[t.Name] = f.follow[t.Name].Add(follow)
+		}
+		return
+	}
+
+	q, ok := d.got[p]
+	if !ok {
+		q = Get(p)
+		d.got[p] = q
+	}
+	if q != p {
+		// p is compiled as q.
+		d.annotate(q, f, follow)
+		return
+	}
+
+	switch t := p.(type) {
+	case *AltNode:
+		left, lnull := f.fs.firstOf(t.Left)
+		right, rnull := f.fs.firstOf(t.Right)
+		if rnull {
+			right = right.Add(follow)
+		}
+		// the first alternative is followed by a Commit unless the choice
+		// is dispatched.
+		lfollow := fullSet
+		if d.add(t, left, right, lnull).disjoint() {
+			lfollow = follow
+		}
+		d.annotate(t.Left, f, lfollow)
+		d.annotate(t.Right, f, follow)
+	case *OptionalNode:
+		set, null := f.fs.firstOf(t.Patt)
+		pfollow := fullSet
+		if d.add(t, set, follow, null).disjoint() {
+			pfollow = follow
+		}
+		d.annotate(t.Patt, f, pfollow)
+	case *SeqNode:
+		d.annotate(t.Right, f, follow)
+		right, rnull := f.fs.firstOf(t.Right)
+		if rnull {
+			right = right.Add(follow)
+		}
+		d.annotate(t.Left, f, right)
+	case *CatchNode:
+		d.annotate(t.Patt, f, fullSet)
+		d.annotate(t.Handler, f, follow)
+	case *CapNode:
+		// captures that are closed after a failure are discarded with the
+		// stack entries above the backtrack entry.
+		d.annotate(t.Patt, f, follow)
+	case *StarNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *PlusNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *RepeatNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *NotNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *AndNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *MemoNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *CheckNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *SearchNode:
+		d.annotate(t.Patt, f, fullSet)
+	case *ErrorNode:
+		if t.Recover != nil {
+			d.annotate(t.Recover, f, fullSet)
+		}
+	case *GrammarNode:
+		d.grammar(t, follow)
+	}
+}
+
+// Records how the choices of g can be dispatched where what follows g starts
+// with a byte in follow.
+func (d *dispatcher) grammar(g *GrammarNode, follow charset.Set) {
+	fs, ok := d.firsts[g]
+	if !ok {
+		fs = newFirstSets(g)
+		d.firsts[g] = fs
+	}
+	f := &follows{
+		fs:     fs,
+		follow: make(map[string]charset.Set),
+	}
+	f.follow[g.Start] = follow
+	// left-recursive rules are entered again after they return, and
+	// recovery rules return to where a label was thrown.
+	for name := range fs.lr {
+		f.follow[name] = fullSet
+	}
+	for _, def := range g.Defs {
+		WalkPattern(def, false, func(sub Pattern) {
+			if t, ok := sub.(*ThrowNode); ok {
+				if rule, ok := g.recovery(t.Label); ok {
+					f.follow[rule] = fullSet
+				}
+			}
+		})
+	}
+
+	// the FOLLOW set of a rule is the union of the sets that follow its
+	// calls, which are found by analyzing the rules that call it.
+	for changed := true; changed; {
+		before := make(map[string]charset.Set, len(f.follow))
+		for name, set := range f.follow {
+			before[name] = set
+		}
+		for name, def := range g.Defs {
+			d.annotate(def, f, f.follow[name])
+		}
+		changed = false
+		for name, set := range f.follow {
+			if set != before[name] {
+				changed = true
+			}
+		}
+	}
+}
+
+// Records the sets of the choice p, and returns its dispatch.
+func (d *dispatcher) add(p Pattern, left, right charset.Set, nullable bool) *dispatch {
+	disp, changed := d.disp[p].add(left, right, nullable)
+	d.disp[p] = disp
+	d.changed = d.changed || changed
+	return disp
+}
@@ -0,0 +1,133 @@
+package pattern
+
+// Returns true if p can succeed without consuming any input. The nullability
+// of non-terminals is looked up in rules. The analysis is conservative: if it
+// cannot tell whether a pattern is nullable, it assumes that it is.
+func nullable(p Pattern, rules map[string]bool) bool {
+	switch t := Get(p).(type) {
+	case *LiteralNode:
+		return len(t.Str) == 0
+	case *ClassNode, *RuneClassNode, *RuneDotNode:
+		return false
+	case *DotNode:
+		return t.N == 0
+	case *SeqNode:
+		return nullable(t.Left, rules) && nullable(t.Right, rules)
+	case *AltNode:
+		return nullable(t.Left, rules) || nullable(t.Right, rules)
+	case *PlusNode:
+		return nullable(t.Patt, rules)
+	case *RepeatNode:
+		return t.Min == 0 || nullable(t.Patt, rules)
+	case *CapNode:
+		return nullable(t.Patt, rules)
+	case *MemoNode:
+		return nullable(t.Patt, rules)
+	case *SearchNode:
+		return nullable(t.Patt, rules)
+	case *CheckNode:
+		return nullable(t.Patt, rules)
+	case *CatchNode:
+		return nullable(t.Patt, rules) || nullable(t.Handler, rules)
+	case *NonTermNode:
+		return rules[t.Name]
+	case *GrammarNode:
+		return t.nullableRules()[t.Start]
+	}
+	// Star, Optional, predicates, empty nodes and errors.
+	return true
+}
+
+// Returns the set of rules in the grammar that may succeed without consuming
+// any input.
+func (p *GrammarNode) nullableRules() map[string]bool {
+	rules := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for name, def := range p.Defs {
+			if !rules[name] && nullable(def, rules) {
+				rules[name] = true
+				changed = true
+			}
+		}
+	}
+	return rules
+}
+
+// Adds to calls the non-terminals that p may call before consuming any input.
+func leftCalls(p Pattern, rules map[string]bool, calls map[string]bool) {
+	switch t := Get(p).(type) {
+	case *SeqNode:
+		leftCalls(t.Left, rules, calls)
+		if nullable(t.Left, rules) {
+			leftCalls(t.Right, rules, calls)
+		}
+	case *AltNode:
+		leftCalls(t.Left, rules, calls)
+		leftCalls(t.Right, rules, calls)
+	case *StarNode:
+		leftCalls(t.Patt, rules, calls)
+	case *PlusNode:
+		leftCalls(t.Patt, rules, calls)
+	case *OptionalNode:
+		leftCalls(t.Patt, rules, calls)
+	case *RepeatNode:
+		leftCalls(t.Patt, rules, calls)
+	case *NotNode:
+		leftCalls(t.Patt, rules, calls)
+	case *AndNode:
+		leftCalls(t.Patt, rules, calls)
+	case *CapNode:
+		leftCalls(t.Patt, rules, calls)
+	case *MemoNode:
+		leftCalls(t.Patt, rules, calls)
+	case *SearchNode:
+		leftCalls(t.Patt, rules, calls)
+	case *CheckNode:
+		leftCalls(t.Patt, rules, calls)
+	case *CatchNode:
+		leftCalls(t.Patt, rules, calls)
+		leftCalls(t.Handler, rules, calls)
+	case *ErrorNode:
+		if t.Recover != nil {
+			leftCalls(t.Recover, rules, calls)
+		}
+	case *NonTermNode:
+		calls[t.Name] = true
+	}
+}
+
+// Returns the set of rules in the grammar that are left recursive, either
+// directly (`A <- A 'a'`) or indirectly through other rules (`A <- B 'a'`,
+// `B <- A 'b'`).
+func (p *GrammarNode) leftRecursive() map[string]bool {
+	rules := p.nullableRules()
+
+	calls := make(map[string]map[string]bool)
+	for name, def := range p.Defs {
+		calls[name] = make(map[string]bool)
+		leftCalls(def, rules, calls[name])
+	}
+
+	lr := make(map[string]bool)
+	for name := range p.Defs {
+		// search for a path of left calls that leads back to this rule.
+		seen := make(map[string]bool)
+		work := []string{name}
+		for len(work) > 0 && !lr[name] {
+			next := work[len(work)-1]
+			work = work[:len(work)-1]
+			for callee := range calls[next] {
+				if callee == name {
+					lr[name] = true
+					break
+				}
+				if !seen[callee] {
+					seen[callee] = true
+					work = append(work, callee)
+				}
+			}
+		}
+	}
+	return lr
+}
@@ -47,10 +47,12 @@ type AndNode struct {
 	Patt Pattern
 }
 
-// CapNode marks a pattern to be captured with a certain ID.
+// CapNode marks a pattern to be captured with a certain ID. If Name is not
+// empty, it is recorded as the name of the ID in the compiled code.
 type CapNode struct {
 	Patt Pattern
 	Id   int
+	Name string
 }
 
 // MemoNode marks a pattern to be memoized with a certain ID.
@@ -71,6 +73,14 @@ type CheckNode struct {
 type GrammarNode struct {
 	Defs  map[string]Pattern
 	Start string
+	// Recover maps failure labels to the names of the rules that recover
+	// from them. A label without an entry is recovered by the rule with the
+	// same name as the label, if there is one.
+	Recover map[string]string
+
+	// anonymous grammars are generated by the compiler and do not record
+	// the names of their rules.
+	anonymous bool
 }
 
 // SearchNode represents a search for a certain pattern.
@@ -78,11 +88,11 @@ type SearchNode struct {
 	Patt Pattern
 }
 
-// RepeatNode represents the repetition of a pattern a constant number of
-// times.
+// RepeatNode represents the bounded repetition of a pattern: at least Min
+// times and at most Max times. If Max is -1 the repetition is unbounded.
 type RepeatNode struct {
-	Patt Pattern
-	N    int
+	Patt     Pattern
+	Min, Max int
 }
 
 // ClassNode represents a character set.
@@ -90,6 +100,11 @@ type ClassNode struct {
 	Chars charset.Set
 }
 
+// RuneClassNode represents a set of code points.
+type RuneClassNode struct {
+	Runes charset.RuneSet
+}
+
 // LiteralNode represents a literal string.
 type LiteralNode struct {
 	Str string
@@ -108,17 +123,42 @@ type DotNode struct {
 	N uint8
 }
 
+// RuneDotNode represents the pattern to match any code point.
+type RuneDotNode struct{}
+
 // ErrorNode represents a pattern that fails with a certain error message.
 type ErrorNode struct {
 	Message string
 	Recover Pattern
 }
 
+// ThrowNode represents a pattern that fails with a label.
+type ThrowNode struct {
+	Label string
+}
+
+// CatchNode represents a labeled choice: if Patt fails with one of Labels,
+// Handler is matched instead.
+type CatchNode struct {
+	Patt    Pattern
+	Handler Pattern
+	Labels  []string
+}
+
 // EmptyOpNode is a node that performs a zero-width assertion.
 type EmptyOpNode struct {
 	Op syntax.EmptyOp
 }
 
+// SourceNode records that a pattern was compiled from the bytes Start to End
+// of the source of a grammar. It matches the same input as Patt, and its
+// location is kept through compilation so that code can be mapped back to
+// the source.
+type SourceNode struct {
+	Patt       Pattern
+	Start, End int
+}
+
 // EmtpyNode represents the empty pattern.
 type EmptyNode struct {
 }
@@ -163,10 +203,17 @@ func WalkPattern(p Pattern, followInline bool, fn WalkFunc) {
 		WalkPattern(t.Patt, followInline, fn)
 	case *SearchNode:
 		WalkPattern(t.Patt, followInline, fn)
+	case *RepeatNode:
+		WalkPattern(t.Patt, followInline, fn)
 	case *CheckNode:
 		WalkPattern(t.Patt, followInline, fn)
+	case *SourceNode:
+		WalkPattern(t.Patt, followInline, fn)
 	case *ErrorNode:
 		WalkPattern(t.Recover, followInline, fn)
+	case *CatchNode:
+		WalkPattern(t.Patt, followInline, fn)
+		WalkPattern(t.Handler, followInline, fn)
 	case *GrammarNode:
 		for _, p := range t.Defs {
 			WalkPattern(p, followInline, fn)
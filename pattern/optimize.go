@@ -8,6 +8,21 @@ import (
 // Nodes with trees larger than this size will not be inlined.
 var InlineThreshold = 100
 
+// Bounded repetitions that would unroll into more than this many instructions
+// are compiled into a counted loop instead.
+var RepeatUnrollThreshold = 32
+
+// Ordered choices that start with at least this many literals are compiled
+// into a single Literals instruction, which matches all of them with one
+// lookup per byte.
+var LiteralsThreshold = 3
+
+// If DispatchChoices is true, ordered choices and optional patterns whose
+// alternatives can be told apart by the next byte, as computed from the FIRST
+// and FOLLOW sets of the grammar, are compiled into tests of the next byte
+// instead of pushing backtrack entries.
+var DispatchChoices = true
+
 // Inline performs inlining passes until the inliner reaches a steady-state.
 func (p *GrammarNode) Inline() {
 	for p.inline() {
@@ -23,8 +38,10 @@ func Get(p Pattern) Pattern {
 	case *NonTermNode:
 		// Return the inlined pattern for a non-terminal that has been inlined.
 		if t.Inlined != nil {
-			return t.Inlined
+			return unwrap(t.Inlined)
 		}
+	case *SourceNode:
+		return Get(t.Patt)
 	case *AltNode:
 		l, r := Get(t.Left), Get(t.Right)
 		if n, emptyL := l.(*EmptyNode); emptyL {
@@ -113,6 +130,9 @@ func (p *GrammarNode) inline() bool {
 				if t.Inlined == nil {
 					leaf = false
 				}
+			case *SourceNode:
+				// source locations do not generate any code.
+				return
 			}
 			size++
 		})
@@ -169,12 +189,37 @@ func combine(p1 Pattern, p2 Pattern) (charset.Set, bool) {
 	return set, false
 }
 
-// Returns the next instruction in p, skipping labels and nops.
-// If false is returned, there is no next instruction.
+// Returns the pattern that p annotates if it is a SourceNode.
+func unwrap(p Pattern) Pattern {
+	for {
+		t, ok := p.(*SourceNode)
+		if !ok {
+			return p
+		}
+		p = t.Patt
+	}
+}
+
+// Returns the index of the first instruction in p that is not a source
+// marker.
+func skipMarkers(p isa.Program) int {
+	for i := 0; i < len(p); i++ {
+		switch p[i].(type) {
+		case isa.NodeBegin, isa.NodeEnd:
+			continue
+		default:
+			return i
+		}
+	}
+	return len(p)
+}
+
+// Returns the next instruction in p, skipping labels, nops and rule and source
+// markers. If false is returned, there is no next instruction.
 func nextInsn(p isa.Program) (isa.Insn, bool) {
 	for i := 0; i < len(p); i++ {
 		switch p[i].(type) {
-		case isa.Label, isa.Nop:
+		case isa.Label, isa.Nop, isa.RuleBegin, isa.NodeBegin, isa.NodeEnd:
 			continue
 		default:
 			return p[i], true
@@ -190,7 +235,7 @@ func nextInsnLabel(p isa.Program) (int, bool) {
 	hadLabel := false
 	for i := 0; i < len(p); i++ {
 		switch p[i].(type) {
-		case isa.Nop:
+		case isa.Nop, isa.RuleBegin, isa.NodeBegin, isa.NodeEnd:
 			continue
 		case isa.Label:
 			hadLabel = true
@@ -219,26 +264,29 @@ func Optimize(p isa.Program) {
 		// followed (no label) by Char/Set/Any, we can replace with the
 		// dedicated instruction TestChar/TestSet/TestAny.
 		if ch, ok := insn.(isa.Choice); ok && i < len(p)-1 {
-			next := p[i+1]
-			switch t := next.(type) {
+			j := i + 1 + skipMarkers(p[i+1:])
+			if j == len(p) {
+				continue
+			}
+			switch t := p[j].(type) {
 			case isa.Char:
 				p[i] = isa.TestChar{
 					Byte: t.Byte,
 					Lbl:  ch.Lbl,
 				}
-				p[i+1] = isa.Nop{}
+				p[j] = isa.Nop{}
 			case isa.Set:
 				p[i] = isa.TestSet{
 					Chars: t.Chars,
 					Lbl:   ch.Lbl,
 				}
-				p[i+1] = isa.Nop{}
+				p[j] = isa.Nop{}
 			case isa.Any:
 				p[i] = isa.TestAny{
 					N:   t.N,
 					Lbl: ch.Lbl,
 				}
-				p[i+1] = isa.Nop{}
+				p[j] = isa.Nop{}
 			}
 		}
 
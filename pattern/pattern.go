@@ -4,6 +4,8 @@ package pattern
 
 import (
 	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zyedidia/gpeg/charset"
 	"github.com/zyedidia/gpeg/isa"
@@ -17,6 +19,15 @@ func Cap(p Pattern, id int) Pattern {
 	}
 }
 
+// NamedCap marks a pattern to be captured with an ID that has the given name.
+func NamedCap(p Pattern, id int, name string) Pattern {
+	return &CapNode{
+		Patt: p,
+		Id:   id,
+		Name: name,
+	}
+}
+
 // Check marks a pattern to be checked with the given checker.
 func Check(p Pattern, c isa.Checker) Pattern {
 	return &CheckNode{
@@ -63,6 +74,40 @@ func Literal(s string) Pattern {
 	}
 }
 
+// LiteralFold matches a given string literal regardless of case. ASCII
+// letters match the same letter in either case, other characters match the
+// characters they are equivalent to under Unicode simple case folding, and
+// bytes that are not valid UTF-8 match themselves. Letters compile to
+// charset instructions, so keywords such as LiteralFold("select") are as
+// fast as the equivalent sets written by hand.
+func LiteralFold(s string) Pattern {
+	var patts []Pattern
+	lit := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		var set Pattern
+		if r < utf8.RuneSelf {
+			if c := charset.New([]byte{byte(r)}); c.Fold() != c {
+				set = Set(c.Fold())
+			}
+		} else if size > 1 && unicode.SimpleFold(r) != r {
+			set = RuneSet(charset.Runes(r, r).Fold())
+		}
+		if set != nil {
+			if lit < i {
+				patts = append(patts, Literal(s[lit:i]))
+			}
+			patts = append(patts, set)
+			lit = i + size
+		}
+		i += size
+	}
+	if lit < len(s) || len(patts) == 0 {
+		patts = append(patts, Literal(s[lit:]))
+	}
+	return Concat(patts...)
+}
+
 // Set matches any character in the given set.
 func Set(chars charset.Set) Pattern {
 	return &ClassNode{
@@ -78,20 +123,54 @@ func Any(n uint8) Pattern {
 	}
 }
 
-// Repeat matches p exactly n times
+// RuneSet matches any UTF-8 encoded code point in the given set. A set of
+// only ASCII code points is the same as the byte set with those characters.
+func RuneSet(runes charset.RuneSet) Pattern {
+	if set, ok := runes.Bytes(); ok {
+		return Set(set)
+	}
+	return &RuneClassNode{
+		Runes: runes,
+	}
+}
+
+// AnyRune consumes one UTF-8 encoded code point, or a single byte if the
+// input is not valid UTF-8, and only fails at the end of the input.
+func AnyRune() Pattern {
+	return &RuneDotNode{}
+}
+
+// Repeat matches p exactly n times: `p{n}`.
 func Repeat(p Pattern, n int) Pattern {
 	if n <= 0 {
 		return &EmptyNode{}
 	}
 
-	acc := p
-	for i := 1; i < n; i++ {
-		acc = &SeqNode{
-			Left:  acc,
-			Right: p,
-		}
+	return &RepeatNode{
+		Patt: p,
+		Min:  n,
+		Max:  n,
+	}
+}
+
+// RepeatMin matches p at least n times: `p{n,}`.
+func RepeatMin(p Pattern, n int) Pattern {
+	return &RepeatNode{
+		Patt: p,
+		Min:  n,
+		Max:  -1,
+	}
+}
+
+// RepeatRange matches p at least min times and at most max times:
+// `p{min,max}`. Like the other repetition operators, the repetition is
+// greedy and does not backtrack.
+func RepeatRange(p Pattern, min, max int) Pattern {
+	return &RepeatNode{
+		Patt: p,
+		Min:  min,
+		Max:  max,
 	}
-	return acc
 }
 
 // Concat concatenates n patterns: `p1 p2 p3...`.
@@ -206,13 +285,16 @@ func Grammar(start string, nonterms map[string]Pattern) Pattern {
 }
 
 // CapGrammar builds a grammar, but all values are automatically captured. The
-// capture IDs are returned in the 'ids' map.
+// capture IDs are named after their non-terminals, and are also returned in the
+// 'ids' map if it is not nil.
 func CapGrammar(start string, nonterms map[string]Pattern, ids map[string]int) Pattern {
 	m := make(map[string]Pattern)
 	id := 0
 	for k, v := range nonterms {
-		m[k] = Cap(v, id)
-		ids[k] = id
+		m[k] = NamedCap(v, id, k)
+		if ids != nil {
+			ids[k] = id
+		}
 		id++
 	}
 	return Grammar(start, m)
@@ -226,6 +308,38 @@ func Error(msg string, recovery Pattern) Pattern {
 	}
 }
 
+// Throw is a pattern that fails with the given label. The failure is not
+// handled by ordinary choices: it is only handled by a Catch for the label, or
+// by the label's recovery rule in an enclosing grammar. If neither exists, the
+// whole match fails.
+func Throw(label string) Pattern {
+	return &ThrowNode{
+		Label: label,
+	}
+}
+
+// Catch builds a labeled choice: it matches p, and if p fails with one of the
+// given labels it matches handler at the same position instead. Ordinary
+// failures of p are not handled.
+func Catch(p, handler Pattern, labels ...string) Pattern {
+	return &CatchNode{
+		Patt:    p,
+		Handler: handler,
+		Labels:  labels,
+	}
+}
+
+// Source records that p was compiled from the bytes start to end of the
+// source of a grammar. The location is carried through compilation into the
+// debugging information of the encoded code.
+func Source(p Pattern, start, end int) Pattern {
+	return &SourceNode{
+		Patt:  p,
+		Start: start,
+		End:   end,
+	}
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -3,6 +3,7 @@ package pattern
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 func Prettify(p Pattern) string {
@@ -13,6 +14,10 @@ func Prettify(p Pattern) string {
 		return fmt.Sprintf("[%s]", t.Chars.String())
 	case *DotNode:
 		return "."
+	case *RuneClassNode:
+		return fmt.Sprintf("[%s]", t.Runes.String())
+	case *RuneDotNode:
+		return "rune(.)"
 	case *EmptyNode:
 		return "\"\""
 	case *AltNode:
@@ -25,6 +30,14 @@ func Prettify(p Pattern) string {
 		return fmt.Sprintf("%s+", Prettify(Get(t.Patt)))
 	case *OptionalNode:
 		return fmt.Sprintf("%s?", Prettify(Get(t.Patt)))
+	case *RepeatNode:
+		switch {
+		case t.Min == t.Max:
+			return fmt.Sprintf("%s{%d}", Prettify(Get(t.Patt)), t.Min)
+		case t.Max == -1:
+			return fmt.Sprintf("%s{%d,}", Prettify(Get(t.Patt)), t.Min)
+		}
+		return fmt.Sprintf("%s{%d,%d}", Prettify(Get(t.Patt)), t.Min, t.Max)
 	case *NotNode:
 		return fmt.Sprintf("!%s", Prettify(Get(t.Patt)))
 	case *AndNode:
@@ -39,6 +52,10 @@ func Prettify(p Pattern) string {
 		return fmt.Sprintf("check(%s)", Prettify(Get(t.Patt)))
 	case *ErrorNode:
 		return fmt.Sprintf("err(%s, %s)", t.Message, Prettify(Get(t.Recover)))
+	case *ThrowNode:
+		return fmt.Sprintf("^%s", t.Label)
+	case *CatchNode:
+		return fmt.Sprintf("(%s //{%s} %s)", Prettify(Get(t.Patt)), strings.Join(t.Labels, ","), Prettify(Get(t.Handler)))
 	case *EmptyOpNode:
 		return fmt.Sprintf("empty(%v)", t.Op)
 	case *GrammarNode:
@@ -0,0 +1,77 @@
+package gpeg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestProfile(t *testing.T) {
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(traceGrammar)))
+
+	prof := &vm.Profile{}
+	match, n, _, _, err := code.ExecContext(context.Background(), strings.NewReader("1+(2"), memo.NoneTable{}, vm.ExecOptions{
+		Profile: prof,
+	})
+	if err != nil || !match || n != 1 {
+		t.Fatalf("got (%t, %d, %v), expected a match of 1 byte", match, n, err)
+	}
+
+	rules := make(map[string]vm.RuleProfile)
+	steps := 0
+	for _, r := range prof.Rules() {
+		rules[r.Rule] = r
+		steps += r.Steps
+	}
+	expr, term := rules["Expr"], rules["Term"]
+	if expr.Calls != 2 || expr.Matches != 2 || expr.Fails != 0 || expr.Consumed != 2 {
+		t.Errorf("incorrect profile for Expr: %+v", expr)
+	}
+	if term.Calls != 3 || term.Matches != 2 || term.Fails != 1 || term.Consumed != 2 {
+		t.Errorf("incorrect profile for Term: %+v", term)
+	}
+	if expr.Backtracks == 0 || term.Backtracks == 0 {
+		t.Errorf("no backtracks recorded: %+v %+v", expr, term)
+	}
+	if steps == 0 || prof.Rules()[0].Steps < prof.Rules()[1].Steps {
+		t.Errorf("rules are not sorted by steps: %+v", prof.Rules())
+	}
+
+	var buf bytes.Buffer
+	if err := vm.WriteReport(&buf, prof.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Term") {
+		t.Errorf("report is missing rules:\n%s", buf.String())
+	}
+}
+
+func TestProfileMemo(t *testing.T) {
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(`
+		S <- M 'x' / M 'y'
+		M <- {{ A }}
+		A <- [a-z]
+	`)))
+
+	prof := &vm.Profile{}
+	match, _, _, _, err := code.ExecContext(context.Background(), strings.NewReader("ay"), memo.NewTreeTable(0), vm.ExecOptions{
+		Profile: prof,
+	})
+	if err != nil || !match {
+		t.Fatalf("got (%t, %v), expected a match", match, err)
+	}
+	hits, misses := 0, 0
+	for _, r := range prof.Rules() {
+		hits += r.MemoHits
+		misses += r.MemoMisses
+	}
+	if hits != 1 || misses != 1 {
+		t.Errorf("got %d memo hits and %d misses, expected 1 of each", hits, misses)
+	}
+}
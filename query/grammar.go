@@ -0,0 +1,215 @@
+package query
+
+import (
+	"github.com/zyedidia/gpeg/charset"
+	p "github.com/zyedidia/gpeg/pattern"
+)
+
+// Query      <- Spacing_ Pattern* EndOfFile_
+// Pattern    <- (Group / Node) Capture*
+// Group      <- OPEN Pattern Predicate* CLOSE
+// Node       <- OPEN (WILDCARD / Identifier) Child* CLOSE
+// 			/ WILDCARD
+// Child      <- Field? Node Quantifier? Capture*
+// 			/ Predicate
+// Field      <- Identifier COLON
+// Quantifier <- QUESTION / STAR / PLUS
+// Predicate  <- OPEN '#' PredName Argument* CLOSE
+// PredName   <- [a-z] [a-z-]* '?'? Spacing_
+// Argument   <- Capture / String
+// Capture    <- '@' [a-zA-Z0-9_.-]+ Spacing_
+//
+// Identifier <- [a-zA-Z_] [a-zA-Z0-9_]* Spacing_
+// String     <- '"' (!'"' Char)* '"' Spacing_
+// Char       <- '\\' . / .
+//
+// WILDCARD   <- '_' ![a-zA-Z0-9_] Spacing_
+// QUESTION   <- '?' Spacing_
+// STAR       <- '*' Spacing_
+// PLUS       <- '+' Spacing_
+// COLON      <- ':' Spacing_
+// OPEN       <- '(' Spacing_
+// CLOSE      <- ')' Spacing_
+//
+// Spacing_   <- (Space_ / Comment_)*
+// Comment_   <- ';' (!EndOfLine_ .)* EndOfLine_
+// Space_     <- ' ' / '\t' / EndOfLine_
+// EndOfLine_ <- '\r\n' / '\n' / '\r'
+// EndOfFile_ <- !.
+
+const (
+	idQuery = iota
+	idPattern
+	idGroup
+	idNode
+	idChild
+	idField
+	idPredicate
+	idPredName
+	idCapture
+	idIdentifier
+	idString
+	idChar
+	idWILDCARD
+	idQUESTION
+	idSTAR
+	idPLUS
+)
+
+var identChars = charset.Range('a', 'z').
+	Add(charset.Range('A', 'Z')).
+	Add(charset.Range('0', '9')).
+	Add(charset.New([]byte{'_'}))
+
+var grammar = map[string]p.Pattern{
+	"Query": p.Cap(p.Concat(
+		p.NonTerm("Spacing"),
+		p.Star(p.NonTerm("Pattern")),
+		p.NonTerm("EndOfFile"),
+	), idQuery),
+	"Pattern": p.Cap(p.Concat(
+		p.Or(
+			p.NonTerm("Group"),
+			p.NonTerm("Node"),
+		),
+		p.Star(p.NonTerm("Capture")),
+	), idPattern),
+	"Group": p.Cap(p.Concat(
+		p.NonTerm("OPEN"),
+		p.NonTerm("Pattern"),
+		p.Star(p.NonTerm("Predicate")),
+		p.NonTerm("CLOSE"),
+	), idGroup),
+	"Node": p.Cap(p.Or(
+		p.Concat(
+			p.NonTerm("OPEN"),
+			p.Or(
+				p.NonTerm("WILDCARD"),
+				p.NonTerm("Identifier"),
+			),
+			p.Star(p.NonTerm("Child")),
+			p.NonTerm("CLOSE"),
+		),
+		p.NonTerm("WILDCARD"),
+	), idNode),
+	"Child": p.Cap(p.Or(
+		p.Concat(
+			p.Optional(p.NonTerm("Field")),
+			p.NonTerm("Node"),
+			p.Optional(p.Or(
+				p.NonTerm("QUESTION"),
+				p.NonTerm("STAR"),
+				p.NonTerm("PLUS"),
+			)),
+			p.Star(p.NonTerm("Capture")),
+		),
+		p.NonTerm("Predicate"),
+	), idChild),
+	"Field": p.Cap(p.Concat(
+		p.NonTerm("Identifier"),
+		p.NonTerm("COLON"),
+	), idField),
+	"Predicate": p.Cap(p.Concat(
+		p.NonTerm("OPEN"),
+		p.Literal("#"),
+		p.NonTerm("PredName"),
+		p.Star(p.Or(
+			p.NonTerm("Capture"),
+			p.NonTerm("String"),
+		)),
+		p.NonTerm("CLOSE"),
+	), idPredicate),
+	"PredName": p.Concat(
+		p.Cap(p.Concat(
+			p.Set(charset.Range('a', 'z')),
+			p.Star(p.Set(charset.Range('a', 'z').Add(charset.New([]byte{'-'})))),
+			p.Optional(p.Literal("?")),
+		), idPredName),
+		p.NonTerm("Spacing"),
+	),
+	"Capture": p.Concat(
+		p.Literal("@"),
+		p.Cap(p.Plus(p.Set(identChars.Add(charset.New([]byte{'.', '-'})))), idCapture),
+		p.NonTerm("Spacing"),
+	),
+
+	"Identifier": p.Concat(
+		p.Cap(p.Concat(
+			p.Set(charset.Range('a', 'z').
+				Add(charset.Range('A', 'Z')).
+				Add(charset.New([]byte{'_'})),
+			),
+			p.Star(p.Set(identChars)),
+		), idIdentifier),
+		p.NonTerm("Spacing"),
+	),
+	"String": p.Cap(p.Concat(
+		p.Literal("\""),
+		p.Star(p.Concat(
+			p.Not(p.Literal("\"")),
+			p.NonTerm("Char"),
+		)),
+		p.Literal("\""),
+		p.NonTerm("Spacing"),
+	), idString),
+	"Char": p.Cap(p.Or(
+		p.Concat(
+			p.Literal("\\"),
+			p.Any(1),
+		),
+		p.Any(1),
+	), idChar),
+
+	"WILDCARD": p.Cap(p.Concat(
+		p.Literal("_"),
+		p.Not(p.Set(identChars)),
+		p.NonTerm("Spacing"),
+	), idWILDCARD),
+	"QUESTION": p.Cap(p.Concat(
+		p.Literal("?"),
+		p.NonTerm("Spacing"),
+	), idQUESTION),
+	"STAR": p.Cap(p.Concat(
+		p.Literal("*"),
+		p.NonTerm("Spacing"),
+	), idSTAR),
+	"PLUS": p.Cap(p.Concat(
+		p.Literal("+"),
+		p.NonTerm("Spacing"),
+	), idPLUS),
+	"COLON": p.Concat(
+		p.Literal(":"),
+		p.NonTerm("Spacing"),
+	),
+	"OPEN": p.Concat(
+		p.Literal("("),
+		p.NonTerm("Spacing"),
+	),
+	"CLOSE": p.Concat(
+		p.Literal(")"),
+		p.NonTerm("Spacing"),
+	),
+
+	"Spacing": p.Star(p.Or(
+		p.NonTerm("Space"),
+		p.NonTerm("Comment"),
+	)),
+	"Comment": p.Concat(
+		p.Literal(";"),
+		p.Star(p.Concat(
+			p.Not(p.NonTerm("EndOfLine")),
+			p.Any(1),
+		)),
+		p.NonTerm("EndOfLine"),
+	),
+	"Space": p.Or(
+		p.Set(charset.New([]byte{' ', '\t'})),
+		p.NonTerm("EndOfLine"),
+	),
+	"EndOfLine": p.Or(
+		p.Literal("\r\n"),
+		p.Literal("\n"),
+		p.Literal("\r"),
+	),
+	"EndOfFile": p.Not(p.Any(1)),
+}
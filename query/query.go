@@ -0,0 +1,546 @@
+// Package query provides a structural query language for capture trees,
+// modeled after tree-sitter's queries. A query is a list of patterns written
+// as S-expressions over capture names, such as
+//
+//	(FuncDecl Name: (Identifier) @fn (Params (Param)* @params))
+//
+// A node pattern (Name child...) matches a capture with the given name whose
+// children, in order but not necessarily adjacent, match the child patterns.
+// The wildcard _ matches any capture. Child patterns may be followed by a
+// quantifier (?, * or +) and any pattern may be followed by @names that bind
+// the matched captures. Since captures have no field names, a field "f: P" is
+// shorthand for (f P): it matches a child named f with a child matching P.
+//
+// Predicates such as (#eq? @fn "main") may appear among the children of a
+// node, or in a group ((Identifier) @id (#match? @id "^[A-Z]")), and
+// constrain the text of the captured nodes. The supported predicates are
+// eq?, not-eq?, match?, not-match?, any-of? and not-any-of?.
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+var parser vm.Code
+
+func init() {
+	prog := pattern.MustCompile(pattern.Grammar("Query", grammar))
+	parser = vm.Encode(prog)
+}
+
+// A Query is a compiled list of patterns.
+type Query struct {
+	patterns []*queryPattern
+	captures []string
+}
+
+type queryPattern struct {
+	root  *step
+	preds []predicate
+}
+
+// A step matches a single capture in the tree. An id of -1 is a wildcard.
+type step struct {
+	id       int
+	quant    int
+	captures []int
+	children []*step
+}
+
+type predicate struct {
+	name string
+	args []argument
+	re   *regexp.Regexp
+}
+
+// An argument is either a capture index or a string (if capture is -1).
+type argument struct {
+	capture int
+	str     string
+}
+
+// A CompileError is returned when a query refers to an unknown name or uses
+// a predicate incorrectly.
+type CompileError struct {
+	Pos     int
+	Message string
+}
+
+// Error returns the error message.
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Pos, e.Message)
+}
+
+// Compile parses the query s. Capture names in the query are resolved to
+// capture IDs with n, which is usually the vm.Code that produces the trees
+// being queried.
+func Compile(s string, n memo.Namer) (*Query, error) {
+	_, ast, errs, err := parser.Parse(strings.NewReader(s), memo.NoneTable{})
+	if len(errs) != 0 {
+		return nil, errs[0]
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{
+		s:     s,
+		namer: n,
+		q:     &Query{},
+	}
+	it := ast.Child(0).ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		qp := &queryPattern{}
+		qp.root, err = c.pattern(ch, qp)
+		if err != nil {
+			return nil, err
+		}
+		c.q.patterns = append(c.q.patterns, qp)
+	}
+	return c.q, nil
+}
+
+// MustCompile is like Compile but panics if the query cannot be compiled.
+func MustCompile(s string, n memo.Namer) *Query {
+	q, err := Compile(s, n)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// NumPatterns returns the number of patterns in the query.
+func (q *Query) NumPatterns() int {
+	return len(q.patterns)
+}
+
+// CaptureNames returns the names of the captures used in the query, in the
+// order they first appear.
+func (q *Query) CaptureNames() []string {
+	return q.captures
+}
+
+type compiler struct {
+	s     string
+	namer memo.Namer
+	q     *Query
+}
+
+func (c *compiler) text(root *memo.Capture) string {
+	return c.s[root.Start():root.End()]
+}
+
+// pattern compiles a top-level pattern or group, adding any predicates it
+// contains to qp.
+func (c *compiler) pattern(root *memo.Capture, qp *queryPattern) (*step, error) {
+	var st *step
+	var err error
+	it := root.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		switch ch.Id() {
+		case idGroup:
+			st, err = c.group(ch, qp)
+		case idNode:
+			st, err = c.node(ch, qp)
+		case idCapture:
+			st.captures = append(st.captures, c.capture(ch))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (c *compiler) group(root *memo.Capture, qp *queryPattern) (*step, error) {
+	var st *step
+	it := root.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		var err error
+		switch ch.Id() {
+		case idPattern:
+			st, err = c.pattern(ch, qp)
+		case idPredicate:
+			err = c.predicate(ch, qp)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (c *compiler) node(root *memo.Capture, qp *queryPattern) (*step, error) {
+	st := &step{
+		id: -1,
+	}
+	it := root.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		var err error
+		switch ch.Id() {
+		case idIdentifier:
+			st.id, err = c.name(ch)
+		case idChild:
+			var child *step
+			child, err = c.child(ch, qp)
+			if child != nil {
+				st.children = append(st.children, child)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// child compiles a child pattern. It returns nil if the child is a predicate.
+func (c *compiler) child(root *memo.Capture, qp *queryPattern) (*step, error) {
+	var st, field *step
+	it := root.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		var err error
+		switch ch.Id() {
+		case idPredicate:
+			return nil, c.predicate(ch, qp)
+		case idField:
+			field = &step{}
+			field.id, err = c.name(ch.Child(0))
+		case idNode:
+			st, err = c.node(ch, qp)
+		case idQUESTION, idSTAR, idPLUS:
+			st.quant = ch.Id()
+		case idCapture:
+			st.captures = append(st.captures, c.capture(ch))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if field != nil {
+		field.quant = st.quant
+		st.quant = 0
+		field.children = []*step{st}
+		return field, nil
+	}
+	return st, nil
+}
+
+func (c *compiler) name(root *memo.Capture) (int, error) {
+	name := c.text(root)
+	id, ok := c.namer.CaptureId(name)
+	if !ok {
+		return 0, &CompileError{
+			Pos:     root.Start(),
+			Message: fmt.Sprintf("unknown name %q", name),
+		}
+	}
+	return id, nil
+}
+
+// capture returns the index of the capture name, adding it if necessary.
+func (c *compiler) capture(root *memo.Capture) int {
+	name := c.text(root)
+	for i, n := range c.q.captures {
+		if n == name {
+			return i
+		}
+	}
+	c.q.captures = append(c.q.captures, name)
+	return len(c.q.captures) - 1
+}
+
+func (c *compiler) predicate(root *memo.Capture, qp *queryPattern) error {
+	pred := predicate{}
+	it := root.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		switch ch.Id() {
+		case idPredName:
+			pred.name = c.text(ch)
+		case idCapture:
+			pred.args = append(pred.args, argument{
+				capture: c.capture(ch),
+			})
+		case idString:
+			pred.args = append(pred.args, argument{
+				capture: -1,
+				str:     c.str(ch),
+			})
+		}
+	}
+
+	errorf := func(format string, args ...interface{}) error {
+		return &CompileError{
+			Pos:     root.Start(),
+			Message: fmt.Sprintf("#%s: ", pred.name) + fmt.Sprintf(format, args...),
+		}
+	}
+	if len(pred.args) == 0 || pred.args[0].capture == -1 {
+		return errorf("first argument must be a capture")
+	}
+	switch pred.name {
+	case "eq?", "not-eq?":
+		if len(pred.args) != 2 {
+			return errorf("expected 2 arguments, got %d", len(pred.args))
+		}
+	case "match?", "not-match?":
+		if len(pred.args) != 2 || pred.args[1].capture != -1 {
+			return errorf("expected a capture and a string")
+		}
+		var err error
+		pred.re, err = regexp.Compile(pred.args[1].str)
+		if err != nil {
+			return errorf("%v", err)
+		}
+	case "any-of?", "not-any-of?":
+		for _, a := range pred.args[1:] {
+			if a.capture != -1 {
+				return errorf("expected a capture and strings")
+			}
+		}
+	default:
+		return errorf("unknown predicate")
+	}
+	qp.preds = append(qp.preds, pred)
+	return nil
+}
+
+// str returns the contents of a string literal with escapes removed.
+func (c *compiler) str(root *memo.Capture) string {
+	buf := &bytes.Buffer{}
+	it := root.ChildIterator(0)
+	for ch := it(); ch != nil; ch = it() {
+		t := c.text(ch)
+		if t[0] != '\\' {
+			buf.WriteString(t)
+			continue
+		}
+		switch t[1] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		default:
+			buf.WriteByte(t[1])
+		}
+	}
+	return buf.String()
+}
+
+// A Capture is a node bound to a capture name by a match.
+type Capture struct {
+	// Index is the index of the name in the query's CaptureNames.
+	Index int
+	Name  string
+	Node  *memo.Capture
+}
+
+// A Match is a successful match of one of the query's patterns.
+type Match struct {
+	// Pattern is the index of the pattern that matched.
+	Pattern int
+	// Captures are the nodes bound by the match in the order they appear in
+	// the tree.
+	Captures []Capture
+}
+
+// Matches returns the matches of the query in the capture tree root, as
+// returned by vm.Code.Exec, over the subject r. Nodes are visited in
+// pre-order and at each node the patterns are tried in order. Each pattern
+// produces at most one match per node: the first one found when quantifiers
+// are matched greedily.
+func (q *Query) Matches(root *memo.Capture, r io.ReaderAt) []Match {
+	var matches []Match
+	if root == nil {
+		return nil
+	}
+	m := &matcher{
+		r: r,
+	}
+	var visit func(c *memo.Capture)
+	visit = func(c *memo.Capture) {
+		if !c.Dummy() {
+			for i, qp := range q.patterns {
+				if m.match(qp, c) {
+					matches = append(matches, q.newMatch(i, m.binds))
+				}
+			}
+		}
+		it := c.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			visit(ch)
+		}
+	}
+	visit(root)
+	return matches
+}
+
+func (q *Query) newMatch(pattern int, binds []binding) Match {
+	match := Match{
+		Pattern:  pattern,
+		Captures: make([]Capture, len(binds)),
+	}
+	for i, b := range binds {
+		match.Captures[i] = Capture{
+			Index: b.capture,
+			Name:  q.captures[b.capture],
+			Node:  b.node,
+		}
+	}
+	return match
+}
+
+type binding struct {
+	capture int
+	node    *memo.Capture
+}
+
+type matcher struct {
+	r     io.ReaderAt
+	binds []binding
+}
+
+func (m *matcher) match(qp *queryPattern, c *memo.Capture) bool {
+	m.binds = m.binds[:0]
+	return m.matchStep(qp.root, c, func() bool {
+		return m.check(qp.preds)
+	})
+}
+
+// matchStep matches st against c and then calls k, backtracking if k fails.
+// Matching in continuation-passing style allows quantifiers and predicates to
+// backtrack into earlier choices.
+func (m *matcher) matchStep(st *step, c *memo.Capture, k func() bool) bool {
+	if st.id != -1 && (c.Dummy() || c.Id() != st.id) {
+		return false
+	}
+	n := len(m.binds)
+	for _, capt := range st.captures {
+		m.binds = append(m.binds, binding{
+			capture: capt,
+			node:    c,
+		})
+	}
+	if len(st.children) == 0 {
+		if k() {
+			return true
+		}
+	} else {
+		var children []*memo.Capture
+		it := c.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			children = append(children, ch)
+		}
+		if m.matchChildren(st.children, children, k) {
+			return true
+		}
+	}
+	m.binds = m.binds[:n]
+	return false
+}
+
+// matchChildren matches the steps against a subsequence of children.
+func (m *matcher) matchChildren(steps []*step, children []*memo.Capture, k func() bool) bool {
+	if len(steps) == 0 {
+		return k()
+	}
+	st := steps[0]
+	// one matches a single occurrence of st in children and continues with
+	// the children after it.
+	one := func(children []*memo.Capture, rest func([]*memo.Capture) bool) bool {
+		for i, ch := range children {
+			after := children[i+1:]
+			if m.matchStep(st, ch, func() bool { return rest(after) }) {
+				return true
+			}
+		}
+		return false
+	}
+	next := func(children []*memo.Capture) bool {
+		return m.matchChildren(steps[1:], children, k)
+	}
+	var star func(children []*memo.Capture) bool
+	star = func(children []*memo.Capture) bool {
+		return one(children, star) || next(children)
+	}
+
+	switch st.quant {
+	case idQUESTION:
+		return one(children, next) || next(children)
+	case idSTAR:
+		return star(children)
+	case idPLUS:
+		return one(children, star)
+	}
+	return one(children, next)
+}
+
+func (m *matcher) check(preds []predicate) bool {
+	for _, p := range preds {
+		if !m.checkPredicate(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *matcher) text(c *memo.Capture) string {
+	return string(input.Slice(m.r, c.Start(), c.End()))
+}
+
+func (m *matcher) nodes(capture int) []*memo.Capture {
+	var nodes []*memo.Capture
+	for _, b := range m.binds {
+		if b.capture == capture {
+			nodes = append(nodes, b.node)
+		}
+	}
+	return nodes
+}
+
+// checkPredicate reports whether every node bound to the first argument of p
+// satisfies it. If the second argument of eq? is a capture, the text is
+// compared with the text of the first node bound to it.
+func (m *matcher) checkPredicate(p predicate) bool {
+	negate := strings.HasPrefix(p.name, "not-")
+	for _, n := range m.nodes(p.args[0].capture) {
+		text := m.text(n)
+		var ok bool
+		switch strings.TrimPrefix(p.name, "not-") {
+		case "eq?":
+			other := p.args[1].str
+			if p.args[1].capture != -1 {
+				nodes := m.nodes(p.args[1].capture)
+				if len(nodes) == 0 {
+					return false
+				}
+				other = m.text(nodes[0])
+			}
+			ok = text == other
+		case "match?":
+			ok = p.re.MatchString(text)
+		case "any-of?":
+			for _, a := range p.args[1:] {
+				if text == a.str {
+					ok = true
+					break
+				}
+			}
+		}
+		if ok == negate {
+			return false
+		}
+	}
+	return true
+}
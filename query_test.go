@@ -0,0 +1,83 @@
+package gpeg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/query"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestQuery(t *testing.T) {
+	p := re.MustCompileCap(`
+		Program    <- S FuncDecl*
+		FuncDecl   <- 'func' S Name Params Body
+		Name       <- Identifier S
+		Params     <- '(' S (Param (',' S Param)*)? ')' S
+		Param      <- Identifier S
+		Body       <- '{' S Call* '}' S
+		Call       <- Identifier '(' S ')' S
+		Identifier <- [a-zA-Z_]+
+		S          <- [ \t\n]*
+	`, nil)
+	code := vm.Encode(pattern.MustCompile(p))
+	in := `
+		func main() { print() exit() }
+		func add(a, b) { }
+		func Upper(x) { print() }
+	`
+	r := strings.NewReader(in)
+	_, _, ast, _, _ := code.Exec(r, memo.NoneTable{})
+
+	text := func(c *memo.Capture) string {
+		return in[c.Start():c.End()]
+	}
+	run := func(q string) string {
+		var results []string
+		for _, m := range query.MustCompile(q, &code).Matches(ast, r) {
+			var s []string
+			for _, c := range m.Captures {
+				s = append(s, c.Name+"="+text(c.Node))
+			}
+			results = append(results, strings.Join(s, " "))
+		}
+		return strings.Join(results, "; ")
+	}
+
+	tests := []struct {
+		query  string
+		expect string
+	}{
+		{`(FuncDecl Name: (Identifier) @fn)`, "fn=main; fn=add; fn=Upper"},
+		{`(FuncDecl (Name (Identifier) @fn) (Params (Param)+ @param))`, "fn=add param=a param=b; fn=Upper param=x"},
+		{`(FuncDecl (Name) @fn (Params (Param)? @param) (Body (Call)* @call))`,
+			"fn=main call=print()  call=exit() ; fn=add param=a; fn=Upper param=x call=print() "},
+		{`((Call (Identifier) @call) (#eq? @call "print"))`, "call=print; call=print"},
+		{`(FuncDecl Name: (_) @fn (Body (Call (Identifier) @call (#not-eq? @call "print"))))`, "fn=main call=exit"},
+		{`(FuncDecl Name: (Identifier) @fn (#match? @fn "^[A-Z]"))`, "fn=Upper"},
+		{`(FuncDecl Name: (Identifier) @fn (#any-of? @fn "add" "sub")) ; comment
+		  (Param) @param`, "fn=add; param=a; param=b; param=x"},
+		{`(FuncDecl (Params (Param) @a (Param) @b (#eq? @a @b)))`, ""},
+	}
+	for _, tt := range tests {
+		if got := run(tt.query); got != tt.expect {
+			t.Errorf("%s: got %q, expected %q", tt.query, got, tt.expect)
+		}
+	}
+
+	errs := []string{
+		`(FuncDecl (Missing))`,
+		`(FuncDecl`,
+		`((Name) @n (#eq? "main" @n))`,
+		`((Name) @n (#match? @n "("))`,
+		`((Name) @n (#unknown? @n))`,
+	}
+	for _, q := range errs {
+		if _, err := query.Compile(q, &code); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
@@ -5,36 +5,55 @@ import (
 	p "github.com/zyedidia/gpeg/pattern"
 )
 
-// Pattern    <- Spacing_ (Grammar / Expression) EndOfFile_
+// Pattern    <- Spacing_ Import* (Grammar / Expression) EndOfFile_
+// Import     <- IMPORT Literal AS Identifier
 // Grammar    <- Definition+
-// Definition <- Identifier LEFTARROW Expression
+// Definition <- (Signature / Qualified / Identifier) (LEFTARROW / EXTEND) Expression
+// Signature  <- Name ('.' Name)* Params
+// Params     <- '(' Spacing_ Identifier (COMMA Identifier)* CLOSE
 //
-// Expression <- Sequence (SLASH Sequence)*
+// Expression <- Sequence ((CATCH / SLASH) Sequence)*
 // Sequence   <- Prefix*
-// Prefix     <- (AND / NOT)? Suffix
-// Suffix     <- Primary (QUESTION / STAR / PLUS)?
-// Primary    <- Identifier !LEFTARROW
+// Prefix     <- (AND / NOT / DEF)? Suffix
+// Suffix     <- Primary (QUESTION / STAR / PLUS / Repeat)?
+// Repeat     <- '{' Spacing_ Number (COMMA Number?)? '}' Spacing_
+// Primary    <- Call !(LEFTARROW / EXTEND)
+// 			/ !Signature Qualified !(LEFTARROW / EXTEND)
+// 			/ !Qualified !Signature Identifier !LEFTARROW
 // 			/ '(' Expression ')'
-// 			/ Literal / Class
+// 			/ Literal / Class / Property Spacing_
 // 			/ BRACEPO Expression BRACEPC
 // 			/ BRACEO Expression BRACEC
+// 			/ CARAT Identifier
+// 			/ EQUALS Identifier
+// 			/ SCOPEO Expression CLOSE
 // 			/ DOT
 //
-// Identifier <- IdentStart IdentCont* Spacing_
+// Call       <- Name ('.' Name)* '(' Spacing_ Expression (COMMA Expression)* CLOSE
+// Qualified  <- Name ('.' Name)+ Spacing_
+// Identifier <- Name Spacing_
+// Name       <- IdentStart IdentCont*
 // IdentStart <- [a-zA-Z_]
 // IdentCont  <- IdentStart / [0-9]
+// Number     <- [0-9]+ Spacing_
 //
-// Literal    <- ['] (!['] Char)* ['] Spacing_
-// 			/ ["] (!["] Char)* ["] Spacing_
-// Class      <- '[' CARAT? (!']' Range)* ']' Spacing_
+// Literal    <- ['] (!['] Char)* ['] FOLD? Spacing_
+// 			/ ["] (!["] Char)* ["] FOLD? Spacing_
+// Class      <- '[' CARAT? (!']' (Property / Range))* ']' FOLD? Spacing_
 // Range      <- Char '-' Char / Char
 // Char       <- '\\' [nrt'"\[\]\\\-]
 // 			/ '\\' [0-2][0-7][0-7]
 // 			/ '\\' [0-7][0-7]?
-// 			/ !'\\' .
+// 			/ !'\\' .  # a UTF-8 encoded character
+// Property   <- '\\' [pP] '{' [a-zA-Z_]+ '}'
+//
+// FOLD       <- 'i' !IdentCont
 //
 // AND        <- '&' Spacing_
 // NOT        <- '!' Spacing_
+// DEF        <- '%' Identifier ':' Spacing_
+// EQUALS     <- '=' Spacing_
+// SCOPEO     <- '%(' Spacing_
 // QUESTION   <- '?' Spacing_
 // STAR       <- '*' Spacing_
 // PLUS       <- '+' Spacing_
@@ -45,9 +64,14 @@ import (
 // BRACEPO    <- '{{' Spacing_
 // BRACEPC    <- '}}' Spacing_
 // LEFTARROW  <- '<-' Spacing_
+// EXTEND     <- '+=' Spacing_
+// IMPORT     <- 'import' !IdentCont Spacing_
+// AS         <- 'as' !IdentCont Spacing_
 // OPEN       <- '(' Spacing_
 // CLOSE      <- ')' Spacing_
 // SLASH      <- '/' Spacing_
+// CATCH      <- '//{' Spacing_ Identifier (COMMA Identifier)* '}' Spacing_
+// COMMA      <- ',' Spacing_
 //
 // Spacing_   <- (Space_ / Comment_)*
 // Comment_   <- '#' (!EndOfLine_ .)* EndOfLine_
@@ -81,28 +105,78 @@ const (
 	idOPEN
 	idBRACEO
 	idBRACEPO
+	idRepeat
+	idNumber
+	idCOMMA
+	idCATCH
+	idProperty
+	idFOLD
+	idDEF
+	idEQUALS
+	idSCOPEO
+	idImport
+	idQualified
+	idEXTEND
+	idSignature
+	idParams
+	idCall
 )
 
 var grammar = map[string]p.Pattern{
 	"Pattern": p.Cap(p.Concat(
 		p.NonTerm("Spacing"),
+		p.Star(p.NonTerm("Import")),
 		p.Or(
 			p.NonTerm("Grammar"),
 			p.NonTerm("Expression"),
 		),
 		p.NonTerm("EndOfFile"),
 	), idPattern),
+	"Import": p.Cap(p.Concat(
+		p.NonTerm("IMPORT"),
+		p.NonTerm("Literal"),
+		p.NonTerm("AS"),
+		p.NonTerm("Identifier"),
+	), idImport),
 	"Grammar": p.Cap(p.Plus(p.NonTerm("Definition")), idGrammar),
 	"Definition": p.Cap(p.Concat(
-		p.NonTerm("Identifier"),
-		p.NonTerm("LEFTARROW"),
+		p.Or(
+			p.NonTerm("Signature"),
+			p.NonTerm("Qualified"),
+			p.NonTerm("Identifier"),
+		),
+		p.Or(
+			p.NonTerm("LEFTARROW"),
+			p.NonTerm("EXTEND"),
+		),
 		p.NonTerm("Expression"),
 	), idDefinition),
+	"Signature": p.Cap(p.Concat(
+		p.NonTerm("Name"),
+		p.Star(p.Concat(
+			p.Literal("."),
+			p.NonTerm("Name"),
+		)),
+		p.NonTerm("Params"),
+	), idSignature),
+	"Params": p.Cap(p.Concat(
+		p.Literal("("),
+		p.NonTerm("Spacing"),
+		p.NonTerm("Identifier"),
+		p.Star(p.Concat(
+			p.NonTerm("COMMA"),
+			p.NonTerm("Identifier"),
+		)),
+		p.NonTerm("CLOSE"),
+	), idParams),
 
 	"Expression": p.Cap(p.Concat(
 		p.NonTerm("Sequence"),
 		p.Star(p.Concat(
-			p.NonTerm("SLASH"),
+			p.Or(
+				p.NonTerm("CATCH"),
+				p.NonTerm("SLASH"),
+			),
 			p.NonTerm("Sequence"),
 		)),
 	), idExpression),
@@ -111,6 +185,7 @@ var grammar = map[string]p.Pattern{
 		p.Optional(p.Or(
 			p.NonTerm("AND"),
 			p.NonTerm("NOT"),
+			p.NonTerm("DEF"),
 		)),
 		p.NonTerm("Suffix"),
 	), idPrefix),
@@ -120,10 +195,39 @@ var grammar = map[string]p.Pattern{
 			p.NonTerm("QUESTION"),
 			p.NonTerm("STAR"),
 			p.NonTerm("PLUS"),
+			p.NonTerm("Repeat"),
 		)),
 	), idSuffix),
+	"Repeat": p.Cap(p.Concat(
+		p.Literal("{"),
+		p.NonTerm("Spacing"),
+		p.NonTerm("Number"),
+		p.Optional(p.Concat(
+			p.NonTerm("COMMA"),
+			p.Optional(p.NonTerm("Number")),
+		)),
+		p.Literal("}"),
+		p.NonTerm("Spacing"),
+	), idRepeat),
 	"Primary": p.Cap(p.Or(
 		p.Concat(
+			p.NonTerm("Call"),
+			p.Not(p.Or(
+				p.NonTerm("LEFTARROW"),
+				p.NonTerm("EXTEND"),
+			)),
+		),
+		p.Concat(
+			p.Not(p.NonTerm("Signature")),
+			p.NonTerm("Qualified"),
+			p.Not(p.Or(
+				p.NonTerm("LEFTARROW"),
+				p.NonTerm("EXTEND"),
+			)),
+		),
+		p.Concat(
+			p.Not(p.NonTerm("Qualified")),
+			p.Not(p.NonTerm("Signature")),
 			p.NonTerm("Identifier"),
 			p.Not(p.NonTerm("LEFTARROW")),
 		),
@@ -144,14 +248,58 @@ var grammar = map[string]p.Pattern{
 		),
 		p.NonTerm("Literal"),
 		p.NonTerm("Class"),
+		p.Concat(
+			p.NonTerm("Property"),
+			p.NonTerm("Spacing"),
+		),
+		p.Concat(
+			p.NonTerm("CARAT"),
+			p.NonTerm("Identifier"),
+		),
+		p.Concat(
+			p.NonTerm("EQUALS"),
+			p.NonTerm("Identifier"),
+		),
+		p.Concat(
+			p.NonTerm("SCOPEO"),
+			p.NonTerm("Expression"),
+			p.NonTerm("CLOSE"),
+		),
 		p.NonTerm("DOT"),
 	), idPrimary),
 
+	"Call": p.Cap(p.Concat(
+		p.NonTerm("Name"),
+		p.Star(p.Concat(
+			p.Literal("."),
+			p.NonTerm("Name"),
+		)),
+		p.Literal("("),
+		p.NonTerm("Spacing"),
+		p.NonTerm("Expression"),
+		p.Star(p.Concat(
+			p.NonTerm("COMMA"),
+			p.NonTerm("Expression"),
+		)),
+		p.NonTerm("CLOSE"),
+	), idCall),
+	"Qualified": p.Cap(p.Concat(
+		p.NonTerm("Name"),
+		p.Plus(p.Concat(
+			p.Literal("."),
+			p.NonTerm("Name"),
+		)),
+		p.NonTerm("Spacing"),
+	), idQualified),
 	"Identifier": p.Cap(p.Concat(
 		p.NonTerm("IdentStart"),
 		p.Star(p.NonTerm("IdentCont")),
 		p.NonTerm("Spacing"),
 	), idIdentifier),
+	"Name": p.Cap(p.Concat(
+		p.NonTerm("IdentStart"),
+		p.Star(p.NonTerm("IdentCont")),
+	), idIdentifier),
 	"IdentStart": p.Cap(
 		p.Set(charset.Range('a', 'z').
 			Add(charset.Range('A', 'Z')).
@@ -162,6 +310,11 @@ var grammar = map[string]p.Pattern{
 		p.Set(charset.Range('0', '9')),
 	), idIdentCont),
 
+	"Number": p.Concat(
+		p.Cap(p.Plus(p.Set(charset.Range('0', '9'))), idNumber),
+		p.NonTerm("Spacing"),
+	),
+
 	"Literal": p.Cap(p.Or(
 		p.Concat(
 			p.Literal("'"),
@@ -170,6 +323,7 @@ var grammar = map[string]p.Pattern{
 				p.NonTerm("Char"),
 			)),
 			p.Literal("'"),
+			p.Optional(p.NonTerm("FOLD")),
 			p.NonTerm("Spacing"),
 		),
 		p.Concat(
@@ -179,6 +333,7 @@ var grammar = map[string]p.Pattern{
 				p.NonTerm("Char"),
 			)),
 			p.Literal("\""),
+			p.Optional(p.NonTerm("FOLD")),
 			p.NonTerm("Spacing"),
 		),
 	), idLiteral),
@@ -187,11 +342,19 @@ var grammar = map[string]p.Pattern{
 		p.Optional(p.NonTerm("CARAT")),
 		p.Star(p.Concat(
 			p.Not(p.Literal("]")),
-			p.NonTerm("Range"),
+			p.Or(
+				p.NonTerm("Property"),
+				p.NonTerm("Range"),
+			),
 		)),
 		p.Literal("]"),
+		p.Optional(p.NonTerm("FOLD")),
 		p.NonTerm("Spacing"),
 	), idClass),
+	"FOLD": p.Cap(p.Concat(
+		p.Literal("i"),
+		p.Not(p.NonTerm("IdentCont")),
+	), idFOLD),
 	"Range": p.Cap(p.Or(
 		p.Concat(
 			p.NonTerm("Char"),
@@ -218,9 +381,19 @@ var grammar = map[string]p.Pattern{
 		),
 		p.Concat(
 			p.Not(p.Literal("\\")),
-			p.Any(1),
+			p.AnyRune(),
 		),
 	), idChar),
+	"Property": p.Cap(p.Concat(
+		p.Literal("\\"),
+		p.Set(charset.New([]byte{'p', 'P'})),
+		p.Literal("{"),
+		p.Plus(p.Set(charset.Range('a', 'z').
+			Add(charset.Range('A', 'Z')).
+			Add(charset.New([]byte{'_'})),
+		)),
+		p.Literal("}"),
+	), idProperty),
 
 	"AND": p.Cap(p.Concat(
 		p.Literal("&"),
@@ -230,6 +403,20 @@ var grammar = map[string]p.Pattern{
 		p.Literal("!"),
 		p.NonTerm("Spacing"),
 	), idNOT),
+	"DEF": p.Cap(p.Concat(
+		p.Literal("%"),
+		p.NonTerm("Identifier"),
+		p.Literal(":"),
+		p.NonTerm("Spacing"),
+	), idDEF),
+	"EQUALS": p.Cap(p.Concat(
+		p.Literal("="),
+		p.NonTerm("Spacing"),
+	), idEQUALS),
+	"SCOPEO": p.Cap(p.Concat(
+		p.Literal("%("),
+		p.NonTerm("Spacing"),
+	), idSCOPEO),
 	"QUESTION": p.Cap(p.Concat(
 		p.Literal("?"),
 		p.NonTerm("Spacing"),
@@ -278,10 +465,39 @@ var grammar = map[string]p.Pattern{
 		p.Literal("/"),
 		p.NonTerm("Spacing"),
 	),
+	"CATCH": p.Cap(p.Concat(
+		p.Literal("//{"),
+		p.NonTerm("Spacing"),
+		p.NonTerm("Identifier"),
+		p.Star(p.Concat(
+			p.NonTerm("COMMA"),
+			p.NonTerm("Identifier"),
+		)),
+		p.Literal("}"),
+		p.NonTerm("Spacing"),
+	), idCATCH),
+	"COMMA": p.Cap(p.Concat(
+		p.Literal(","),
+		p.NonTerm("Spacing"),
+	), idCOMMA),
 	"LEFTARROW": p.Concat(
 		p.Literal("<-"),
 		p.NonTerm("Spacing"),
 	),
+	"EXTEND": p.Cap(p.Concat(
+		p.Literal("+="),
+		p.NonTerm("Spacing"),
+	), idEXTEND),
+	"IMPORT": p.Concat(
+		p.Literal("import"),
+		p.Not(p.NonTerm("IdentCont")),
+		p.NonTerm("Spacing"),
+	),
+	"AS": p.Concat(
+		p.Literal("as"),
+		p.Not(p.NonTerm("IdentCont")),
+		p.NonTerm("Spacing"),
+	),
 
 	"Spacing": p.Star(p.Or(
 		p.NonTerm("Space"),
@@ -5,10 +5,16 @@ package re
 import (
 	"bytes"
 	"fmt"
+	"io/fs"
+	"math"
+	"path"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/isa"
 	"github.com/zyedidia/gpeg/memo"
 	"github.com/zyedidia/gpeg/pattern"
 	"github.com/zyedidia/gpeg/vm"
@@ -21,85 +27,232 @@ func init() {
 	parser = vm.Encode(prog)
 }
 
-func compile(root *memo.Capture, s string, capg bool, ids map[string]int) pattern.Pattern {
+// A compiler holds the state of the compilation of a pattern.
+type compiler struct {
+	// whether to capture every rule of a grammar, with the given ids.
+	capg bool
+	ids  map[string]int
+
+	// the file system that imported modules are loaded from, the path of
+	// the module being compiled, and the paths of the modules whose
+	// compilation is in progress.
+	fsys    fs.FS
+	file    string
+	loading map[string]bool
+	// the prefix of the names of the rules of the module, which is empty
+	// for the pattern being compiled and otherwise the names it was
+	// imported as followed by dots.
+	prefix string
+	// the names that modules are imported as, and the rules of the module
+	// by their full names, including those of the modules it imports.
+	aliases map[string]bool
+	rules   map[string]pattern.Pattern
+	// the position of the first reference to each rule of an imported
+	// module, by full name.
+	qualified map[string]int
+	// the rules with parameters of the module by full name, and the
+	// arguments that parameters are bound to while one is expanded.
+	macros map[string]*macro
+	env    map[string]binding
+	// the names of the rules created by expansions, and the number of
+	// nested expansions in progress.
+	expanded map[string]bool
+	depth    *int
+
+	// the checker of all back-references, and the id of each symbol by full
+	// name.
+	refs *isa.BackReference
+	syms map[string]int
+	// whether each symbol of the module is defined, and the position of its
+	// first use.
+	defined map[string]bool
+	used    map[string]int
+}
+
+func newCompiler(fsys fs.FS, file string) *compiler {
+	return &compiler{
+		fsys:      fsys,
+		file:      file,
+		loading:   map[string]bool{file: true},
+		aliases:   make(map[string]bool),
+		rules:     make(map[string]pattern.Pattern),
+		qualified: make(map[string]int),
+		macros:    make(map[string]*macro),
+		expanded:  make(map[string]bool),
+		depth:     new(int),
+		refs:      isa.NewBackRef(),
+		syms:      make(map[string]int),
+		defined:   make(map[string]bool),
+		used:      make(map[string]int),
+	}
+}
+
+func (cm *compiler) compile(root *memo.Capture, s string) pattern.Pattern {
 	var p pattern.Pattern
 	switch root.Id() {
 	case idPattern:
-		p = compile(root.Child(0), s, capg, ids)
+		it := root.ChildIterator(0)
+		for c := it(); c != nil; c = it() {
+			if c.Id() == idImport {
+				cm.compileImport(c, s)
+				continue
+			}
+			if len(cm.aliases) > 0 && c.Id() != idGrammar {
+				errorf(c.Start(), "only a grammar can import modules")
+			}
+			p = cm.compile(c, s)
+		}
 	case idGrammar:
-		nonterms := make(map[string]pattern.Pattern)
-		var first string
+		// rules with parameters can be called before they are defined.
 		it := root.ChildIterator(0)
 		for c := it(); c != nil; c = it() {
-			k, v := compileDef(c, s, capg, ids)
+			if c.Child(0).Id() == idSignature {
+				cm.compileMacro(c, s)
+			}
+		}
+		var first string
+		it = root.ChildIterator(0)
+		for c := it(); c != nil; c = it() {
+			if c.Child(0).Id() == idSignature {
+				continue
+			}
+			k := cm.compileDef(c, s)
 			if first == "" {
 				first = k
 			}
-			nonterms[k] = v
 		}
-		if capg {
-			p = pattern.CapGrammar(first, nonterms, ids)
+		if first == "" && cm.prefix == "" {
+			errorf(root.Start(), "a grammar needs a rule without parameters")
+		}
+		// rules of imported modules that are referred to must exist, but
+		// references to other rules are checked when the grammar is
+		// compiled.
+		for name, pos := range cm.qualified {
+			if _, ok := cm.rules[name]; !ok {
+				errorf(pos, "undefined rule %s", strings.TrimPrefix(name, cm.prefix))
+			}
+		}
+		if cm.capg {
+			// expanded rules are part of the rules that call them, so they
+			// are not captured.
+			named := make(map[string]pattern.Pattern)
+			for name, rule := range cm.rules {
+				if !cm.expanded[name] {
+					named[name] = rule
+				}
+			}
+			g := pattern.CapGrammar(first, named, cm.ids).(*pattern.GrammarNode)
+			for name, rule := range cm.rules {
+				if cm.expanded[name] {
+					g.Defs[name] = rule
+				}
+			}
+			p = g
 		} else {
-			p = pattern.Grammar(first, nonterms)
+			p = pattern.Grammar(first, cm.rules)
 		}
 	case idExpression:
 		alternations := make([]pattern.Pattern, 0, root.NumChildren())
+		var labels []string
 		it := root.ChildIterator(0)
 		for c := it(); c != nil; c = it() {
-			alternations = append(alternations, compile(c, s, capg, ids))
+			if c.Id() == idCATCH {
+				labels = compileLabels(c, s)
+				continue
+			}
+			alt := cm.compile(c, s)
+			if labels != nil {
+				// labeled choices are left associative: everything before
+				// the choice is the pattern whose labels are caught.
+				alt = pattern.Catch(pattern.Or(alternations...), alt, labels...)
+				alternations = alternations[:0]
+				labels = nil
+			}
+			alternations = append(alternations, alt)
 		}
 		p = pattern.Or(alternations...)
 	case idSequence:
 		concats := make([]pattern.Pattern, 0, root.NumChildren())
 		it := root.ChildIterator(0)
 		for c := it(); c != nil; c = it() {
-			concats = append(concats, compile(c, s, capg, ids))
+			concats = append(concats, cm.compile(c, s))
 		}
 		p = pattern.Concat(concats...)
 	case idPrefix:
 		c := root.Child(0)
 		switch c.Id() {
 		case idAND:
-			p = pattern.And(compile(root.Child(1), s, capg, ids))
+			p = pattern.And(cm.compile(root.Child(1), s))
 		case idNOT:
-			p = pattern.Not(compile(root.Child(1), s, capg, ids))
+			p = pattern.Not(cm.compile(root.Child(1), s))
+		case idDEF:
+			name := parseId(c.Child(0), s)
+			cm.defined[name] = true
+			p = pattern.CheckFlags(cm.compile(root.Child(1), s), cm.refs, cm.symbol(name), int(isa.RefDef))
 		default:
-			p = compile(root.Child(0), s, capg, ids)
+			p = cm.compile(root.Child(0), s)
 		}
 	case idSuffix:
 		if root.NumChildren() == 2 {
 			c := root.Child(1)
 			switch c.Id() {
 			case idQUESTION:
-				p = pattern.Optional(compile(root.Child(0), s, capg, ids))
+				p = pattern.Optional(cm.compile(root.Child(0), s))
 			case idSTAR:
-				p = pattern.Star(compile(root.Child(0), s, capg, ids))
+				p = pattern.Star(cm.compile(root.Child(0), s))
 			case idPLUS:
-				p = pattern.Plus(compile(root.Child(0), s, capg, ids))
+				p = pattern.Plus(cm.compile(root.Child(0), s))
+			case idRepeat:
+				p = compileRepeat(cm.compile(root.Child(0), s), c, s)
 			}
 		} else {
-			p = compile(root.Child(0), s, capg, ids)
+			p = cm.compile(root.Child(0), s)
 		}
 	case idPrimary:
 		switch root.Child(0).Id() {
 		case idIdentifier, idLiteral, idClass:
-			p = compile(root.Child(0), s, capg, ids)
+			p = cm.compile(root.Child(0), s)
+		case idCall:
+			p = cm.compileCall(root.Child(0), s)
+		case idQualified:
+			name := cm.parseQualified(root.Child(0), s)
+			if m, ok := cm.macros[name]; ok {
+				errorf(root.Start(), "%s takes %d arguments", strings.TrimPrefix(name, cm.prefix), len(m.params))
+			}
+			if _, ok := cm.qualified[name]; !ok {
+				cm.qualified[name] = root.Start()
+			}
+			p = pattern.NonTerm(name)
 		case idOPEN:
-			p = compile(root.Child(1), s, capg, ids)
+			p = cm.compile(root.Child(1), s)
 		case idBRACEPO:
-			p = pattern.Memo(compile(root.Child(1), s, capg, ids))
+			p = pattern.Memo(cm.compile(root.Child(1), s))
+		case idCARAT:
+			p = pattern.Throw(parseId(root.Child(1), s))
 		case idDOT:
 			p = pattern.Any(1)
+		case idEQUALS:
+			name := parseId(root.Child(1), s)
+			if _, ok := cm.used[name]; !ok {
+				cm.used[name] = root.Start()
+			}
+			p = pattern.CheckFlags(&pattern.EmptyNode{}, cm.refs, cm.symbol(name), int(isa.RefUse))
+		case idSCOPEO:
+			p = pattern.CheckFlags(cm.compile(root.Child(1), s), cm.refs, 0, int(isa.RefBlock))
+		case idProperty:
+			if name, negate := parseProperty(root.Child(0), s); name == "Any" && !negate {
+				p = pattern.AnyRune()
+			} else {
+				p = pattern.RuneSet(compileProperty(root.Child(0), s))
+			}
 		}
 	case idLiteral:
-		lit := &bytes.Buffer{}
-		it := root.ChildIterator(0)
-		for c := it(); c != nil; c = it() {
-			lit.WriteByte(parseChar(s[c.Start():c.End()]))
+		if lit, fold := parseLiteral(root, s); fold {
+			p = pattern.LiteralFold(lit)
+		} else {
+			p = pattern.Literal(lit)
 		}
-		p = pattern.Literal(lit.String())
 	case idClass:
-		var set charset.Set
 		if root.NumChildren() <= 0 {
 			break
 		}
@@ -107,6 +260,12 @@ func compile(root *memo.Capture, s string, capg bool, ids map[string]int) patter
 		if root.Child(0).Id() == idCARAT {
 			complement = true
 		}
+		// a class that contains non-ASCII characters or Unicode classes
+		// matches code points, and otherwise bytes.
+		var ranges []charset.RuneRange
+		var runes charset.RuneSet
+		runeClass := false
+		fold := false
 		it := root.ChildIterator(0)
 		i := 0
 		for c := it(); c != nil; c = it() {
@@ -114,16 +273,104 @@ func compile(root *memo.Capture, s string, capg bool, ids map[string]int) patter
 				i++
 				continue
 			}
-			set = set.Add(compileSet(c, s))
+			if c.Id() == idFOLD {
+				fold = true
+				continue
+			}
+			if c.Id() == idProperty {
+				runes = runes.Add(compileProperty(c, s))
+				runeClass = true
+				continue
+			}
+			r, u := compileRange(c, s)
+			ranges = append(ranges, r)
+			runeClass = runeClass || u
+		}
+		if runeClass {
+			runes = runes.Add(charset.NewRuneSet(ranges...))
+			if fold {
+				runes = runes.Fold()
+			}
+			if complement {
+				runes = runes.Complement()
+			}
+			p = pattern.RuneSet(runes)
+			break
+		}
+		var set charset.Set
+		for _, r := range ranges {
+			set = set.Add(charset.Range(byte(r.Lo), byte(r.Hi)))
+		}
+		if fold {
+			set = set.Fold()
 		}
 		if complement {
 			set = set.Complement()
 		}
 		p = pattern.Set(set)
 	case idIdentifier:
-		p = pattern.NonTerm(parseId(root, s))
+		name := parseId(root, s)
+		if b, ok := cm.env[name]; ok {
+			p = b.patt
+			break
+		}
+		if m, ok := cm.macros[cm.prefix+name]; ok {
+			errorf(root.Start(), "%s takes %d arguments", name, len(m.params))
+		}
+		p = pattern.NonTerm(cm.prefix + name)
 	}
-	return p
+
+	if cm.prefix != "" {
+		// locations in imported modules are not locations in the source of
+		// the pattern.
+		return p
+	}
+	switch p.(type) {
+	case nil, *pattern.SourceNode, *pattern.GrammarNode:
+		// grammars are left unwrapped so that they can be used as grammar
+		// nodes, and their rules have their own locations.
+		return p
+	}
+	return pattern.Source(p, root.Start(), trimSpacing(s, root.Start(), root.End()))
+}
+
+// Returns the end of s[start:end] without the trailing spaces and comments
+// that are part of each token.
+func trimSpacing(s string, start, end int) int {
+	for {
+		for end > start && strings.IndexByte(" \t\r\n", s[end-1]) != -1 {
+			end--
+		}
+		line := strings.LastIndexByte(s[start:end], '\n') + 1 + start
+		comment := commentStart(s[line:end])
+		if comment == -1 {
+			return end
+		}
+		end = line + comment
+	}
+}
+
+// Returns the index of the comment in the line, or -1 if there is none.
+func commentStart(line string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '#':
+			return i
+		}
+	}
+	return -1
 }
 
 var special = map[byte]byte{
@@ -138,19 +385,22 @@ var special = map[byte]byte{
 	'-':  '-',
 }
 
-func parseChar(char string) byte {
+// Returns the character that char denotes. Escapes denote bytes, and other
+// characters are UTF-8 encoded code points.
+func parseChar(char string) rune {
 	switch char[0] {
 	case '\\':
 		for k, v := range special {
 			if char[1] == k {
-				return v
+				return rune(v)
 			}
 		}
 
-		i, _ := strconv.ParseInt(string(char[1:]), 8, 8)
-		return byte(i)
+		i, _ := strconv.ParseUint(string(char[1:]), 8, 8)
+		return rune(byte(i))
 	default:
-		return char[0]
+		r, _ := utf8.DecodeRuneInString(char)
+		return r
 	}
 }
 
@@ -163,34 +413,452 @@ func parseId(root *memo.Capture, s string) string {
 	return ident.String()
 }
 
-func compileDef(root *memo.Capture, s string, capg bool, ids map[string]int) (string, pattern.Pattern) {
+// Returns the text of a literal, and whether it is case-insensitive.
+func parseLiteral(root *memo.Capture, s string) (string, bool) {
+	lit := &bytes.Buffer{}
+	fold := false
+	it := root.ChildIterator(0)
+	for c := it(); c != nil; c = it() {
+		if c.Id() == idFOLD {
+			fold = true
+			continue
+		}
+		char := s[c.Start():c.End()]
+		if char[0] == '\\' {
+			lit.WriteByte(byte(parseChar(char)))
+		} else {
+			lit.WriteString(char)
+		}
+	}
+	return lit.String(), fold
+}
+
+// Returns the full name of the rule of an imported module that a qualified
+// name refers to.
+func (cm *compiler) parseQualified(root *memo.Capture, s string) string {
+	var names []string
+	it := root.ChildIterator(0)
+	for c := it(); c != nil; c = it() {
+		names = append(names, parseId(c, s))
+	}
+	return cm.qualify(names, root.Start())
+}
+
+// Returns the full name of the rule that the names separated by dots at pos
+// refer to.
+func (cm *compiler) qualify(names []string, pos int) string {
+	if len(names) > 1 && !cm.aliases[names[0]] {
+		errorf(pos, "unknown module %s", names[0])
+	}
+	return cm.prefix + strings.Join(names, ".")
+}
+
+// Compiles a definition into the rules of the module, and returns the full
+// name of the rule. A definition of a rule of an imported module overrides
+// it, or extends it with alternatives that are tried first.
+func (cm *compiler) compileDef(root *memo.Capture, s string) string {
 	id := root.Child(0)
-	exp := root.Child(1)
-	return parseId(id, s), compile(exp, s, capg, ids)
+	exp := root.Child(root.NumChildren() - 1)
+	if id.Id() == idIdentifier {
+		name := cm.prefix + parseId(id, s)
+		cm.rules[name] = cm.compile(exp, s)
+		return name
+	}
+
+	name := cm.parseQualified(id, s)
+	orig, ok := cm.rules[name]
+	if !ok {
+		errorf(id.Start(), "undefined rule %s", strings.TrimPrefix(name, cm.prefix))
+	}
+	if root.Child(1).Id() == idEXTEND {
+		cm.rules[name] = pattern.Or(cm.compile(exp, s), orig)
+	} else {
+		cm.rules[name] = cm.compile(exp, s)
+	}
+	return name
+}
+
+// A macro is a rule with parameters. It is expanded into a rule for each list
+// of arguments that it is called with.
+type macro struct {
+	params []string
+	body   *memo.Capture
+	// the source of the body and the compiler of the module that defines
+	// the rule.
+	s  string
+	cm *compiler
+	// the rule that this one extends, if any.
+	ext *macro
+}
+
+// A binding is the argument that a parameter is bound to, and the text that
+// identifies the argument in the names of expanded rules.
+type binding struct {
+	patt pattern.Pattern
+	key  string
+}
+
+// maxDepth is the maximum number of nested expansions of rules with
+// parameters, which is reached by rules that call themselves with ever larger
+// arguments.
+const maxDepth = 100
+
+// Adds a definition of a rule with parameters to the rules with parameters of
+// the module.
+func (cm *compiler) compileMacro(root *memo.Capture, s string) {
+	sig := root.Child(0)
+	var names, params []string
+	it := sig.ChildIterator(0)
+	for c := it(); c != nil; c = it() {
+		if c.Id() == idIdentifier {
+			names = append(names, parseId(c, s))
+			continue
+		}
+		pit := c.ChildIterator(0)
+		for p := pit(); p != nil; p = pit() {
+			if p.Id() != idIdentifier {
+				continue
+			}
+			param := parseId(p, s)
+			for _, other := range params {
+				if param == other {
+					errorf(p.Start(), "duplicate parameter %s", param)
+				}
+			}
+			params = append(params, param)
+		}
+	}
+
+	m := &macro{
+		params: params,
+		body:   root.Child(root.NumChildren() - 1),
+		s:      s,
+		cm:     cm,
+	}
+	name := cm.qualify(names, sig.Start())
+	if len(names) > 1 {
+		orig, ok := cm.macros[name]
+		if !ok {
+			errorf(sig.Start(), "undefined rule %s", strings.Join(names, "."))
+		}
+		if len(orig.params) != len(params) {
+			errorf(sig.Start(), "%s takes %d arguments", strings.Join(names, "."), len(orig.params))
+		}
+		if root.Child(1).Id() == idEXTEND {
+			m.ext = orig
+		}
+	}
+	cm.macros[name] = m
+}
+
+// Compiles a call of a rule with parameters into a reference to the rule
+// that it expands to.
+func (cm *compiler) compileCall(root *memo.Capture, s string) pattern.Pattern {
+	var names, keys []string
+	var args []pattern.Pattern
+	it := root.ChildIterator(0)
+	for c := it(); c != nil; c = it() {
+		switch c.Id() {
+		case idIdentifier:
+			names = append(names, parseId(c, s))
+		case idExpression:
+			args = append(args, cm.compile(c, s))
+			keys = append(keys, strings.TrimSpace(cm.key(c, s)))
+		}
+	}
+	name := cm.qualify(names, root.Start())
+	m, ok := cm.macros[name]
+	if !ok {
+		errorf(root.Start(), "%s is not a rule with parameters", strings.Join(names, "."))
+	}
+	if len(args) != len(m.params) {
+		errorf(root.Start(), "%s takes %d arguments, got %d", strings.Join(names, "."), len(m.params), len(args))
+	}
+
+	rule := name + "(" + strings.Join(keys, ", ") + ")"
+	if !cm.expanded[rule] {
+		if *cm.depth >= maxDepth {
+			errorf(root.Start(), "too many nested expansions of %s", strings.Join(names, "."))
+		}
+		cm.expanded[rule] = true
+		*cm.depth++
+		cm.rules[rule] = cm.expand(m, args, keys)
+		*cm.depth--
+	}
+	return pattern.NonTerm(rule)
+}
+
+// Returns the body of the rule with parameters m with its parameters bound to
+// args. The body is compiled in the module that defines it, and its rules
+// are added to the rules of cm.
+func (cm *compiler) expand(m *macro, args []pattern.Pattern, keys []string) pattern.Pattern {
+	if m.cm.file != cm.file {
+		defer func() {
+			if r := recover(); r != nil {
+				if cerr, ok := r.(compileError); ok {
+					panic(compileError{&ImportError{Path: m.cm.file, Err: cerr.err}})
+				}
+				panic(r)
+			}
+		}()
+	}
+
+	mc := *m.cm
+	mc.rules = cm.rules
+	mc.qualified = make(map[string]int)
+	mc.env = make(map[string]binding)
+	for i, param := range m.params {
+		mc.env[param] = binding{args[i], keys[i]}
+	}
+	p := mc.compile(m.body, m.s)
+	for name, pos := range mc.qualified {
+		if _, ok := mc.rules[name]; !ok {
+			errorf(pos, "undefined rule %s", strings.TrimPrefix(name, mc.prefix))
+		}
+	}
+	if m.ext != nil {
+		p = pattern.Or(p, cm.expand(m.ext, args, keys))
+	}
+	return p
+}
+
+// Returns the text of root with the names of rules replaced by their full
+// names and parameters by the keys of their arguments. Two expressions with
+// the same key compile to the same pattern.
+func (cm *compiler) key(root *memo.Capture, s string) string {
+	var b strings.Builder
+	pos := root.Start()
+	it := root.ChildIterator(0)
+	c := it()
+	switch root.Id() {
+	case idIdentifier:
+		name := parseId(root, s)
+		if bind, ok := cm.env[name]; ok {
+			b.WriteString(bind.key)
+		} else {
+			b.WriteString(cm.prefix + name)
+		}
+		pos += len(name)
+		c = nil
+	case idQualified, idCall:
+		// the leading names are the name of a rule.
+		var names []string
+		for ; c != nil && c.Id() == idIdentifier; c = it() {
+			names = append(names, parseId(c, s))
+			pos = c.End()
+		}
+		b.WriteString(cm.prefix + strings.Join(names, "."))
+	}
+	for ; c != nil; c = it() {
+		b.WriteString(s[pos:c.Start()])
+		b.WriteString(cm.key(c, s))
+		pos = c.End()
+	}
+	b.WriteString(s[pos:root.End()])
+	return b.String()
+}
+
+// Compiles the module that an import loads, and adds its rules to the rules
+// of the module that imports it. The path of the module is relative to the
+// directory of the importing module.
+func (cm *compiler) compileImport(root *memo.Capture, s string) {
+	lit, _ := parseLiteral(root.Child(0), s)
+	alias := parseId(root.Child(1), s)
+	if cm.fsys == nil {
+		errorf(root.Start(), "cannot import %q without a file system", lit)
+	}
+	if cm.aliases[alias] {
+		errorf(root.Child(1).Start(), "duplicate import name %s", alias)
+	}
+	file := path.Join(path.Dir(cm.file), lit)
+	if cm.loading[file] {
+		errorf(root.Start(), "import cycle through %s", file)
+	}
+	src, err := fs.ReadFile(cm.fsys, file)
+	if err != nil {
+		panic(compileError{err})
+	}
+
+	sub := newCompiler(cm.fsys, file)
+	sub.loading = cm.loading
+	sub.expanded = cm.expanded
+	sub.depth = cm.depth
+	sub.prefix = cm.prefix + alias + "."
+	sub.refs = cm.refs
+	sub.syms = cm.syms
+	cm.loading[file] = true
+	_, err = sub.compileSource(string(src))
+	delete(cm.loading, file)
+	if err != nil {
+		panic(compileError{&ImportError{Path: file, Err: err}})
+	}
+	if sub.rules == nil {
+		errorf(root.Start(), "module %s is not a grammar", file)
+	}
+
+	cm.aliases[alias] = true
+	for name, rule := range sub.rules {
+		cm.rules[name] = rule
+	}
+	for name, m := range sub.macros {
+		cm.macros[name] = m
+	}
+}
+
+// Returns the id of the back-reference symbol name.
+func (cm *compiler) symbol(name string) int {
+	name = cm.prefix + name
+	id, ok := cm.syms[name]
+	if !ok {
+		id = len(cm.syms)
+		cm.syms[name] = id
+		cm.refs.Names[id] = name
+	}
+	return id
 }
 
-func compileSet(root *memo.Capture, s string) charset.Set {
+func compileLabels(root *memo.Capture, s string) []string {
+	var labels []string
+	it := root.ChildIterator(0)
+	for c := it(); c != nil; c = it() {
+		if c.Id() == idIdentifier {
+			labels = append(labels, parseId(c, s))
+		}
+	}
+	return labels
+}
+
+func compileRepeat(patt pattern.Pattern, root *memo.Capture, s string) pattern.Pattern {
+	min := parseNumber(root.Child(0), s)
+	switch root.NumChildren() {
+	case 2:
+		return pattern.RepeatMin(patt, min)
+	case 3:
+		return pattern.RepeatRange(patt, min, parseNumber(root.Child(2), s))
+	}
+	return pattern.Repeat(patt, min)
+}
+
+func parseNumber(root *memo.Capture, s string) int {
+	n, err := strconv.Atoi(s[root.Start():root.End()])
+	if err != nil {
+		// the number only contains digits, so it must be out of range.
+		return math.MaxInt32
+	}
+	return n
+}
+
+// Returns the range of characters of a class, and whether it contains a
+// non-ASCII character.
+func compileRange(root *memo.Capture, s string) (charset.RuneRange, bool) {
+	var r charset.RuneRange
 	switch root.NumChildren() {
 	case 1:
 		c := root.Child(0)
-		return charset.New([]byte{parseChar(s[c.Start():c.End()])})
+		r.Lo = parseChar(s[c.Start():c.End()])
+		r.Hi = r.Lo
 	case 2:
 		c1, c2 := root.Child(0), root.Child(1)
-		return charset.Range(parseChar(s[c1.Start():c1.End()]), parseChar(s[c2.Start():c2.End()]))
+		r.Lo = parseChar(s[c1.Start():c1.End()])
+		r.Hi = parseChar(s[c2.Start():c2.End()])
 	}
-	return charset.Set{}
+	return r, s[root.Start()] >= utf8.RuneSelf || s[root.End()-1] >= utf8.RuneSelf
 }
 
-func Compile(s string) (pattern.Pattern, error) {
-	match, n, ast, errs := parser.Exec(strings.NewReader(s), memo.NoneTable{})
+// Returns the name of the Unicode class \p{name} or \P{name}, and whether
+// it is negated.
+func parseProperty(root *memo.Capture, s string) (string, bool) {
+	return s[root.Start()+3 : root.End()-1], s[root.Start()+1] == 'P'
+}
+
+// Returns the set of code points in a Unicode class, which is either the
+// class Any, a general category such as L or Lu, or a script such as Greek.
+func compileProperty(root *memo.Capture, s string) charset.RuneSet {
+	name, negate := parseProperty(root, s)
+	var set charset.RuneSet
+	if name == "Any" {
+		set = charset.Runes(0, unicode.MaxRune)
+	} else if t, ok := unicode.Categories[name]; ok {
+		set = charset.FromTable(t)
+	} else if t, ok := unicode.Scripts[name]; ok {
+		set = charset.FromTable(t)
+	} else {
+		errorf(root.Start(), "unknown Unicode class %s", name)
+	}
+	if negate {
+		set = set.Complement()
+	}
+	return set
+}
+
+// A compileError is raised with panic to stop compiling a pattern that is
+// syntactically valid but cannot be compiled.
+type compileError struct {
+	err error
+}
+
+// errorf stops the compilation with an error at pos in the pattern.
+func errorf(pos int, format string, args ...interface{}) {
+	panic(compileError{vm.ParseError{
+		Message: fmt.Sprintf(format, args...),
+		Pos:     pos,
+	}})
+}
+
+// An ImportError is an error in a module imported by a pattern.
+type ImportError struct {
+	// Path is the path of the module in the file system it was loaded from.
+	Path string
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// compileSource parses and compiles s, and returns the error raised by
+// errorf if there is one.
+func (cm *compiler) compileSource(s string) (p pattern.Pattern, err error) {
+	_, ast, errs, err := parser.Parse(strings.NewReader(s), memo.NoneTable{})
 	if len(errs) != 0 {
 		return nil, errs[0]
 	}
-	if !match {
-		return nil, fmt.Errorf("Invalid PEG: failed at %d", n)
+	if err != nil {
+		return nil, err
 	}
 
-	return compile(ast.Child(0), s, false, nil), nil
+	defer func() {
+		if r := recover(); r != nil {
+			cerr, ok := r.(compileError)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, cerr.err
+		}
+	}()
+	p = cm.compile(ast.Child(0), s)
+	undef := ""
+	for name, pos := range cm.used {
+		if !cm.defined[name] && (undef == "" || pos < cm.used[undef]) {
+			undef = name
+		}
+	}
+	if undef != "" {
+		errorf(cm.used[undef], "undefined symbol %s", undef)
+	}
+	if _, ok := p.(*pattern.GrammarNode); !ok {
+		cm.rules = nil
+	}
+	return p, nil
+}
+
+// Compile compiles the pattern s, which may be an expression or a grammar.
+func Compile(s string) (pattern.Pattern, error) {
+	return newCompiler(nil, "").compileSource(s)
 }
 
 func MustCompile(s string) pattern.Pattern {
@@ -201,16 +869,14 @@ func MustCompile(s string) pattern.Pattern {
 	return p
 }
 
+// CompileCap compiles s like Compile, but captures every rule of a grammar
+// with a capture named after it. The id of each rule is stored in ids if it
+// is not nil.
 func CompileCap(s string, ids map[string]int) (pattern.Pattern, error) {
-	match, n, ast, errs := parser.Exec(strings.NewReader(s), memo.NoneTable{})
-	if len(errs) != 0 {
-		return nil, errs[0]
-	}
-	if !match {
-		return nil, fmt.Errorf("Invalid PEG: failed at %d", n)
-	}
-
-	return compile(ast.Child(0), s, true, ids), nil
+	cm := newCompiler(nil, "")
+	cm.capg = true
+	cm.ids = ids
+	return cm.compileSource(s)
 }
 
 func MustCompileCap(s string, ids map[string]int) pattern.Pattern {
@@ -220,3 +886,23 @@ func MustCompileCap(s string, ids map[string]int) pattern.Pattern {
 	}
 	return p
 }
+
+// CompileFS compiles s like Compile, and loads the modules that it imports
+// from fsys. The paths of the modules that s imports are relative to the
+// root of fsys, and those of the modules that they import are relative to
+// their own directories. The rules of an imported module are named after the
+// name it is imported as, such as c.Identifier, and are part of the same
+// grammar as the rules of s. Source locations are only recorded for the
+// patterns of s.
+func CompileFS(s string, fsys fs.FS) (pattern.Pattern, error) {
+	return newCompiler(fsys, "").compileSource(s)
+}
+
+// CompileCapFS compiles s like CompileCap, and loads the modules that it
+// imports from fsys like CompileFS.
+func CompileCapFS(s string, fsys fs.FS, ids map[string]int) (pattern.Pattern, error) {
+	cm := newCompiler(fsys, "")
+	cm.capg = true
+	cm.ids = ids
+	return cm.compileSource(s)
+}
@@ -28,6 +28,26 @@ func TestReExtra(t *testing.T) {
 	check(p, tests, t)
 }
 
+func TestReRepeat(t *testing.T) {
+	p := re.MustCompile("[0-9]{3} '-' [0-9]{2,} '-' [a-z]{1,3} !.")
+	tests := []PatternTest{
+		{"123-45-abc", 10},
+		{"123-4567-a", 10},
+		{"12-45-abc", -1},
+		{"123-4-abc", -1},
+		{"123-45-abcd", -1},
+	}
+	check(p, tests, t)
+
+	p = re.MustCompile("A <- ('x' / 'y'){ 2 , 4 } 'z'")
+	tests = []PatternTest{
+		{"xyz", 3},
+		{"xyxyz", 5},
+		{"xz", -1},
+	}
+	check(p, tests, t)
+}
+
 func TestJson(t *testing.T) {
 	peg, err := ioutil.ReadFile("grammars/json.peg")
 	if err != nil {
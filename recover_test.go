@@ -37,7 +37,7 @@ func TestRecover(t *testing.T) {
 	peg := MustCompile(p)
 	code := vm.Encode(peg)
 	in := strings.NewReader("one two three,")
-	_, _, _, errs := code.Exec(in, memo.NoneTable{})
+	_, _, _, errs, _ := code.Exec(in, memo.NoneTable{})
 
 	if len(errs) != 3 {
 		t.Error("Incorrect list of errors:", errs)
@@ -18,61 +18,79 @@ func uniq() string {
 	return "a" + strconv.Itoa(num)
 }
 
-func star(r *syntax.Regexp, k p.Pattern) p.Pattern {
+// A converter converts regexps into patterns.
+type converter struct {
+	// groups is true if the groups of the regexp are marked with captures.
+	groups bool
+}
+
+func (c *converter) star(r *syntax.Regexp, k p.Pattern) p.Pattern {
 	nterm := uniq()
 	nonterms := make(map[string]p.Pattern)
-	nonterms[nterm] = p.Or(pi(r, p.NonTerm(nterm)), k)
+	nonterms[nterm] = p.Or(c.pi(r, p.NonTerm(nterm)), k)
 	return p.Grammar(nterm, nonterms)
 }
 
 // continuation-based conversion
-func pi(e *syntax.Regexp, k p.Pattern) p.Pattern {
+func (c *converter) pi(e *syntax.Regexp, k p.Pattern) p.Pattern {
 	switch e.Op {
 	case syntax.OpEmptyMatch:
 		return k
 	case syntax.OpLiteral:
-		return p.Concat(p.Literal(string(e.Rune)), k)
+		if e.Flags&syntax.FoldCase == 0 {
+			return p.Concat(p.Literal(string(e.Rune)), k)
+		}
+		// unlike pattern.LiteralFold, regexps fold ASCII letters with
+		// non-ASCII characters such as the Kelvin sign.
+		patts := make([]p.Pattern, 0, len(e.Rune)+1)
+		for _, r := range e.Rune {
+			patts = append(patts, p.RuneSet(charset.Runes(r, r).Fold()))
+		}
+		return p.Concat(append(patts, k)...)
 	case syntax.OpCharClass:
-		lits := make([]p.Pattern, 0, len(e.Rune))
+		ranges := make([]charset.RuneRange, 0, len(e.Rune)/2)
 		for i := 0; i < len(e.Rune); i += 2 {
-			start := e.Rune[i]
-			end := e.Rune[i+1]
-			var patt p.Pattern
-			if start < 256 && end < 256 {
-				patt = p.Set(charset.Range(byte(start), byte(end)))
-				lits = append(lits, p.Concat(patt, k))
-			} else {
-				for ; start <= end; start++ {
-					lits = append(lits, p.Concat(p.Literal(string(start)), k))
-				}
-			}
+			ranges = append(ranges, charset.RuneRange{Lo: e.Rune[i], Hi: e.Rune[i+1]})
 		}
-		return p.Or(lits...)
+		return p.Concat(p.RuneSet(charset.NewRuneSet(ranges...)), k)
 	case syntax.OpAnyChar:
-		// TODO: unicode
-		return p.Concat(p.Any(1), k)
+		return p.Concat(p.AnyRune(), k)
 	case syntax.OpAnyCharNotNL:
-		return p.Concat(p.Set(charset.New([]byte{'\n'}).Complement()), k)
+		return p.Concat(p.RuneSet(charset.Runes('\n', '\n').Complement()), k)
 	case syntax.OpConcat:
 		patt := k
 		for i := len(e.Sub) - 1; i >= 0; i-- {
-			patt = pi(e.Sub[i], patt)
+			patt = c.pi(e.Sub[i], patt)
 		}
 		return patt
 	case syntax.OpAlternate:
 		alts := make([]p.Pattern, 0, len(e.Sub))
 		for _, s := range e.Sub {
-			alts = append(alts, pi(s, k))
+			alts = append(alts, c.pi(s, k))
 		}
 		return p.Or(alts...)
 	case syntax.OpCapture:
-		return pi(e.Sub[0], k)
+		if !c.groups {
+			return c.pi(e.Sub[0], k)
+		}
+		// the continuation is matched as part of the group, so the group
+		// cannot be captured by a single capture. Its bounds are marked
+		// with empty captures instead.
+		end := p.Concat(p.Cap(&p.EmptyNode{}, GroupEnd(e.Cap)), k)
+		return p.Concat(p.Cap(&p.EmptyNode{}, GroupStart(e.Cap)), c.pi(e.Sub[0], end))
 	case syntax.OpStar:
-		return star(e.Sub[0], k)
+		return c.star(e.Sub[0], k)
 	case syntax.OpPlus:
-		return pi(e.Sub[0], star(e.Sub[0], k))
+		return c.pi(e.Sub[0], c.star(e.Sub[0], k))
 	case syntax.OpQuest:
-		return p.Or(pi(e.Sub[0], k), k)
+		return p.Or(c.pi(e.Sub[0], k), k)
+	case syntax.OpRepeat:
+		// Regexp repetitions backtrack into the repeated expression, so
+		// they cannot use the possessive bounded repetition from the
+		// pattern package. Instead the repetition is expanded into
+		// concatenations and optionals, which are converted with the
+		// continuation.
+		return c.pi(e.Simplify(), k)
 	case syntax.OpBeginLine:
 		return p.Concat(p.EmptyOp(syntax.EmptyBeginLine), k)
 	case syntax.OpEndLine:
@@ -89,19 +107,71 @@ func pi(e *syntax.Regexp, k p.Pattern) p.Pattern {
 	panic(fmt.Sprintf("unimplemented %s", e.Op))
 }
 
-func convert(r *syntax.Regexp) p.Pattern {
-	return pi(r, &p.EmptyNode{})
+func (c *converter) convert(r *syntax.Regexp) p.Pattern {
+	return c.pi(r, &p.EmptyNode{})
 }
 
+// FromRegexp converts the regexp s into a pattern that searches for the
+// first match of s and captures it with ID 0.
 func FromRegexp(s string, flags syntax.Flags) (p.Pattern, error) {
+	patt, err := Convert(s, flags)
+	if err != nil {
+		return nil, err
+	}
+	return p.Search(p.Cap(patt, 0)), nil
+}
+
+// Convert converts the regexp s into a pattern that matches s at the
+// current position, without searching for it or capturing the match.
+// Groups in s are not captured.
+func Convert(s string, flags syntax.Flags) (p.Pattern, error) {
+	re, err := parse(s, flags)
+	if err != nil {
+		return nil, err
+	}
+	c := &converter{}
+	return c.convert(re), nil
+}
+
+// ConvertGroups is like Convert, but it also marks the groups of s, and
+// returns their names like regexp.Regexp.SubexpNames. The start and end of
+// group i are marked by empty captures with the IDs GroupStart(i) and
+// GroupEnd(i). Since a group may be matched more than once, the bounds of
+// the group in a match are given by the last pair of marks.
+func ConvertGroups(s string, flags syntax.Flags) (p.Pattern, []string, error) {
+	re, err := parse(s, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, re.MaxCap()+1)
+	for i, name := range re.CapNames() {
+		names[i] = name
+	}
+	c := &converter{groups: true}
+	return c.convert(re), names, nil
+}
+
+// GroupStart returns the ID of the captures that mark the start of group i
+// in the patterns returned by ConvertGroups.
+func GroupStart(i int) int {
+	return 2 * i
+}
+
+// GroupEnd returns the ID of the captures that mark the end of group i in
+// the patterns returned by ConvertGroups.
+func GroupEnd(i int) int {
+	return 2*i + 1
+}
+
+func parse(s string, flags syntax.Flags) (*syntax.Regexp, error) {
 	re, err := syntax.Parse(s, flags)
 	if err != nil {
 		return nil, err
 	}
 	if !verify(re) {
-		return nil, fmt.Errorf("invalid regexp (repeat not supported)")
+		return nil, fmt.Errorf("invalid regexp (unsupported operator)")
 	}
-	return p.Search(p.Cap(convert(re), 0)), nil
+	return re, nil
 }
 
 func verify(e *syntax.Regexp) bool {
@@ -117,7 +187,8 @@ func verify(e *syntax.Regexp) bool {
 			yes = yes && verify(s)
 		}
 		return yes
-	case syntax.OpCapture, syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+	case syntax.OpCapture, syntax.OpStar, syntax.OpPlus, syntax.OpQuest,
+		syntax.OpRepeat:
 		return verify(e.Sub[0])
 	}
 	return false
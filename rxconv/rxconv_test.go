@@ -21,7 +21,7 @@ func check(p Pattern, tests []PatternTest, t *testing.T) {
 	for _, tt := range tests {
 		name := tt.in[:min(10, len(tt.in))]
 		t.Run(name, func(t *testing.T) {
-			match, off, _, _ := code.Exec(strings.NewReader(tt.in), memo.NoneTable{})
+			match, off, _, _, _ := code.Exec(strings.NewReader(tt.in), memo.NoneTable{})
 			if tt.match == -1 && match || tt.match != -1 && !match || tt.match != -1 && tt.match != off {
 				t.Errorf("%s: got: (%t, %d), but expected (%d)\n", tt.in, match, off, tt.match)
 			}
@@ -86,6 +86,31 @@ func TestCharClass(t *testing.T) {
 	check(peg, tests, t)
 }
 
+func TestRepeat(t *testing.T) {
+	peg, err := rxconv.FromRegexp("a{2,3}ab{2}", syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []PatternTest{
+		{"aaabb", 5},
+		{"aaaabb", 6},
+		{"aabb", -1},
+		{"aaab", -1},
+	}
+	check(peg, tests, t)
+
+	peg, err = rxconv.FromRegexp("x(ab){2,}c", syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests = []PatternTest{
+		{"xabc", -1},
+		{"xababc", 6},
+		{"xabababc", 8},
+	}
+	check(peg, tests, t)
+}
+
 func TestEmptyOp(t *testing.T) {
 	peg, err := rxconv.FromRegexp("^foo", syntax.Perl)
 	if err != nil {
@@ -114,3 +139,18 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+func TestUnicode(t *testing.T) {
+	peg, err := rxconv.FromRegexp(`^.[à-ÿ]\pL$`, syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []PatternTest{
+		{"日éb", 6},
+		{"aé日", 6},
+		{"aeb", -1},
+		{"\nét", -1},
+	}
+	check(peg, tests, t)
+}
@@ -0,0 +1,59 @@
+package gpeg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestSource(t *testing.T) {
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(traceGrammar)))
+
+	// every instruction of a rule maps back to part of the rule's definition
+	found := make(map[string]bool)
+	for ip := 0; ip < code.Size(); ip++ {
+		info, ok := code.Source(ip)
+		if !ok || info.Start == -1 {
+			continue
+		}
+		text := traceGrammar[info.Start:info.End]
+		def := traceGrammar[strings.Index(traceGrammar, info.Rule+" <-"):]
+		def = def[:strings.Index(def, "\n")]
+		if !strings.Contains(def, text) {
+			t.Errorf("%d: source %q is not part of rule %s", ip, text, info.Rule)
+		}
+		if info.Node == nil {
+			t.Errorf("%d: no pattern node for %q", ip, text)
+		}
+		found[text] = true
+	}
+	for _, text := range []string{"'+'", "[0-9]+", "')'"} {
+		if !found[text] {
+			t.Errorf("no instruction maps to %s", text)
+		}
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	grammar := "S <- ('#' / [#]) # comment\n\t# another\n\t'x'+ # done\n"
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(grammar)))
+
+	found := make(map[string]bool)
+	for ip := 0; ip < code.Size(); ip++ {
+		if info, ok := code.Source(ip); ok && info.Start != -1 {
+			text := grammar[info.Start:info.End]
+			if strings.Contains(text, "comment") || strings.Contains(text, "done") {
+				t.Errorf("%d: source %q includes a comment", ip, text)
+			}
+			found[text] = true
+		}
+	}
+	for _, text := range []string{"'#' / [#]", "'x'+"} {
+		if !found[text] {
+			t.Errorf("no instruction maps to %s", text)
+		}
+	}
+}
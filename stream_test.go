@@ -0,0 +1,108 @@
+package gpeg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+// logReader generates n lines of a log without storing them.
+type logReader struct {
+	n, line int
+	buf     []byte
+}
+
+func (r *logReader) Read(b []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.line == r.n {
+			return 0, io.EOF
+		}
+		r.buf = []byte(fmt.Sprintf("%d INFO request %d took %dms\n", 1600000000+r.line, r.line, r.line%1000))
+		r.line++
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Log   <- Line* !.
+// Line  <- {Time ' ' {Level} ' ' (!'\n' .)* '\n'}
+// Time  <- [0-9]+
+// Level <- 'INFO' / 'WARN' / 'ERROR'
+var logGrammar = pattern.Grammar("Log", map[string]pattern.Pattern{
+	"Log": re.MustCompile("Line* !."),
+	"Line": pattern.Cap(pattern.Concat(
+		re.MustCompile("Time ' '"),
+		pattern.Cap(pattern.NonTerm("Level"), 2),
+		re.MustCompile("' ' (!'\\n' .)* '\\n'"),
+	), 1),
+	"Time":  re.MustCompile("[0-9]+"),
+	"Level": re.MustCompile("'INFO' / 'WARN' / 'ERROR'"),
+})
+
+func TestExecStream(t *testing.T) {
+	code := vm.Encode(pattern.MustCompile(logGrammar))
+
+	// the captures are the same as when parsing the whole subject
+	var all strings.Builder
+	io.Copy(&all, &logReader{n: 100})
+	_, _, capt, _, _ := code.Exec(strings.NewReader(all.String()), memo.NoneTable{})
+	var want, got []string
+	it := capt.ChildIterator(0)
+	for c := it(); c != nil; c = it() {
+		want = append(want, fmt.Sprint(c.Start(), c.End()))
+	}
+
+	s := input.NewStream(&logReader{n: 100})
+	match, n, _, err := code.ExecStream(s, func(c *memo.Capture) error {
+		got = append(got, fmt.Sprint(c.Start(), c.End()))
+		return nil
+	})
+	if !match || n != all.Len() || err != nil {
+		t.Fatalf("got (%t, %d, %v), expected (true, %d, nil)", match, n, err, all.Len())
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got captures %v, expected %v", got, want)
+	}
+
+	// the buffered input stays small for a long subject, and the text of
+	// the captures is available when they are delivered
+	s = input.NewStream(&logReader{n: 200000})
+	max, levels := 0, 0
+	match, _, _, err = code.ExecStream(s, func(c *memo.Capture) error {
+		if s.Buffered() > max {
+			max = s.Buffered()
+		}
+		level := c.ChildById(2)
+		if string(input.Slice(s, level.Start(), level.End())) == "INFO" {
+			levels++
+		}
+		return nil
+	})
+	if !match || err != nil {
+		t.Fatalf("got (%t, %v), expected (true, nil)", match, err)
+	}
+	if levels != 200000 {
+		t.Errorf("got %d levels, expected %d", levels, 200000)
+	}
+	if max > 64*1024 {
+		t.Errorf("buffered %d bytes", max)
+	}
+
+	// an error from the capture function stops the execution
+	stop := errors.New("stop")
+	_, _, _, err = code.ExecStream(input.NewStream(&logReader{n: 100000}), func(c *memo.Capture) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("got error %v, expected %v", err, stop)
+	}
+}
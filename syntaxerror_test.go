@@ -0,0 +1,66 @@
+package gpeg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestSyntaxError(t *testing.T) {
+	p := re.MustCompile(`
+		Top    <- Expr !.
+		Expr   <- Term (('+' / '-') Term)*
+		Term   <- S (Number / '(' Expr ')') S
+		Number <- [0-9]+
+		S      <- [ \n]*
+	`)
+	code := vm.Encode(pattern.MustCompile(p))
+
+	tests := []struct {
+		in   string
+		line int
+		col  int
+		msg  string
+	}{
+		{"1+(2", 1, 5, "expected {'+','-'} or ')' at 1:5"},
+		{"1+", 1, 3, "expected Term at 1:3"},
+		{"1 +\n2 +\n*", 3, 1, "expected {'0'..'9'} or '(' at 3:1"},
+		{"1 2", 1, 3, "expected {'+','-'} at 1:3"},
+		{"", 1, 1, "expected Top at 1:1"},
+	}
+
+	for _, tt := range tests {
+		_, _, _, err := code.Parse(strings.NewReader(tt.in), memo.NoneTable{})
+		var serr *vm.SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: expected syntax error, got %v", tt.in, err)
+			continue
+		}
+		if serr.Line != tt.line || serr.Col != tt.col {
+			t.Errorf("%q: got position %d:%d, expected %d:%d", tt.in, serr.Line, serr.Col, tt.line, tt.col)
+		}
+		if serr.Error() != tt.msg {
+			t.Errorf("%q: got %q, expected %q", tt.in, serr.Error(), tt.msg)
+		}
+	}
+
+	if _, _, _, err := code.Parse(strings.NewReader("1 + (2-3)\n"), memo.NoneTable{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReSyntaxError(t *testing.T) {
+	_, err := re.Compile("A <- 'a'\nB <- [a-z")
+	var serr *vm.SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected syntax error, got %v", err)
+	}
+	if serr.Line != 2 || serr.Col != 10 {
+		t.Errorf("got position %d:%d, expected 2:10", serr.Line, serr.Col)
+	}
+}
@@ -0,0 +1,111 @@
+package gpeg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gpeg/memo"
+	"github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+var traceGrammar = `
+	Expr <- Term ('+' Term)*
+	Term <- [0-9]+ / '(' Expr ')'
+`
+
+func TestTrace(t *testing.T) {
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(traceGrammar)))
+
+	var events []string
+	steps := 0
+	match, _, _, _, err := code.ExecContext(context.Background(), strings.NewReader("1+(2"), memo.NoneTable{}, vm.ExecOptions{
+		Trace: func(ev vm.Event) {
+			if ev.Kind == vm.EventStep {
+				steps++
+				if ev.Op == "" {
+					t.Errorf("step without an instruction at %d", ev.Ip)
+				}
+				return
+			}
+			events = append(events, ev.String())
+		},
+	})
+	if err != nil || !match {
+		t.Fatalf("got (%t, %v), expected a match", match, err)
+	}
+	expect := []string{
+		"0: enter Expr",
+		"0: enter Term",
+		"1: exit Term",
+		"2: enter Term",
+		"3: enter Expr",
+		"3: enter Term",
+		"4: exit Term",
+		"4: fail",
+		"4: exit Expr",
+		"4: fail",
+		"4: exit Term (fail)",
+		"1: exit Expr",
+	}
+	got := events[:0]
+	for _, ev := range events {
+		// drop the instruction details from failures
+		if i := strings.Index(ev, ": fail"); i != -1 {
+			ev = ev[:i+len(": fail")]
+		}
+		got = append(got, ev)
+	}
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("got events:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expect, "\n"))
+	}
+	if steps == 0 {
+		t.Error("no steps were traced")
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	code := vm.Encode(pattern.MustCompile(re.MustCompile(traceGrammar)))
+	in := "1+(2+3)"
+
+	d := vm.NewDebugger(&code, strings.NewReader(in), memo.NoneTable{})
+	d.BreakRule("Expr")
+	if ev, ok := d.Continue(); !ok || ev.Kind != vm.EventEnter || ev.Pos != 0 {
+		t.Fatalf("incorrect first breakpoint: %v", ev)
+	}
+	if ev, ok := d.Continue(); !ok || ev.Rule != "Expr" || ev.Pos != 3 {
+		t.Fatalf("incorrect second breakpoint: %v", ev)
+	}
+	if bt := strings.Join(d.Backtrace(), " "); bt != "Expr Term Expr" {
+		t.Errorf("got backtrace %q", bt)
+	}
+	ev, ok := d.Step()
+	if !ok || ev.Kind != vm.EventStep || ev.Rule != "Expr" {
+		t.Errorf("incorrect step: %v", ev)
+	}
+
+	d.BreakPos(6)
+	if ev, ok := d.Continue(); !ok || ev.Pos != 6 {
+		t.Errorf("incorrect position breakpoint: %v", ev)
+	}
+	if _, ok := d.Continue(); ok {
+		t.Error("expected the program to finish")
+	}
+	match, n, _, _, err := d.Result()
+	if !match || n != len(in) || err != nil {
+		t.Errorf("got result (%t, %d, %v)", match, n, err)
+	}
+
+	d = vm.NewDebugger(&code, strings.NewReader("1+x"), memo.NoneTable{})
+	d.BreakFail()
+	if ev, ok := d.Continue(); !ok || ev.Kind != vm.EventFail || ev.Pos != 2 || ev.Rule != "Term" {
+		t.Errorf("incorrect failure breakpoint: %v", ev)
+	}
+	d.Stop()
+	if _, _, _, _, err := d.Result(); !errors.Is(err, vm.ErrStopped) {
+		t.Errorf("got error %v, expected the debugger to stop", err)
+	}
+}
@@ -0,0 +1,86 @@
+package gpeg
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/memo"
+	. "github.com/zyedidia/gpeg/pattern"
+	"github.com/zyedidia/gpeg/re"
+	"github.com/zyedidia/gpeg/vm"
+)
+
+func TestAnyRune(t *testing.T) {
+	p := Concat(AnyRune(), AnyRune(), Not(Any(1)))
+	tests := []PatternTest{
+		{"ab", 2},
+		{"aé", 3},
+		{"日本", 6},
+		{"😀x", 5},
+		// invalid encodings are matched one byte at a time.
+		{"\xff\xfe", 2},
+		{"\xe6\x97", 2},
+		{"日", -1},
+		{"", -1},
+	}
+	check(p, tests, t)
+}
+
+func TestRuneSet(t *testing.T) {
+	greek := Plus(RuneSet(charset.FromTable(unicode.Greek)))
+	tests := []PatternTest{
+		{"αβγ abc", 6},
+		{"abc", -1},
+		{"ωx", 2},
+	}
+	check(greek, tests, t)
+
+	// sets of ASCII code points are byte sets.
+	if _, ok := RuneSet(charset.Runes('a', 'z')).(*ClassNode); !ok {
+		t.Error("ASCII rune set is not a byte set")
+	}
+}
+
+func TestReUnicode(t *testing.T) {
+	tests := []struct {
+		patt  string
+		tests []PatternTest
+	}{
+		{`\p{L}+`, []PatternTest{{"héllo wörld", 6}, {"日本語!", 9}, {"123", -1}}},
+		{`\P{L}+`, []PatternTest{{"12 ab", 3}, {"é", -1}}},
+		{`[\p{Greek}\p{Nd}]+`, []PatternTest{{"α1β2c", 6}, {"c", -1}}},
+		{`[à-ÿ]+`, []PatternTest{{"éèx", 4}, {"e", -1}}},
+		{`[^é]`, []PatternTest{{"è", 2}, {"é", -1}, {"a", 1}}},
+		{`'é' [é]`, []PatternTest{{"éé", 4}, {"ée", -1}}},
+		{`\p{Any} 'x'`, []PatternTest{{"日x", 4}, {"\xffx", 2}}},
+		// classes of ASCII characters and escapes still match bytes.
+		{`[^a]`, []PatternTest{{"é", 1}}},
+		{`[\200-\277]`, []PatternTest{{"\x80", 1}, {"é", -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patt, func(t *testing.T) {
+			check(re.MustCompile(tt.patt), tt.tests, t)
+		})
+	}
+}
+
+func TestReUnknownClass(t *testing.T) {
+	_, err := re.Compile(`'a' \p{Klingon}`)
+	if err == nil || !strings.Contains(err.Error(), "unknown Unicode class Klingon") {
+		t.Errorf("got error %v", err)
+	}
+	if _, err := re.Compile(`[a\p{Lx}]`); err == nil {
+		t.Error("expected an error for an unknown class")
+	}
+}
+
+// Rune sets report what was expected when they fail.
+func TestRuneSetExpected(t *testing.T) {
+	code := vm.Encode(MustCompile(Concat(Literal("a"), RuneSet(charset.Runes('α', 'ω')))))
+	_, _, _, err := code.Parse(strings.NewReader("ab"), memo.NoneTable{})
+	if err == nil || err.Error() != `expected {'\u03b1'..'\u03c9'} at 1:2` {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/isa"
+	"github.com/zyedidia/gpeg/memo"
+)
+
+// Symbols defined by back-reference checks are stored as symbol captures in
+// the capture lists of the stack, so that they are discarded by
+// backtracking like other captures and memoized with the results that
+// define them.
+
+func isBackRef(c isa.Checker) bool {
+	switch c.(type) {
+	case isa.BackReference, *isa.BackReference:
+		return true
+	}
+	return false
+}
+
+// captureFuncs returns the functions that build the capture nodes and the
+// dummy captures of the program. The children of the captures are only
+// examined for symbol definitions if the program has back-references.
+func (vm *Code) captureFuncs() (func(id int, start, length int, children []*memo.Capture) *memo.Capture, func(start, length int, children []*memo.Capture) *memo.Capture) {
+	if vm.data.Symbols {
+		return memo.NewCaptureNodeSymbols, memo.NewCaptureDummySymbols
+	}
+	return memo.NewCaptureNode, memo.NewCaptureDummy
+}
+
+// backRef ends the back-reference check ent, which has been popped without
+// propagating its captures, at the current position of src. It returns the
+// number of bytes to advance, or -1 if the check fails.
+func (s *stack) backRef(ent *stackEntry, src *input.Input) int {
+	switch isa.RefKind(ent.memo.count) {
+	case isa.RefDef:
+		s.addCapt(memo.NewCaptureSymbol(int(ent.memo.id), ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt))
+	case isa.RefBlock:
+		if len(ent.capt) > 0 {
+			s.addCapt(memo.NewCaptureScope(ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt))
+		}
+	case isa.RefUse:
+		s.addCapt(ent.capt...)
+		sym := s.lookup(int(ent.memo.id))
+		if sym == nil {
+			return -1
+		}
+		// compare byte by byte so that the furthest examined position is
+		// tracked for memoization.
+		start := src.Pos()
+		want := src.Slice(sym.Start(), sym.End())
+		for _, b := range want {
+			if c, ok := src.Peek(); !ok || c != b {
+				src.SeekTo(start)
+				return -1
+			}
+			src.Advance(1)
+		}
+		src.SeekTo(start)
+		return len(want)
+	}
+	return 0
+}
+
+// lookup returns the visible definition of symbol id, or nil if there is
+// none. Memoized results that are being computed and do not contain the
+// definition depend on it, so they are marked as external.
+func (s *stack) lookup(id int) *memo.Capture {
+	i := len(s.entries) - 1
+	var sym *memo.Capture
+	for ; i >= 0 && sym == nil; i-- {
+		sym = memo.FindSymbol(s.entries[i].capt, id)
+	}
+	if sym == nil {
+		sym = memo.FindSymbol(s.capt, id)
+	} else {
+		// the definition is in entry i+1.
+		i++
+	}
+	for i++; i < len(s.entries); i++ {
+		if t := s.entries[i].stype; t == stMemo || t == stMemoTree {
+			s.entries[i].memo.external = true
+		}
+	}
+	return sym
+}
@@ -0,0 +1,806 @@
+package vm
+
+import (
+	"regexp/syntax"
+
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+)
+
+// A Backend selects how Exec runs the instructions of a Code.
+type Backend int
+
+const (
+	// Interpreter decodes each instruction from the bytecode every time it
+	// is executed. This is the default backend.
+	Interpreter Backend = iota
+	// Closures compiles each instruction into a Go closure that holds its
+	// decoded arguments and is linked to the closures of the instructions
+	// that may follow it, so that no bytecode is decoded while parsing.
+	Closures
+)
+
+// SetBackend selects the backend that Exec uses to run this code. Selecting
+// Closures compiles the code, so it should be done once after the code is
+// loaded rather than before every parse. Parse, ExecInterval and ExecContext
+// always use the interpreter, because they need to observe every
+// instruction.
+func (vm *Code) SetBackend(b Backend) {
+	vm.threads = nil
+	if b == Closures {
+		vm.threads = compileThreads(vm)
+	}
+}
+
+// Backend returns the backend that Exec uses to run this code.
+func (vm *Code) Backend() Backend {
+	if vm.threads != nil {
+		return Closures
+	}
+	return Interpreter
+}
+
+// A thread is the compiled form of an instruction. It executes the
+// instruction and returns the thread of the next instruction to execute, or
+// nil when the execution is over.
+type thread func(m *machine) thread
+
+// threadCode holds the threads compiled from a Code.
+type threadCode struct {
+	// the thread of each instruction, indexed by the location of the
+	// instruction in the bytecode
+	threads []thread
+	labels  []string
+	catches [][]int
+}
+
+// A machine holds the state of an execution of a threadCode. It is the
+// closure backend's equivalent of the local variables of exec.
+type machine struct {
+	code   *threadCode
+	src    *input.Input
+	st     *stack
+	memtbl memo.Table
+
+	// seeds for the calls to left-recursive rules that are in progress.
+	lrtbl map[lrKey]*lrSeed
+	// number of left-recursive calls on the stack.
+	lrdepth int
+
+	// the label, recovery rule and return address of a labeled failure
+	// being thrown.
+	label, recovery, ret int
+
+	errs []ParseError
+	// whether an End instruction was executed, and whether it was a match.
+	done, success bool
+	// the error that stopped the execution, if any.
+	err error
+}
+
+// compileThreads compiles every instruction of vm into a thread.
+func compileThreads(vm *Code) *threadCode {
+	idata := vm.data.Insns
+	c := &threadCode{
+		// a label may refer to the end of the code
+		threads: make([]thread, len(idata)+1),
+		labels:  vm.data.Labels,
+		catches: vm.data.Catches,
+	}
+	for ip := 0; ip < len(idata); {
+		t, sz := c.compile(vm, ip)
+		c.threads[ip] = t
+		if sz == 0 {
+			break
+		}
+		ip += sz
+	}
+	return c
+}
+
+// at returns a pointer to the thread of the instruction at ip. Threads refer
+// to the threads that follow them through these pointers, because those may
+// not be compiled yet.
+func (c *threadCode) at(ip int) *thread {
+	return &c.threads[ip]
+}
+
+// compile returns the thread for the instruction at ip and the size of the
+// instruction. The threads mirror the cases of exec.
+func (c *threadCode) compile(vm *Code, ip int) (thread, int) {
+	idata := vm.data.Insns
+	lbl := func(off int) *thread {
+		return c.at(int(decodeU24(idata[ip+off:])))
+	}
+	newNode, newDummy := vm.captureFuncs()
+
+	switch idata[ip] {
+	case opChar:
+		b := decodeU8(idata[ip+1:])
+		next := c.at(ip + szChar)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && in == b {
+				m.src.Advance(1)
+				return *next
+			}
+			return m.fail()
+		}, szChar
+	case opJump:
+		target := lbl(1)
+		return func(m *machine) thread {
+			return *target
+		}, szJump
+	case opChoice:
+		l := int(decodeU24(idata[ip+1:]))
+		next := c.at(ip + szChoice)
+		return func(m *machine) thread {
+			m.st.pushBacktrack(stackBacktrack{l, m.src.Pos()})
+			return *next
+		}, szChoice
+	case opCall:
+		l := int(decodeU24(idata[ip+1:]))
+		target := c.at(l)
+		ret := stackRet(ip + szCall)
+		return func(m *machine) thread {
+			m.st.pushRet(ret, stackBacktrack{l, m.src.Pos()})
+			return *target
+		}, szCall
+	case opLRCall:
+		l := int(decodeU24(idata[ip+1:]))
+		target := c.at(l)
+		next := c.at(ip + szLRCall)
+		ret := stackRet(ip + szLRCall)
+		return func(m *machine) thread {
+			key := lrKey{l, m.src.Pos()}
+			if seed, ok := m.lrtbl[key]; ok {
+				// recursive call: use the current seed
+				if seed.end == -1 {
+					return m.fail()
+				}
+				m.st.addCapt(seed.capt...)
+				m.src.SeekTo(seed.end)
+				return *next
+			}
+			if m.lrtbl == nil {
+				m.lrtbl = make(map[lrKey]*lrSeed)
+			}
+			m.lrtbl[key] = &lrSeed{end: -1}
+			m.st.pushLR(ret, stackBacktrack{l, m.src.Pos()})
+			m.lrdepth++
+			return *target
+		}, szLRCall
+	case opCommit:
+		target := lbl(1)
+		return func(m *machine) thread {
+			if m.st.pop(true) == nil {
+				return m.abort(&ExecError{Ip: ip, Message: "Commit failed"})
+			}
+			return *target
+		}, szCommit
+	case opReturn:
+		return func(m *machine) thread {
+			if ent := m.st.peek(); ent != nil && ent.stype == stLR {
+				key := lrKey{ent.btrack.ip, ent.btrack.off}
+				seed := m.lrtbl[key]
+				if m.src.Pos() > seed.end {
+					// the rule made progress: save the result as the new
+					// seed and grow it by entering the rule again.
+					seed.end = m.src.Pos()
+					seed.capt = ent.capt[:len(ent.capt):len(ent.capt)]
+					ent.capt = nil
+					m.src.SeekTo(ent.btrack.off)
+					return m.code.threads[ent.btrack.ip]
+				}
+				// no progress: the previous seed is the result.
+				m.st.pop(false)
+				m.lrdepth--
+				delete(m.lrtbl, key)
+				m.st.addCapt(seed.capt...)
+				m.src.SeekTo(seed.end)
+				return m.code.threads[ent.ret]
+			}
+			ent := m.st.pop(true)
+			if ent != nil && ent.stype == stRet {
+				return m.code.threads[ent.ret]
+			}
+			return m.abort(&ExecError{Ip: ip, Message: "Return failed"})
+		}, szReturn
+	case opFail:
+		return func(m *machine) thread {
+			return m.fail()
+		}, szFail
+	case opSet:
+		set := decodeSet(idata[ip+1:], vm.data.Sets)
+		next := c.at(ip + szSet)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && set.Has(in) {
+				m.src.Advance(1)
+				return *next
+			}
+			return m.fail()
+		}, szSet
+	case opAny:
+		n := int(decodeU8(idata[ip+1:]))
+		next := c.at(ip + szAny)
+		return func(m *machine) thread {
+			if m.src.Advance(n) {
+				return *next
+			}
+			return m.fail()
+		}, szAny
+	case opAnyRune:
+		next := c.at(ip + szAnyRune)
+		return func(m *machine) thread {
+			if _, n, ok := m.src.PeekRune(); ok {
+				m.src.Advance(n)
+				return *next
+			}
+			return m.fail()
+		}, szAnyRune
+	case opRuneSet:
+		set := &vm.data.RuneSets[decodeU24(idata[ip+1:])]
+		next := c.at(ip + szRuneSet)
+		return func(m *machine) thread {
+			if r, n, ok := m.src.PeekRune(); ok && set.Has(r) {
+				m.src.Advance(n)
+				return *next
+			}
+			return m.fail()
+		}, szRuneSet
+	case opLiterals:
+		t := &vm.data.Tries[decodeU24(idata[ip+1:])]
+		next := c.at(ip + szLiterals)
+		return func(m *machine) thread {
+			if t.match(m.src, nil, ip) {
+				return *next
+			}
+			return m.fail()
+		}, szLiterals
+	case opPartialCommit:
+		target := lbl(1)
+		return func(m *machine) thread {
+			ent := m.st.peek()
+			if ent == nil || ent.stype != stBtrack {
+				return m.abort(&ExecError{Ip: ip, Message: "PartialCommit failed"})
+			}
+			ent.btrack.off = m.src.Pos()
+			m.st.propCapt()
+			ent.capt = nil
+			return *target
+		}, szPartialCommit
+	case opSpan:
+		set := decodeSet(idata[ip+1:], vm.data.Sets)
+		next := c.at(ip + szSpan)
+		return func(m *machine) thread {
+			in, ok := m.src.Peek()
+			for ok && set.Has(in) {
+				m.src.Advance(1)
+				in, ok = m.src.Peek()
+			}
+			return *next
+		}, szSpan
+	case opBackCommit:
+		target := lbl(1)
+		return func(m *machine) thread {
+			ent := m.st.pop(true)
+			if ent == nil || ent.stype != stBtrack {
+				return m.abort(&ExecError{Ip: ip, Message: "BackCommit failed"})
+			}
+			m.src.SeekTo(ent.btrack.off)
+			return *target
+		}, szBackCommit
+	case opFailTwice:
+		return func(m *machine) thread {
+			m.st.pop(false)
+			return m.fail()
+		}, szFailTwice
+	case opEmpty:
+		op := syntax.EmptyOp(decodeU8(idata[ip+1:]))
+		next := c.at(ip + szEmpty)
+		return func(m *machine) thread {
+			r1, r2 := rune(-1), rune(-1)
+			if b, ok := m.src.PeekBefore(); ok {
+				r1 = rune(b)
+			}
+			if b, ok := m.src.Peek(); ok {
+				r2 = rune(b)
+			}
+			if syntax.EmptyOpContext(r1, r2)&op != 0 {
+				return *next
+			}
+			return m.fail()
+		}, szEmpty
+	case opTestChar:
+		b := decodeU8(idata[ip+2:])
+		l := int(decodeU24(idata[ip+3:]))
+		target := c.at(l)
+		next := c.at(ip + szTestChar)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && in == b {
+				m.st.pushBacktrack(stackBacktrack{l, m.src.Pos()})
+				m.src.Advance(1)
+				return *next
+			}
+			return *target
+		}, szTestChar
+	case opTestCharNoChoice:
+		b := decodeU8(idata[ip+2:])
+		target := lbl(3)
+		next := c.at(ip + szTestCharNoChoice)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && in == b {
+				m.src.Advance(1)
+				return *next
+			}
+			return *target
+		}, szTestCharNoChoice
+	case opTestSet:
+		set := decodeSet(idata[ip+2:], vm.data.Sets)
+		l := int(decodeU24(idata[ip+3:]))
+		target := c.at(l)
+		next := c.at(ip + szTestSet)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && set.Has(in) {
+				m.st.pushBacktrack(stackBacktrack{l, m.src.Pos()})
+				m.src.Advance(1)
+				return *next
+			}
+			return *target
+		}, szTestSet
+	case opTestSetNoChoice:
+		set := decodeSet(idata[ip+2:], vm.data.Sets)
+		target := lbl(3)
+		next := c.at(ip + szTestSetNoChoice)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && set.Has(in) {
+				m.src.Advance(1)
+				return *next
+			}
+			return *target
+		}, szTestSetNoChoice
+	case opPeekSet:
+		set := decodeSet(idata[ip+2:], vm.data.Sets)
+		target := lbl(3)
+		next := c.at(ip + szPeekSet)
+		return func(m *machine) thread {
+			if in, ok := m.src.Peek(); ok && set.Has(in) {
+				return *next
+			}
+			return *target
+		}, szPeekSet
+	case opTestAny:
+		n := int(decodeU8(idata[ip+2:]))
+		l := int(decodeU24(idata[ip+3:]))
+		target := c.at(l)
+		next := c.at(ip + szTestAny)
+		return func(m *machine) thread {
+			ent := stackBacktrack{l, m.src.Pos()}
+			if m.src.Advance(n) {
+				m.st.pushBacktrack(ent)
+				return *next
+			}
+			return *target
+		}, szTestAny
+	case opCaptureBegin:
+		id := decodeI16(idata[ip+2:])
+		next := c.at(ip + szCaptureBegin)
+		return func(m *machine) thread {
+			m.st.pushCapt(stackMemo{
+				id:  id,
+				pos: m.src.Pos(),
+			})
+			return *next
+		}, szCaptureBegin
+	case opCaptureLate:
+		back := int(decodeU8(idata[ip+1:]))
+		id := decodeI16(idata[ip+2:])
+		next := c.at(ip + szCaptureLate)
+		return func(m *machine) thread {
+			m.st.pushCapt(stackMemo{
+				id:  id,
+				pos: m.src.Pos() - back,
+			})
+			return *next
+		}, szCaptureLate
+	case opCaptureFull:
+		back := int(decodeU8(idata[ip+1:]))
+		id := int(decodeI16(idata[ip+2:]))
+		next := c.at(ip + szCaptureFull)
+		return func(m *machine) thread {
+			pos := m.src.Pos()
+			m.st.addCapt(memo.NewCaptureNode(id, pos-back, back, nil))
+			return *next
+		}, szCaptureFull
+	case opCaptureEnd:
+		next := c.at(ip + szCaptureEnd)
+		return func(m *machine) thread {
+			ent := m.st.pop(false)
+			if ent == nil || ent.stype != stCapt {
+				return m.abort(&ExecError{Ip: ip, Message: "CaptureEnd did not find capture entry"})
+			}
+			end := m.src.Pos()
+			m.st.addCapt(newNode(int(ent.memo.id), ent.memo.pos, end-ent.memo.pos, ent.capt))
+			return *next
+		}, szCaptureEnd
+	case opRepeatOpen:
+		n := int(decodeU24(idata[ip+1:]))
+		next := c.at(ip + szRepeatOpen)
+		return func(m *machine) thread {
+			m.st.pushRepeat(stackMemo{
+				count: n,
+				pos:   m.src.Pos(),
+			})
+			return *next
+		}, szRepeatOpen
+	case opRepeatNext:
+		target := lbl(1)
+		next := c.at(ip + szRepeatNext)
+		return func(m *machine) thread {
+			ent := m.st.peek()
+			if ent == nil || ent.stype != stRepeat {
+				return m.abort(&ExecError{Ip: ip, Message: "RepeatNext did not find counter entry"})
+			}
+			ent.memo.count--
+			if ent.memo.count > 0 {
+				return *target
+			}
+			m.st.pop(true)
+			return *next
+		}, szRepeatNext
+	case opRepeatClose:
+		next := c.at(ip + szRepeatClose)
+		return func(m *machine) thread {
+			ent := m.st.pop(true)
+			if ent == nil || ent.stype != stRepeat {
+				return m.abort(&ExecError{Ip: ip, Message: "RepeatClose did not find counter entry"})
+			}
+			return *next
+		}, szRepeatClose
+	case opCatch:
+		l := int(decodeU24(idata[ip+1:]))
+		set := stackRet(decodeU16(idata[ip+4:]))
+		next := c.at(ip + szCatch)
+		return func(m *machine) thread {
+			m.st.pushCatch(set, stackBacktrack{l, m.src.Pos()})
+			return *next
+		}, szCatch
+	case opThrow:
+		label := int(decodeU24(idata[ip+1:]))
+		return func(m *machine) thread {
+			m.label = label
+			m.recovery = -1
+			return m.throw()
+		}, szThrow
+	case opThrowRecover:
+		label := int(decodeU16(idata[ip+4:]))
+		recovery := int(decodeU24(idata[ip+1:]))
+		ret := ip + szThrowRecover
+		return func(m *machine) thread {
+			m.label = label
+			m.recovery = recovery
+			m.ret = ret
+			return m.throw()
+		}, szThrowRecover
+	case opEnd:
+		success := decodeU8(idata[ip+1:]) != 1
+		return func(m *machine) thread {
+			m.done = true
+			m.success = success
+			return nil
+		}, szEnd
+	case opMemoOpen:
+		target := lbl(1)
+		id := decodeI16(idata[ip+4:])
+		next := c.at(ip + szMemoOpen)
+		return func(m *machine) thread {
+			ment, ok := m.memtbl.Get(int(id), m.src.Pos())
+			if !ok {
+				m.st.pushMemo(stackMemo{
+					id:  id,
+					pos: m.src.Pos(),
+				})
+				return *next
+			}
+			if ment.Length() == -1 {
+				return m.fail()
+			}
+			if capt := ment.Captures(); capt != nil {
+				m.st.addCapt(capt...)
+			}
+			m.src.Advance(ment.Length())
+			return *target
+		}, szMemoOpen
+	case opMemoClose:
+		next := c.at(ip + szMemoClose)
+		return func(m *machine) thread {
+			ent := m.st.pop(true)
+			if ent == nil || ent.stype != stMemo {
+				return m.abort(&ExecError{Ip: ip, Message: "memo close failed"})
+			}
+			mlen := m.src.Pos() - ent.memo.pos
+			if !ent.memo.external {
+				m.memoize(int(ent.memo.id), ent.memo.pos, mlen, 1, ent.capt)
+			}
+			return *next
+		}, szMemoClose
+	case opMemoTreeOpen:
+		target := lbl(1)
+		id := decodeI16(idata[ip+4:])
+		next := c.at(ip + szMemoTreeOpen)
+		return func(m *machine) thread {
+			ment, ok := m.memtbl.Get(int(id), m.src.Pos())
+			if !ok {
+				m.st.pushMemoTree(stackMemo{
+					id:  id,
+					pos: m.src.Pos(),
+				})
+				return *next
+			}
+			if ment.Length() == -1 {
+				return m.fail()
+			}
+			m.st.pushMemoTree(stackMemo{
+				id:    id,
+				pos:   m.src.Pos(),
+				count: ment.Count(),
+			})
+			if capt := ment.Captures(); capt != nil {
+				m.st.addCapt(capt...)
+			}
+			m.src.Advance(ment.Length())
+			m.src.Peek()
+			return *target
+		}, szMemoTreeOpen
+	case opMemoTreeClose:
+		id := decodeI16(idata[ip+2:])
+		next := c.at(ip + szMemoTreeClose)
+		return func(m *machine) thread {
+			for p := m.st.peek(); p != nil && p.stype == stMemoTree && p.memo.id == id; p = m.st.peek() {
+				m.st.pop(true)
+			}
+			return *next
+		}, szMemoTreeClose
+	case opMemoTreeInsert:
+		next := c.at(ip + szMemoTreeInsert)
+		return func(m *machine) thread {
+			ent := m.st.peek()
+			if ent == nil || ent.stype != stMemoTree {
+				return m.abort(&ExecError{Ip: ip, Message: "no memo entry on stack"})
+			}
+			mlen := m.src.Pos() - ent.memo.pos
+			ent.memo.count++
+			if !ent.memo.external {
+				m.memoize(int(ent.memo.id), ent.memo.pos, mlen, ent.memo.count, ent.capt)
+			}
+			return *next
+		}, szMemoTreeInsert
+	case opMemoTree:
+		next := c.at(ip + szMemoTree)
+		return func(m *machine) thread {
+			seen := 0
+			accum := 0
+			for {
+				top := m.st.peekn(seen)
+				next := m.st.peekn(seen + 1)
+
+				if top == nil || next == nil || top.stype != stMemoTree || next.stype != stMemoTree {
+					break
+				}
+
+				seen++
+				accum += top.memo.count
+
+				if accum < next.memo.count {
+					continue
+				}
+
+				for i := 0; i < seen-1; i++ {
+					m.st.pop(true)
+				}
+				ent := m.st.pop(false) // next is now top of stack
+
+				if len(ent.capt) > 0 {
+					dummy := newDummy(ent.memo.pos, m.src.Pos()-ent.memo.pos, ent.capt)
+					m.st.addCapt(dummy)
+				}
+
+				next.memo.count = accum + next.memo.count
+				mlen := m.src.Pos() - next.memo.pos
+				if !next.memo.external {
+					m.memoize(int(next.memo.id), next.memo.pos, mlen, next.memo.count, next.capt)
+				}
+
+				accum = 0
+				seen = 0
+			}
+			return *next
+		}, szMemoTree
+	case opCheckBegin:
+		id := decodeI16(idata[ip+2:])
+		flag := int(decodeI16(idata[ip+4:]))
+		next := c.at(ip + szCheckBegin)
+		return func(m *machine) thread {
+			m.st.pushCheck(stackMemo{
+				id:    id,
+				count: flag,
+				pos:   m.src.Pos(),
+			})
+			return *next
+		}, szCheckBegin
+	case opCheckEnd:
+		checker := vm.data.Checkers[decodeU24(idata[ip+1:])]
+		next := c.at(ip + szCheckEnd)
+		if isBackRef(checker) {
+			return func(m *machine) thread {
+				ent := m.st.pop(false)
+				if ent == nil || ent.stype != stCheck {
+					return m.abort(&ExecError{Ip: ip, Message: "check end needs check stack entry"})
+				}
+				n := m.st.backRef(ent, m.src)
+				if n == -1 {
+					return m.fail()
+				}
+				m.src.Advance(n)
+				return *next
+			}, szCheckEnd
+		}
+		return func(m *machine) thread {
+			ent := m.st.pop(true)
+			if ent == nil || ent.stype != stCheck {
+				return m.abort(&ExecError{Ip: ip, Message: "check end needs check stack entry"})
+			}
+			n := checker.Check(m.src.Slice(ent.memo.pos, m.src.Pos()), m.src, int(ent.memo.id), ent.memo.count)
+			if n == -1 {
+				return m.fail()
+			}
+			m.src.Advance(n)
+			return *next
+		}, szCheckEnd
+	case opError:
+		msg := vm.data.Errors[decodeU24(idata[ip+1:])]
+		next := c.at(ip + szError)
+		return func(m *machine) thread {
+			m.errs = append(m.errs, ParseError{
+				Pos:     m.src.Pos(),
+				Message: msg,
+			})
+			return *next
+		}, szError
+	}
+	return func(m *machine) thread {
+		return m.abort(&ExecError{Ip: ip, Message: "Invalid opcode"})
+	}, 0
+}
+
+// exec runs the threads from the first instruction with a stack that may
+// hold max entries. It returns the same results as exec without an interval,
+// failure tracker or monitor.
+func (c *threadCode) exec(src *input.Input, memtbl memo.Table, max int) (bool, int, *memo.Capture, []ParseError, error) {
+	if len(c.threads) == 1 {
+		return true, 0, memo.NewCaptureDummy(0, 0, nil), nil, nil
+	}
+
+	m := &machine{
+		code:   c,
+		src:    src,
+		st:     newStack(max),
+		memtbl: memtbl,
+	}
+	for t := c.threads[0]; t != nil && !m.st.overflow; {
+		t = t(m)
+	}
+	if m.st.overflow {
+		return false, src.Pos(), nil, m.errs, &AbortError{
+			Err:     ErrStackOverflow,
+			Pos:     src.Pos(),
+			Capture: memo.NewCaptureDummy(0, src.Pos(), m.st.allCapt()),
+		}
+	}
+	if m.err != nil {
+		return false, src.Pos(), nil, m.errs, m.err
+	}
+	if !m.done {
+		return false, src.Pos(), nil, m.errs, nil
+	}
+	return m.success, src.Pos(), memo.NewCaptureDummy(0, src.Pos(), m.st.capt), m.errs, nil
+}
+
+// abort stops the execution because of err.
+func (m *machine) abort(err error) thread {
+	m.err = err
+	return nil
+}
+
+func (m *machine) memoize(id, pos, mlen, count int, capt []*memo.Capture) {
+	if m.lrdepth > 0 {
+		// results computed while a left-recursive rule is being grown
+		// depend on an intermediate seed and cannot be reused.
+		return
+	}
+	mexam := max(m.src.Furthest(), m.src.Pos()) - pos + 1
+	m.memtbl.Put(id, pos, mlen, mexam, count, capt)
+}
+
+// fail pops entries off the stack until it finds one to resume from, and
+// returns the thread to resume at, or nil if the match failed.
+func (m *machine) fail() thread {
+	for {
+		ent := m.st.pop(false)
+		if ent == nil {
+			// match failed
+			return nil
+		}
+
+		switch ent.stype {
+		case stBtrack:
+			m.src.SeekTo(ent.btrack.off)
+			ent.capt = nil
+			return m.code.threads[ent.btrack.ip]
+		case stMemo:
+			// mark this position in the memo table as a failed match
+			if !ent.memo.external {
+				m.memoize(int(ent.memo.id), ent.memo.pos, -1, 0, nil)
+			}
+			ent.capt = nil
+		case stLR:
+			m.lrdepth--
+			key := lrKey{ent.btrack.ip, ent.btrack.off}
+			seed := m.lrtbl[key]
+			delete(m.lrtbl, key)
+			ent.capt = nil
+			if seed.end != -1 {
+				// growing the seed failed: the previous seed is the result.
+				m.st.addCapt(seed.capt...)
+				m.src.SeekTo(seed.end)
+				return m.code.threads[ent.ret]
+			}
+		default:
+			ent.capt = nil
+		}
+	}
+}
+
+// throw unwinds the stack to the innermost catch entry that handles the
+// thrown label, or calls the recovery rule if there is none.
+func (m *machine) throw() thread {
+	st := m.st
+	for i := len(st.entries) - 1; i >= 0; i-- {
+		if st.entries[i].stype != stCatch || !m.catches(int(st.entries[i].ret), m.label) {
+			continue
+		}
+		for len(st.entries) > i+1 {
+			ent := st.pop(false)
+			if ent.stype == stLR {
+				m.lrdepth--
+				delete(m.lrtbl, lrKey{ent.btrack.ip, ent.btrack.off})
+			}
+			ent.capt = nil
+		}
+		ent := st.pop(false)
+		ent.capt = nil
+		m.src.SeekTo(ent.btrack.off)
+		return m.code.threads[ent.btrack.ip]
+	}
+
+	m.errs = append(m.errs, ParseError{
+		Pos:     m.src.Pos(),
+		Message: m.code.labels[m.label],
+	})
+	if m.recovery == -1 {
+		// nothing handles the label: the match fails
+		return nil
+	}
+	// call the recovery rule in place of the failure
+	st.pushRet(stackRet(m.ret), stackBacktrack{m.recovery, m.src.Pos()})
+	return m.code.threads[m.recovery]
+}
+
+// Returns true if the set of labels at index set contains label.
+func (m *machine) catches(set, label int) bool {
+	for _, l := range m.code.catches[set] {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -15,20 +15,70 @@ import (
 // Code is the representation of VM bytecode.
 type Code struct {
 	data code
+	// pattern nodes of the source ranges in data.Source
+	nodes []interface{}
+	// compiled threads, if the closure backend is selected
+	threads *threadCode
+	// maximum depth of the stack, or 0 for no limit
+	maxDepth int
+	// capture IDs by name, built from data.CapNames
+	capIds map[string]int
 }
 
 type code struct {
 	// list of charsets
 	Sets []charset.Set
+	// list of rune sets
+	RuneSets []charset.RuneSet
+	// list of tries of the strings of literals instructions
+	Tries []trie
 	// list of error messages
 	Errors []string
 	// list of checker functions
 	Checkers []isa.Checker
+	// whether back-reference checks define symbols
+	Symbols bool
+	// names of grammar rules indexed by the location of their code
+	Rules map[int]string
+	// locations where not predicates resume when their pattern fails
+	Preds map[int]bool
+	// names of capture IDs
+	CapNames map[int]string
+	// list of failure labels
+	Labels []string
+	// list of sets of labels handled by catch instructions
+	Catches [][]int
+	// locations in the grammar source of ranges of instructions, sorted by
+	// the start of the range with enclosing ranges first
+	Source []sourceRange
 
 	// the encoded instructions
 	Insns []byte
 }
 
+// CaptureName returns the name of the given capture ID, if it has one.
+func (c *Code) CaptureName(id int) (string, bool) {
+	name, ok := c.data.CapNames[id]
+	return name, ok
+}
+
+// CaptureId returns the capture ID with the given name. If several IDs have
+// the name, the smallest one is returned.
+func (c *Code) CaptureId(name string) (int, bool) {
+	id, ok := c.capIds[name]
+	return id, ok
+}
+
+// indexCaptures builds the map from capture names to IDs.
+func (c *Code) indexCaptures() {
+	c.capIds = make(map[string]int, len(c.data.CapNames))
+	for id, name := range c.data.CapNames {
+		if prev, ok := c.capIds[name]; !ok || id < prev {
+			c.capIds[name] = id
+		}
+	}
+}
+
 // Size returns the size of the encoded instructions.
 func (c *Code) Size() int {
 	return len(c.data.Insns)
@@ -59,9 +109,11 @@ func FromBytes(b []byte) (Code, error) {
 	dec := gob.NewDecoder(fz)
 	err = dec.Decode(&c)
 	fz.Close()
-	return Code{
+	code := Code{
 		data: c,
-	}, err
+	}
+	code.indexCaptures()
+	return code, err
 }
 
 // ToJson returns this Code serialized to JSON form.
@@ -73,9 +125,11 @@ func (c *Code) ToJson() ([]byte, error) {
 func FromJson(b []byte) (Code, error) {
 	var c code
 	err := json.Unmarshal(b, &c)
-	return Code{
+	code := Code{
 		data: c,
-	}, err
+	}
+	code.indexCaptures()
+	return code, err
 }
 
 // Encode transforms a program into VM bytecode.
@@ -89,24 +143,58 @@ func Encode(insns isa.Program) Code {
 
 	var bcount uint
 	labels := make(map[isa.Label]uint)
+	failTwice := false
+	var open []int
 	for _, insn := range insns {
 		switch t := insn.(type) {
 		case isa.Nop:
 			continue
+		case isa.NodeBegin:
+			open = append(open, len(code.data.Source))
+			code.data.Source = append(code.data.Source, sourceRange{
+				Start:    int(bcount),
+				SrcStart: t.Start,
+				SrcEnd:   t.End,
+			})
+			code.nodes = append(code.nodes, t.Node)
+			continue
+		case isa.NodeEnd:
+			if len(open) > 0 {
+				code.data.Source[open[len(open)-1]].End = int(bcount)
+				open = open[:len(open)-1]
+			}
+			continue
 		case isa.Label:
 			labels[t] = bcount
+			// a not predicate ends with a FailTwice followed by the label
+			// its choice jumps to.
+			if failTwice {
+				if code.data.Preds == nil {
+					code.data.Preds = make(map[int]bool)
+				}
+				code.data.Preds[int(bcount)] = true
+			}
+			continue
+		case isa.RuleBegin:
+			if code.data.Rules == nil {
+				code.data.Rules = make(map[int]string)
+			}
+			code.data.Rules[int(bcount)] = t.Name
 			continue
 		default:
+			_, failTwice = insn.(isa.FailTwice)
 			bcount += size(insn)
 		}
 	}
 
+	code.pruneSource()
+
 	for _, insn := range insns {
 		var op byte
 		var args []byte
 
 		switch t := insn.(type) {
-		case isa.Label, isa.Nop:
+		case isa.Label, isa.Nop, isa.RuleBegin, isa.NodeBegin, isa.NodeEnd:
 			continue
 		case isa.Char:
 			op = opChar
@@ -120,6 +208,9 @@ func Encode(insns isa.Program) Code {
 		case isa.Call:
 			op = opCall
 			args = encodeLabel(labels[t.Lbl])
+		case isa.LRCall:
+			op = opLRCall
+			args = encodeLabel(labels[t.Lbl])
 		case isa.Commit:
 			op = opCommit
 			args = encodeLabel(labels[t.Lbl])
@@ -133,6 +224,14 @@ func Encode(insns isa.Program) Code {
 		case isa.Any:
 			op = opAny
 			args = []byte{t.N}
+		case isa.AnyRune:
+			op = opAnyRune
+		case isa.RuneSet:
+			op = opRuneSet
+			args = encodeU24(addRuneSet(&code, t.Runes))
+		case isa.Literals:
+			op = opLiterals
+			args = encodeU24(addTrie(&code, t.Strs))
 		case isa.PartialCommit:
 			op = opPartialCommit
 			args = encodeLabel(labels[t.Lbl])
@@ -159,20 +258,26 @@ func Encode(insns isa.Program) Code {
 		case isa.TestSetNoChoice:
 			op = opTestSetNoChoice
 			args = append(encodeU8(addSet(&code, t.Chars)), encodeLabel(labels[t.Lbl])...)
+		case isa.PeekSet:
+			op = opPeekSet
+			args = append(encodeU8(addSet(&code, t.Chars)), encodeLabel(labels[t.Lbl])...)
 		case isa.TestAny:
 			op = opTestAny
 			args = append([]byte{t.N}, encodeLabel(labels[t.Lbl])...)
 		case isa.CaptureBegin:
 			op = opCaptureBegin
 			args = encodeI16(int(t.Id))
+			addCapName(&code, t.Id, t.Name)
 		case isa.CaptureEnd:
 			op = opCaptureEnd
 		case isa.CaptureLate:
 			op = opCaptureLate
 			args = append([]byte{t.Back}, encodeI16(int(t.Id))...)
+			addCapName(&code, t.Id, t.Name)
 		case isa.CaptureFull:
 			op = opCaptureFull
 			args = append([]byte{t.Back}, encodeI16(int(t.Id))...)
+			addCapName(&code, t.Id, t.Name)
 		case isa.MemoOpen:
 			op = opMemoOpen
 			args = append(encodeLabel(labels[t.Lbl]), encodeI16(int(t.Id))...)
@@ -194,9 +299,27 @@ func Encode(insns isa.Program) Code {
 		case isa.CheckEnd:
 			op = opCheckEnd
 			args = encodeU24(addChecker(&code, t.Checker))
+			code.data.Symbols = code.data.Symbols || isBackRef(t.Checker)
 		case isa.Error:
 			op = opError
 			args = encodeU24(addError(&code, t.Message))
+		case isa.RepeatOpen:
+			op = opRepeatOpen
+			args = encodeU24(uint(t.N))
+		case isa.RepeatNext:
+			op = opRepeatNext
+			args = encodeLabel(labels[t.Lbl])
+		case isa.RepeatClose:
+			op = opRepeatClose
+		case isa.Throw:
+			op = opThrow
+			args = encodeU24(addLabel(&code, t.Label))
+		case isa.ThrowRecover:
+			op = opThrowRecover
+			args = append(encodeLabel(labels[t.Lbl]), encodeU16(addLabel(&code, t.Label))...)
+		case isa.Catch:
+			op = opCatch
+			args = append(encodeLabel(labels[t.Lbl]), encodeU16(addCatch(&code, t.Labels))...)
 		case isa.End:
 			op = opEnd
 			args = encodeBool(t.Fail)
@@ -214,6 +337,7 @@ func Encode(insns isa.Program) Code {
 		code.data.Insns = append(code.data.Insns, args...)
 	}
 	code.data.Insns = append(code.data.Insns, opEnd, 0)
+	code.indexCaptures()
 
 	return code
 }
@@ -293,6 +417,22 @@ func addSet(code *Code, set charset.Set) uint {
 	return uint(len(code.data.Sets) - 1)
 }
 
+func addRuneSet(code *Code, set charset.RuneSet) uint {
+	for i, s := range code.data.RuneSets {
+		if set.Equal(s) {
+			return uint(i)
+		}
+	}
+
+	code.data.RuneSets = append(code.data.RuneSets, set)
+	return uint(len(code.data.RuneSets) - 1)
+}
+
+func addTrie(code *Code, strs []string) uint {
+	code.data.Tries = append(code.data.Tries, newTrie(strs))
+	return uint(len(code.data.Tries) - 1)
+}
+
 func addError(code *Code, msg string) uint {
 	for i, s := range code.data.Errors {
 		if msg == s {
@@ -304,6 +444,36 @@ func addError(code *Code, msg string) uint {
 	return uint(len(code.data.Errors) - 1)
 }
 
+func addCapName(code *Code, id int, name string) {
+	if name == "" {
+		return
+	}
+	if code.data.CapNames == nil {
+		code.data.CapNames = make(map[int]string)
+	}
+	code.data.CapNames[id] = name
+}
+
+func addLabel(code *Code, label string) uint {
+	for i, l := range code.data.Labels {
+		if label == l {
+			return uint(i)
+		}
+	}
+
+	code.data.Labels = append(code.data.Labels, label)
+	return uint(len(code.data.Labels) - 1)
+}
+
+func addCatch(code *Code, labels []string) uint {
+	set := make([]int, 0, len(labels))
+	for _, l := range labels {
+		set = append(set, int(addLabel(code, l)))
+	}
+	code.data.Catches = append(code.data.Catches, set)
+	return uint(len(code.data.Catches) - 1)
+}
+
 func addChecker(code *Code, checker isa.Checker) uint {
 	code.data.Checkers = append(code.data.Checkers, checker)
 	return uint(len(code.data.Checkers) - 1)
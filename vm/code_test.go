@@ -1,9 +1,11 @@
 package vm
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/memo"
 	. "github.com/zyedidia/gpeg/pattern"
 )
 
@@ -64,3 +66,119 @@ func TestJson(t *testing.T) {
 		}
 	}
 }
+
+// roundTrip returns code with the codes loaded from its serialization to
+// bytes and to JSON.
+func roundTrip(t *testing.T, code Code) []Code {
+	t.Helper()
+	b, err := code.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bload, err := FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := code.ToJson()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jload, err := FromJson(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []Code{code, bload, jload}
+}
+
+// The tries of Literals instructions are saved with the code.
+func TestTries(t *testing.T) {
+	p := Or(Literal("static"), Literal("super"), Literal("switch"), Literal("s"))
+
+	for _, load := range roundTrip(t, Encode(MustCompile(p))) {
+		for in, want := range map[string]int{"switch": 6, "supper": 1, "x": -1} {
+			match, n, _, _, _ := load.Exec(strings.NewReader(in), memo.NoneTable{})
+			if !match {
+				n = -1
+			}
+			if n != want {
+				t.Errorf("%q: got %d, expected %d", in, n, want)
+			}
+		}
+	}
+}
+
+func TestCaptureNames(t *testing.T) {
+	p := CapGrammar("Expr", map[string]Pattern{
+		"Expr":   Concat(NonTerm("Number"), Star(Concat(Literal("+"), NonTerm("Number")))),
+		"Number": Plus(Set(charset.Range('0', '9'))),
+	}, nil)
+
+	for _, c := range roundTrip(t, Encode(MustCompile(p))) {
+		for _, name := range []string{"Expr", "Number"} {
+			id, ok := c.CaptureId(name)
+			if !ok {
+				t.Errorf("no capture ID for %s", name)
+				continue
+			}
+			if n, ok := c.CaptureName(id); !ok || n != name {
+				t.Errorf("capture ID %d: got name %s, expected %s", id, n, name)
+			}
+		}
+		if _, ok := c.CaptureId("Term"); ok {
+			t.Error("unexpected capture ID for Term")
+		}
+	}
+}
+
+// The smallest of the capture IDs that have the same name is used for the
+// name, whatever the order of the captures.
+func TestDuplicateCaptureNames(t *testing.T) {
+	for _, p := range []Pattern{
+		Concat(NamedCap(Literal("a"), 3, "x"), NamedCap(Literal("b"), 1, "x")),
+		Concat(NamedCap(Literal("a"), 1, "x"), NamedCap(Literal("b"), 3, "x")),
+	} {
+		for _, c := range roundTrip(t, Encode(MustCompile(p))) {
+			if id, ok := c.CaptureId("x"); !ok || id != 1 {
+				t.Errorf("got capture ID %d, expected 1", id)
+			}
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	digits := Set(charset.Range('0', '9'))
+	plain := Concat(Cap(Concat(Literal("("), Plus(digits)), 0), Optional(Literal(")")), Not(Literal("x")), Or(Literal("ab"), Literal("cd")))
+	src := Concat(
+		Source(Cap(Concat(Source(Literal("("), 1, 4), Source(Plus(Source(digits, 5, 10)), 5, 11)), 0), 0, 12),
+		Source(Optional(Source(Literal(")"), 13, 16)), 13, 17),
+		Source(Not(Source(Literal("x"), 19, 22)), 18, 22),
+		Or(Source(Literal("ab"), 23, 27), Literal("cd")),
+	)
+
+	code := Encode(MustCompile(src))
+	expect := Encode(MustCompile(plain))
+	if string(code.data.Insns) != string(expect.data.Insns) {
+		t.Fatal("source locations changed the generated code")
+	}
+
+	// the first instruction matches '('
+	info, ok := code.Source(0)
+	if !ok || info.Start != 1 || info.End != 4 || info.Node == nil {
+		t.Errorf("incorrect location for the first instruction: %+v", info)
+	}
+	last := len(code.data.Insns) - 2
+	if _, ok := code.Source(last); ok {
+		t.Error("the final instruction has a location")
+	}
+
+	for _, load := range roundTrip(t, code)[1:] {
+		if info, ok := load.Source(0); !ok || info.Start != 1 || info.End != 4 || info.Node != nil {
+			t.Errorf("incorrect location after loading: %+v", info)
+		}
+	}
+
+	code.StripSource()
+	if _, ok := code.Source(0); ok {
+		t.Error("location was not removed")
+	}
+}
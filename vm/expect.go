@@ -0,0 +1,236 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+
+	"github.com/zyedidia/gpeg/input"
+)
+
+// A SyntaxError describes why a subject failed to match: the farthest
+// position reached by the parser and what it expected to find there.
+type SyntaxError struct {
+	Pos int
+	// Line and Col are the 1-based line and byte column of Pos.
+	Line, Col int
+	// Expected lists the literals, charsets and rule names that would have
+	// allowed the parse to continue, in the order they were tried.
+	Expected []string
+}
+
+func (e *SyntaxError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("unexpected input at %d:%d", e.Line, e.Col)
+	}
+	return fmt.Sprintf("expected %s at %d:%d", orList(e.Expected), e.Line, e.Col)
+}
+
+func orList(items []string) string {
+	switch len(items) {
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " or " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", or " + items[len(items)-1]
+}
+
+// An expectation is something the parser expected at the farthest failure
+// position: either the terminal instruction at ip, or the rule called rule.
+// For Literals instructions, node is the trie node that the search stopped
+// at.
+type expectation struct {
+	ip   int
+	node int
+	rule string
+}
+
+// A failTracker records the farthest position at which a terminal failed to
+// match, and what was expected there.
+type failTracker struct {
+	pos   int
+	items []expectation
+	// number of not predicates being matched. Failures inside a not
+	// predicate are what allows it to succeed, so they are not recorded.
+	silent int
+}
+
+func newFailTracker() *failTracker {
+	return &failTracker{
+		pos: -1,
+	}
+}
+
+// add records that the terminal at ip failed at pos.
+func (f *failTracker) add(ip, pos int) {
+	f.addNode(ip, 0, pos)
+}
+
+// addNode records that the Literals instruction at ip failed at pos, where it
+// stopped at the given node of its trie.
+func (f *failTracker) addNode(ip, node, pos int) {
+	if f.silent > 0 || pos < f.pos {
+		return
+	}
+	if pos > f.pos {
+		f.pos = pos
+		f.items = f.items[:0]
+	}
+	for _, e := range f.items {
+		if e.ip == ip && e.node == node && e.rule == "" {
+			return
+		}
+	}
+	f.items = append(f.items, expectation{ip: ip, node: node})
+}
+
+// mark returns the number of expectations that were recorded before a rule
+// was called at pos.
+func (f *failTracker) mark(pos int) int {
+	if pos != f.pos {
+		return 0
+	}
+	return len(f.items)
+}
+
+// reduce is called when a rule that was called at pos fails. If everything
+// the rule expected was at pos, the rule name is a more helpful description
+// than its terminals, so the expectations recorded since mark are replaced by
+// the rule name.
+func (f *failTracker) reduce(rule string, pos, mark int) {
+	if f.silent > 0 || pos != f.pos || rule == "" || mark > len(f.items) {
+		return
+	}
+	f.items = f.items[:mark]
+	for _, e := range f.items {
+		if e.rule == rule {
+			return
+		}
+	}
+	f.items = append(f.items, expectation{ip: -1, rule: rule})
+}
+
+// drop is called when a rule that was called at pos succeeds without
+// consuming any input. Everything the rule expected was optional, so the
+// expectations recorded since mark are discarded.
+func (f *failTracker) drop(pos, mark int) {
+	if pos != f.pos || mark > len(f.items) {
+		return
+	}
+	f.items = f.items[:mark]
+}
+
+// enterPred is called when the backtrack entry ent for a not predicate is
+// pushed.
+func (f *failTracker) enterPred(ent *stackEntry) {
+	// stackMemo is unused for backtrack entries, so it is used to flag the
+	// entry as belonging to a predicate.
+	ent.memo.count = 1
+	f.silent++
+}
+
+// exitPred is called when the backtrack entry ent is popped.
+func (f *failTracker) exitPred(ent *stackEntry) {
+	if ent != nil && ent.stype == stBtrack && ent.memo.count == 1 {
+		f.silent--
+	}
+}
+
+// err builds the syntax error for the failures recorded by the tracker.
+func (f *failTracker) err(vm *Code, r io.ReaderAt) *SyntaxError {
+	pos := f.pos
+	if pos < 0 {
+		pos = 0
+	}
+	line, col := lineCol(r, pos)
+	serr := &SyntaxError{
+		Pos:  pos,
+		Line: line,
+		Col:  col,
+	}
+	seen := make(map[string]bool)
+	for _, e := range f.items {
+		for _, s := range vm.expected(e) {
+			if s != "" && !seen[s] {
+				seen[s] = true
+				serr.Expected = append(serr.Expected, s)
+			}
+		}
+	}
+	return serr
+}
+
+// expected returns the descriptions of what e expected.
+func (vm *Code) expected(e expectation) []string {
+	if e.rule != "" {
+		return []string{e.rule}
+	}
+	if vm.data.Insns[e.ip] == opLiterals {
+		// each byte that could have continued the search is expected, as
+		// if the strings were matched by Char instructions.
+		n := &vm.data.Tries[decodeU24(vm.data.Insns[e.ip+1:])].Nodes[e.node]
+		var strs []string
+		for b := 0; b <= 255; b++ {
+			if n.Next.Has(byte(b)) {
+				strs = append(strs, strconv.QuoteRuneToASCII(rune(b)))
+			}
+		}
+		return strs
+	}
+	return []string{vm.describe(e.ip)}
+}
+
+// describe returns a description of what the terminal at ip matches.
+func (vm *Code) describe(ip int) string {
+	idata := vm.data.Insns
+	switch idata[ip] {
+	case opChar:
+		return strconv.QuoteRuneToASCII(rune(decodeU8(idata[ip+1:])))
+	case opTestChar, opTestCharNoChoice:
+		return strconv.QuoteRuneToASCII(rune(decodeU8(idata[ip+2:])))
+	case opSet:
+		return decodeSet(idata[ip+1:], vm.data.Sets).String()
+	case opTestSet, opTestSetNoChoice:
+		return decodeSet(idata[ip+2:], vm.data.Sets).String()
+	case opPeekSet:
+		// the set of a single byte comes from a Char of the first
+		// alternative.
+		set := decodeSet(idata[ip+2:], vm.data.Sets)
+		if set.Size() == 1 {
+			for b := 0; b <= 255; b++ {
+				if set.Has(byte(b)) {
+					return strconv.QuoteRuneToASCII(rune(b))
+				}
+			}
+		}
+		return set.String()
+	case opAny, opTestAny, opAnyRune:
+		return "any character"
+	case opRuneSet:
+		return vm.data.RuneSets[decodeU24(idata[ip+1:])].String()
+	case opEmpty:
+		return emptyDescriptions[syntax.EmptyOp(decodeU8(idata[ip+1:]))]
+	}
+	return ""
+}
+
+var emptyDescriptions = map[syntax.EmptyOp]string{
+	syntax.EmptyBeginLine:      "beginning of line",
+	syntax.EmptyEndLine:        "end of line",
+	syntax.EmptyBeginText:      "beginning of text",
+	syntax.EmptyEndText:        "end of text",
+	syntax.EmptyWordBoundary:   "word boundary",
+	syntax.EmptyNoWordBoundary: "non-word boundary",
+}
+
+// Returns the 1-based line and column of pos in r.
+func lineCol(r io.ReaderAt, pos int) (int, int) {
+	b := input.Slice(r, 0, pos)
+	line := bytes.Count(b, []byte{'\n'}) + 1
+	col := len(b) - bytes.LastIndexByte(b, '\n')
+	return line, col
+}
@@ -0,0 +1,178 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+)
+
+// ErrStepLimit is the cause of an AbortError when a program executes more
+// instructions than its step budget allows.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// ErrStackOverflow is the cause of an AbortError when the backtrack and call
+// stack of the VM grows deeper than its limit, for example when parsing
+// deeply nested input. The limit is set by SetMaxStackDepth or by
+// ExecOptions.MaxStackDepth.
+var ErrStackOverflow = errors.New("stack depth limit exceeded")
+
+// ExecOptions configures an execution by ExecContext. The zero value places
+// no limits on the execution.
+type ExecOptions struct {
+	// MaxSteps is the maximum number of instructions to execute, or 0 for no
+	// limit.
+	MaxSteps int
+	// Timeout is the maximum duration of the execution, or 0 for no limit.
+	Timeout time.Duration
+	// MaxStackDepth is the maximum number of entries (backtrack points,
+	// return addresses and open captures) on the VM's stack, or 0 for the
+	// limit set by SetMaxStackDepth.
+	MaxStackDepth int
+	// Trace, if not nil, is called with an event for every instruction
+	// executed, rule entered and exited, and failure.
+	Trace func(ev Event)
+	// Profile, if not nil, accumulates statistics for each rule.
+	Profile *Profile
+}
+
+// An AbortError is returned when an execution is stopped before it completes.
+type AbortError struct {
+	// Err is the reason for stopping: ErrStepLimit, ErrStackOverflow, the
+	// error of the context (context.Canceled or context.DeadlineExceeded),
+	// or the error returned by the capture function of ExecStream.
+	Err error
+	// Pos is the subject position the parser had reached.
+	Pos int
+	// Steps is the number of instructions that were executed.
+	Steps int
+	// Capture holds the captures that were completed before the execution
+	// stopped. Captures that were still open are not included.
+	Capture *memo.Capture
+}
+
+// Error returns the error message.
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("%d: parse aborted after %d steps: %v", e.Pos, e.Steps, e.Err)
+}
+
+// Unwrap returns the reason for stopping.
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// checkInterval is the number of instructions executed between checks of the
+// context, so that the cost of checking is small compared to execution.
+const checkInterval = 1024
+
+// A monitor limits the resources used by an execution and reports its
+// progress to a tracer and a profile.
+type monitor struct {
+	ctx      context.Context
+	maxSteps int
+	steps    int
+	trace    func(ev *Event) error
+	// an error returned by trace for an event other than a step
+	err error
+
+	prof *Profile
+	// profiles of the rules being executed, innermost last
+	calls []*RuleProfile
+
+	// stream, if not nil, receives completed captures during a streaming
+	// execution.
+	stream *streamer
+}
+
+// step records the execution of the instruction at ip and returns an error
+// if the execution must stop.
+func (m *monitor) step(vm *Code, ip int, st *stack, pos int) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.maxSteps > 0 && m.steps >= m.maxSteps {
+		return ErrStepLimit
+	}
+	m.steps++
+	if m.prof != nil {
+		m.current().Steps++
+	}
+	if m.trace != nil {
+		if err := m.trace(vm.event(EventStep, ip, st, pos)); err != nil {
+			return err
+		}
+	}
+	if m.stream != nil && m.steps%streamInterval == 0 {
+		if err := m.stream.flush(st, pos); err != nil {
+			return err
+		}
+	}
+	if m.steps%checkInterval == 0 && m.ctx != nil {
+		return m.ctx.Err()
+	}
+	return nil
+}
+
+// ExecContext executes the parsing program like Exec, but stops if ctx is
+// done or if the limits in opts are exceeded. In that case it returns an
+// *AbortError containing the partial state of the parse. If the program is
+// malformed, an *ExecError is returned. Entries that were added to memtbl
+// before stopping remain valid, so a later execution with the same table does
+// not repeat the work.
+func (vm *Code) ExecContext(ctx context.Context, r io.ReaderAt, memtbl memo.Table, opts ExecOptions) (bool, int, *memo.Capture, []ParseError, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return false, 0, nil, nil, &AbortError{
+			Err: err,
+		}
+	}
+	mon := &monitor{
+		ctx:      ctx,
+		maxSteps: opts.MaxSteps,
+		prof:     opts.Profile,
+	}
+	if opts.Trace != nil {
+		mon.trace = func(ev *Event) error {
+			opts.Trace(*ev)
+			return nil
+		}
+	}
+	depth := opts.MaxStackDepth
+	if depth == 0 {
+		depth = vm.maxDepth
+	}
+	src := input.NewInput(r)
+	return vm.exec(0, newStack(depth), src, memtbl, nil, nil, mon)
+}
+
+// SetMaxStackDepth limits the number of entries (backtrack points, return
+// addresses and open captures) on the VM's stack in every execution of this
+// code, or removes the limit if max is 0. An execution that exceeds the limit
+// stops with an *AbortError whose cause is ErrStackOverflow, so that deeply
+// nested input cannot exhaust the memory of a parser.
+func (vm *Code) SetMaxStackDepth(max int) {
+	vm.maxDepth = max
+}
+
+// abort returns the AbortError for stopping the execution because of err. If
+// m is nil, the execution is not monitored and its steps are not counted.
+func (m *monitor) abort(err error, st *stack, pos int) *AbortError {
+	steps := 0
+	if m != nil {
+		steps = m.steps
+	}
+	return &AbortError{
+		Err:     err,
+		Pos:     pos,
+		Steps:   steps,
+		Capture: memo.NewCaptureDummy(0, pos, st.allCapt()),
+	}
+}
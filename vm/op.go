@@ -40,6 +40,17 @@ const (
 	opMemoTree
 	opMemoTreeClose
 	opError
+	opRepeatOpen
+	opRepeatNext
+	opRepeatClose
+	opLRCall
+	opThrow
+	opThrowRecover
+	opCatch
+	opAnyRune
+	opRuneSet
+	opPeekSet
+	opLiterals
 )
 
 // instruction sizes
@@ -66,6 +77,12 @@ const (
 	szCheckBegin     = 6
 	szCheckEnd       = 4
 	szError          = 4
+	szRepeatOpen     = 4
+	szRepeatClose    = 2
+	szThrow          = 4
+	szAnyRune        = 2
+	szRuneSet        = 4
+	szLiterals       = 4
 
 	// jumps
 	szJump             = 4
@@ -81,13 +98,18 @@ const (
 	szTestAny          = 6
 	szMemoOpen         = 6
 	szMemoTreeOpen     = 6
+	szRepeatNext       = 4
+	szLRCall           = 4
+	szThrowRecover     = 6
+	szCatch            = 6
+	szPeekSet          = 6
 )
 
 // returns the size in bytes of the encoded version of this instruction
 func size(insn isa.Insn) uint {
 	var sz uint
 	switch insn.(type) {
-	case isa.Label, isa.Nop:
+	case isa.Label, isa.Nop, isa.RuleBegin, isa.NodeBegin, isa.NodeEnd:
 		return 0
 	case isa.JumpType, isa.CheckBegin:
 		sz += 4
@@ -99,7 +121,9 @@ func size(insn isa.Insn) uint {
 	switch insn.(type) {
 	case isa.MemoOpen, isa.MemoTreeOpen, isa.MemoTreeClose, isa.CaptureBegin, isa.CaptureLate,
 		isa.CaptureFull, isa.TestChar, isa.TestCharNoChoice, isa.TestSet,
-		isa.TestSetNoChoice, isa.TestAny, isa.Error, isa.CheckBegin, isa.CheckEnd:
+		isa.TestSetNoChoice, isa.TestAny, isa.Error, isa.CheckBegin, isa.CheckEnd,
+		isa.RepeatOpen, isa.Throw, isa.ThrowRecover, isa.Catch, isa.RuneSet, isa.PeekSet,
+		isa.Literals:
 		sz += 2
 	}
 
@@ -141,6 +165,17 @@ var names = map[byte]string{
 	opMemoTreeClose:    "MemoTreeClose",
 	opError:            "Error",
 	opEmpty:            "Empty",
+	opRepeatOpen:       "RepeatOpen",
+	opRepeatNext:       "RepeatNext",
+	opRepeatClose:      "RepeatClose",
+	opLRCall:           "LRCall",
+	opThrow:            "Throw",
+	opThrowRecover:     "ThrowRecover",
+	opCatch:            "Catch",
+	opAnyRune:          "AnyRune",
+	opRuneSet:          "RuneSet",
+	opPeekSet:          "PeekSet",
+	opLiterals:         "Literals",
 }
 
 func opstr(op byte) string {
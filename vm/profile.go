@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// topLevel is the name used in profiles for instructions executed outside of
+// any rule.
+const topLevel = "(top)"
+
+// A RuleProfile holds the statistics collected for a rule.
+type RuleProfile struct {
+	Rule string
+	// Calls is the number of times the rule was called, of which Matches
+	// succeeded and Fails failed.
+	Calls, Matches, Fails int
+	// Consumed is the number of subject bytes consumed by successful calls.
+	Consumed int
+	// Backtracks is the number of failures that occurred while the rule was
+	// the innermost rule being executed.
+	Backtracks int
+	// MemoHits and MemoMisses count the lookups in the memoization table
+	// while the rule was the innermost rule being executed.
+	MemoHits, MemoMisses int
+	// Steps is the number of instructions executed while the rule was the
+	// innermost rule being executed.
+	Steps int
+}
+
+// A Profile collects statistics for each rule of a grammar during executions
+// with ExecContext. The zero value is an empty profile. Rules that the
+// compiler inlined or that are reached by a tail call are attributed to
+// their caller.
+type Profile struct {
+	rules map[string]*RuleProfile
+}
+
+func (p *Profile) rule(name string) *RuleProfile {
+	if name == "" {
+		name = topLevel
+	}
+	if p.rules == nil {
+		p.rules = make(map[string]*RuleProfile)
+	}
+	r, ok := p.rules[name]
+	if !ok {
+		r = &RuleProfile{
+			Rule: name,
+		}
+		p.rules[name] = r
+	}
+	return r
+}
+
+// Rules returns the statistics of every rule that was executed, sorted by
+// the number of instructions executed in each rule.
+func (p *Profile) Rules() []RuleProfile {
+	rules := make([]RuleProfile, 0, len(p.rules))
+	for _, r := range p.rules {
+		rules = append(rules, *r)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Steps != rules[j].Steps {
+			return rules[i].Steps > rules[j].Steps
+		}
+		return rules[i].Rule < rules[j].Rule
+	})
+	return rules
+}
+
+// WriteReport writes the rules as a table to w.
+func WriteReport(w io.Writer, rules []RuleProfile) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "rule\tsteps\tcalls\tmatches\tfails\tconsumed\tbacktracks\tmemo hits\tmemo misses\t")
+	for _, r := range rules {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", r.Rule, r.Steps, r.Calls, r.Matches, r.Fails, r.Consumed, r.Backtracks, r.MemoHits, r.MemoMisses)
+	}
+	return tw.Flush()
+}
+
+// current returns the profile of the innermost rule being executed.
+func (m *monitor) current() *RuleProfile {
+	if len(m.calls) == 0 {
+		return m.prof.rule("")
+	}
+	return m.calls[len(m.calls)-1]
+}
+
+// memo records a lookup in the memoization table.
+func (m *monitor) memo(hit bool) {
+	if m.prof == nil {
+		return
+	}
+	if hit {
+		m.current().MemoHits++
+	} else {
+		m.current().MemoMisses++
+	}
+}
@@ -0,0 +1,82 @@
+package vm
+
+import "sort"
+
+// A sourceRange maps the instructions from Start to End to the bytes SrcStart
+// to SrcEnd of the grammar source.
+type sourceRange struct {
+	Start, End       int
+	SrcStart, SrcEnd int
+}
+
+// A SourceInfo describes the part of a grammar that an instruction was
+// compiled from.
+type SourceInfo struct {
+	// Rule is the name of the rule whose code contains the instruction. The
+	// code of an inlined rule belongs to the rule it was inlined into.
+	Rule string
+	// Start and End are the byte offsets in the grammar source of the
+	// innermost pattern that generated the instruction, or -1 if the
+	// location is unknown.
+	Start, End int
+	// Node is the innermost pattern that generated the instruction. It is
+	// nil if the location is unknown or if the code was loaded with
+	// FromBytes or FromJson.
+	Node interface{}
+}
+
+// Source returns the location in the grammar of the instruction at ip. Source
+// locations are recorded for patterns created with pattern.Source, which
+// includes every pattern compiled by the re package. It returns false if ip
+// is not part of a rule and has no location.
+func (c *Code) Source(ip int) (SourceInfo, bool) {
+	info := SourceInfo{
+		Start: -1,
+		End:   -1,
+	}
+	rule := -1
+	for loc, name := range c.data.Rules {
+		if loc <= ip && loc > rule {
+			rule = loc
+			info.Rule = name
+		}
+	}
+
+	// ranges are properly nested, so the innermost range containing ip is
+	// the one that starts last.
+	ranges := c.data.Source
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].Start > ip
+	})
+	for i--; i >= 0; i-- {
+		if ip < ranges[i].End {
+			info.Start, info.End = ranges[i].SrcStart, ranges[i].SrcEnd
+			if i < len(c.nodes) {
+				info.Node = c.nodes[i]
+			}
+			break
+		}
+	}
+	return info, rule != -1 || info.Start != -1
+}
+
+// StripSource removes the source locations from c so that they are not
+// serialized by ToBytes and ToJson.
+func (c *Code) StripSource() {
+	c.data.Source = nil
+	c.nodes = nil
+}
+
+// Removes the ranges that do not contain any instructions.
+func (c *Code) pruneSource() {
+	n := 0
+	for i, r := range c.data.Source {
+		if r.Start < r.End {
+			c.data.Source[n] = r
+			c.nodes[n] = c.nodes[i]
+			n++
+		}
+	}
+	c.data.Source = c.data.Source[:n]
+	c.nodes = c.nodes[:n]
+}
@@ -7,6 +7,11 @@ import (
 type stack struct {
 	entries []stackEntry
 	capt    []*memo.Capture
+	// the maximum number of entries, or 0 for no limit
+	max int
+	// set when an entry is pushed beyond the limit, so that the execution
+	// stops before the next instruction
+	overflow bool
 }
 
 func (s *stack) addCapt(capt ...*memo.Capture) {
@@ -17,6 +22,15 @@ func (s *stack) addCapt(capt ...*memo.Capture) {
 	}
 }
 
+// allCapt returns the completed captures in the stack, in order.
+func (s *stack) allCapt() []*memo.Capture {
+	capt := append([]*memo.Capture(nil), s.capt...)
+	for _, ent := range s.entries {
+		capt = append(capt, ent.capt...)
+	}
+	return capt
+}
+
 func (s *stack) propCapt() {
 	if len(s.entries) == 0 {
 		return
@@ -39,6 +53,9 @@ const (
 	stMemoTree
 	stCapt
 	stCheck
+	stRepeat
+	stLR
+	stCatch
 )
 
 type stackEntry struct {
@@ -46,9 +63,9 @@ type stackEntry struct {
 	// we could use a union to avoid the space cost but I have found this
 	// doesn't impact performance and the space cost itself is quite small
 	// because the stack is usually small.
-	ret    stackRet // stackRet is reused for stCheck
-	btrack stackBacktrack
-	memo   stackMemo // stackMemo is reused for stCapt
+	ret    stackRet       // stackRet is reused for stCheck and stCatch
+	btrack stackBacktrack // stackBacktrack is reused for stRet, stLR and stCatch
+	memo   stackMemo      // stackMemo is reused for stRet, stCapt, stCheck, stRepeat and stLR
 
 	capt []*memo.Capture
 }
@@ -75,12 +92,32 @@ type stackMemo struct {
 	id    int16
 	pos   int
 	count int
+	// whether the result depends on a symbol defined before pos, in which
+	// case it must not be memoized.
+	external bool
 }
 
-func newStack() *stack {
+// An lrKey identifies a call to a left-recursive rule, by the location of the
+// rule and the subject position it was called at.
+type lrKey struct {
+	ip  int
+	pos int
+}
+
+// An lrSeed is the longest match found so far for a call to a left-recursive
+// rule. An end of -1 means the call has not matched yet.
+type lrSeed struct {
+	end  int
+	capt []*memo.Capture
+}
+
+// newStack returns an empty stack. If max is not 0, the stack overflows when
+// it holds more than max entries.
+func newStack(max int) *stack {
 	return &stack{
 		entries: make([]stackEntry, 0, 4),
 		capt:    make([]*memo.Capture, 0),
+		max:     max,
 	}
 }
 
@@ -90,9 +127,13 @@ func (s *stack) reset() {
 	// released to the garbage collector if the user has no references to them
 	// (unused stack entries shouldn't keep references to those captures).
 	s.entries = make([]stackEntry, 0, 4)
+	s.overflow = false
 }
 
 func (s *stack) push(ent stackEntry) {
+	if s.max > 0 && len(s.entries) >= s.max {
+		s.overflow = true
+	}
 	s.entries = append(s.entries, ent)
 }
 
@@ -124,10 +165,13 @@ func (s *stack) peekn(n int) *stackEntry {
 	return &s.entries[len(s.entries)-n-1]
 }
 
-func (s *stack) pushRet(r stackRet) {
+// pushRet pushes a return address. The location of the callee and the
+// position it was called at are saved in b for error reporting.
+func (s *stack) pushRet(r stackRet, b stackBacktrack) {
 	s.push(stackEntry{
-		stype: stRet,
-		ret:   r,
+		stype:  stRet,
+		ret:    r,
+		btrack: b,
 	})
 }
 
@@ -165,3 +209,28 @@ func (s *stack) pushCheck(m stackMemo) {
 		memo:  m,
 	})
 }
+
+func (s *stack) pushRepeat(m stackMemo) {
+	s.push(stackEntry{
+		stype: stRepeat,
+		memo:  m,
+	})
+}
+
+// pushCatch pushes a catch entry that backtracks to b. The index of the set of
+// labels that the entry handles is stored in r.
+func (s *stack) pushCatch(r stackRet, b stackBacktrack) {
+	s.push(stackEntry{
+		stype:  stCatch,
+		ret:    r,
+		btrack: b,
+	})
+}
+
+func (s *stack) pushLR(r stackRet, b stackBacktrack) {
+	s.push(stackEntry{
+		stype:  stLR,
+		ret:    r,
+		btrack: b,
+	})
+}
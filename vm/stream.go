@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+)
+
+// streamInterval is the number of instructions executed between deliveries
+// of completed captures during a streaming execution.
+const streamInterval = 1024
+
+// lookBehind is the number of bytes before the current position that are
+// kept during a streaming execution, for instructions that look at the
+// previous byte and captures that start before the current position.
+const lookBehind = 256
+
+// ExecStream executes the parsing program on the data read from s without
+// keeping all of it in memory. Captures are passed to emit, in order, as soon
+// as they are complete and no backtracking can undo them, and the data
+// before the earliest position that the parser may still return to is
+// discarded from s. Memory use therefore stays bounded for grammars that
+// repeatedly match and commit to parts of the subject, such as `Line*`, but
+// a capture or backtrack point that encloses the whole subject keeps all of
+// it. The data of a capture can be read from s while emit is running. The
+// data of the latest definition of each back-reference symbol is kept as
+// long as the definition is visible.
+//
+// No memoization table is used, because memoized results would refer to
+// discarded data. If the match fails, emit may already have received the
+// captures that preceded the failure. If emit returns an error, the
+// execution stops and returns an *AbortError wrapping it. A read error from
+// the underlying reader of s is returned as is.
+func (vm *Code) ExecStream(s *input.Stream, emit func(c *memo.Capture) error) (bool, int, []ParseError, error) {
+	mon := &monitor{
+		stream: &streamer{
+			s:    s,
+			emit: emit,
+		},
+	}
+	match, n, capt, errs, err := vm.exec(0, newStack(vm.maxDepth), input.NewInput(s), memo.NoneTable{}, nil, nil, mon)
+	if err == nil && match {
+		it := capt.ChildIterator(0)
+		for c := it(); c != nil; c = it() {
+			if err = emit(c); err != nil {
+				err = &AbortError{Err: err, Pos: n, Steps: mon.steps}
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = s.Err()
+	}
+	return match, n, errs, err
+}
+
+// A streamer delivers completed captures and discards input during a
+// streaming execution.
+type streamer struct {
+	s    *input.Stream
+	emit func(c *memo.Capture) error
+}
+
+// flush passes the captures in st that can no longer be undone to emit and
+// removes them from the stack, then discards the input that can no longer be
+// read.
+func (s *streamer) flush(st *stack, pos int) error {
+	// captures in entries above the first entry that can resume execution
+	// after a failure may be discarded, and captures above an open capture
+	// will become its children.
+	final := len(st.entries)
+	for i := range st.entries {
+		switch st.entries[i].stype {
+		case stBtrack, stLR, stCatch, stCapt, stCheck, stMemoTree:
+			final = i
+		default:
+			continue
+		}
+		break
+	}
+
+	// the definitions of symbols that are delivered stay on the stack,
+	// without their children, so that they can still be referred to.
+	var syms []*memo.Capture
+	keep := func(sym *memo.Capture) {
+		for i := range syms {
+			if syms[i].Id() == sym.Id() {
+				syms[i] = memo.NewCaptureSymbol(sym.Id(), sym.Start(), sym.Len(), nil)
+				return
+			}
+		}
+		syms = append(syms, memo.NewCaptureSymbol(sym.Id(), sym.Start(), sym.Len(), nil))
+	}
+
+	if err := s.deliver(st.capt); err != nil {
+		return err
+	}
+	memo.VisibleSymbols(st.capt, keep)
+	st.capt = nil
+	for i := 0; i < final; i++ {
+		if err := s.deliver(st.entries[i].capt); err != nil {
+			return err
+		}
+		memo.VisibleSymbols(st.entries[i].capt, keep)
+		st.entries[i].capt = nil
+	}
+	st.capt = syms
+
+	low := pos - lookBehind
+	for _, sym := range syms {
+		low = min(low, sym.Start())
+	}
+	for i := range st.entries {
+		ent := &st.entries[i]
+		switch ent.stype {
+		case stBtrack, stLR, stCatch:
+			low = min(low, ent.btrack.off)
+		case stCapt, stCheck:
+			low = min(low, ent.memo.pos)
+		}
+	}
+	s.s.Discard(low)
+	return nil
+}
+
+// deliver passes the captures in capt to emit, replacing transparent
+// captures by their children.
+func (s *streamer) deliver(capt []*memo.Capture) error {
+	for _, c := range capt {
+		if !c.Transparent() {
+			if err := s.emit(c); err != nil {
+				return err
+			}
+			continue
+		}
+		it := c.ChildIterator(0)
+		for ch := it(); ch != nil; ch = it() {
+			if err := s.emit(ch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zyedidia/gpeg/input"
+	"github.com/zyedidia/gpeg/memo"
+)
+
+// An EventKind is the kind of a trace event.
+type EventKind int
+
+const (
+	// EventStep is reported before an instruction is executed.
+	EventStep EventKind = iota
+	// EventEnter is reported when a rule is called. Rules that the compiler
+	// inlined or that are reached by a tail call are not reported.
+	EventEnter
+	// EventExit is reported when a call to a rule matches or fails.
+	EventExit
+	// EventFail is reported when an instruction fails and the VM starts
+	// backtracking.
+	EventFail
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStep:
+		return "step"
+	case EventEnter:
+		return "enter"
+	case EventExit:
+		return "exit"
+	case EventFail:
+		return "fail"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// An Event describes a step in the execution of a program.
+type Event struct {
+	Kind EventKind
+	// Ip is the location of the instruction. For EventEnter it is the
+	// location of the rule that was called.
+	Ip int
+	// Op is the name of the instruction at Ip.
+	Op string
+	// Pos is the position in the subject.
+	Pos int
+	// Depth is the number of entries on the VM's stack.
+	Depth int
+	// Rule is the name of the innermost rule being executed. For EventEnter
+	// and EventExit it is the rule that was entered or exited.
+	Rule string
+	// Match reports whether the rule matched, for EventExit.
+	Match bool
+}
+
+func (ev Event) String() string {
+	switch ev.Kind {
+	case EventEnter:
+		return fmt.Sprintf("%d: enter %s", ev.Pos, ev.Rule)
+	case EventExit:
+		if ev.Match {
+			return fmt.Sprintf("%d: exit %s", ev.Pos, ev.Rule)
+		}
+		return fmt.Sprintf("%d: exit %s (fail)", ev.Pos, ev.Rule)
+	}
+	return fmt.Sprintf("%d: %s %d %s [%s]", ev.Pos, ev.Kind, ev.Ip, ev.Op, ev.Rule)
+}
+
+// event returns an event for the instruction at ip.
+func (vm *Code) event(kind EventKind, ip int, st *stack, pos int) *Event {
+	ev := &Event{
+		Kind:  kind,
+		Ip:    ip,
+		Pos:   pos,
+		Depth: len(st.entries),
+		Rule:  vm.rule(st),
+	}
+	if ip >= 0 && ip < len(vm.data.Insns) {
+		ev.Op = opstr(vm.data.Insns[ip])
+	}
+	return ev
+}
+
+// rule returns the name of the innermost rule called on the stack.
+func (vm *Code) rule(st *stack) string {
+	for i := len(st.entries) - 1; i >= 0; i-- {
+		ent := &st.entries[i]
+		if ent.stype == stRet || ent.stype == stLR {
+			return vm.data.Rules[ent.btrack.ip]
+		}
+	}
+	return ""
+}
+
+// emit reports an event that is not an instruction step. If the tracer
+// returns an error, it is returned by the next call to step.
+func (m *monitor) emit(ev *Event) {
+	if m.trace == nil || m.err != nil {
+		return
+	}
+	m.err = m.trace(ev)
+}
+
+// enter reports a call to the rule whose return entry is on top of st.
+func (m *monitor) enter(vm *Code, st *stack, pos int) {
+	if m.prof != nil {
+		r := m.prof.rule(vm.data.Rules[st.peek().btrack.ip])
+		r.Calls++
+		m.calls = append(m.calls, r)
+	}
+	if m.trace != nil {
+		m.emit(vm.event(EventEnter, st.peek().btrack.ip, st, pos))
+	}
+}
+
+// exit reports that the call recorded in ent matched or failed.
+func (m *monitor) exit(vm *Code, ip int, st *stack, ent *stackEntry, pos int, match bool) {
+	if m.prof != nil && len(m.calls) > 0 {
+		r := m.calls[len(m.calls)-1]
+		m.calls = m.calls[:len(m.calls)-1]
+		if match {
+			r.Matches++
+			r.Consumed += pos - ent.btrack.off
+		} else {
+			r.Fails++
+		}
+	}
+	if m.trace != nil {
+		ev := vm.event(EventExit, ip, st, pos)
+		ev.Rule = vm.data.Rules[ent.btrack.ip]
+		ev.Match = match
+		m.emit(ev)
+	}
+}
+
+// fail reports that the instruction at ip failed.
+func (m *monitor) fail(vm *Code, ip int, st *stack, pos int) {
+	if m.prof != nil {
+		m.current().Backtracks++
+	}
+	if m.trace != nil {
+		m.emit(vm.event(EventFail, ip, st, pos))
+	}
+}
+
+// ErrStopped is the cause of the AbortError returned by a Debugger that was
+// stopped before the program finished.
+var ErrStopped = errors.New("stopped by debugger")
+
+// A Debugger executes a program one event at a time. Breakpoints may be set on
+// rules, subject positions and failures, and the execution can be advanced
+// by a single event with Step or until the next breakpoint with Continue. The
+// program runs in a separate goroutine, which is paused while the Debugger
+// is stopped at an event. The Debugger may only be used by one goroutine.
+type Debugger struct {
+	vm     *Code
+	r      io.ReaderAt
+	memtbl memo.Table
+
+	rules     map[string]bool
+	positions map[int]bool
+	fails     bool
+
+	started  bool
+	finished bool
+	stepping bool
+	last     int
+	st       *stack
+
+	stops  chan Event
+	resume chan debugCmd
+	done   chan struct{}
+
+	match bool
+	n     int
+	capt  *memo.Capture
+	errs  []ParseError
+	err   error
+}
+
+type debugCmd int
+
+const (
+	cmdStep debugCmd = iota
+	cmdContinue
+	cmdStop
+)
+
+// NewDebugger returns a debugger for executing the program on r with the
+// memoization table memtbl. The program starts running at the first call to
+// Step or Continue.
+func NewDebugger(vm *Code, r io.ReaderAt, memtbl memo.Table) *Debugger {
+	return &Debugger{
+		vm:        vm,
+		r:         r,
+		memtbl:    memtbl,
+		rules:     make(map[string]bool),
+		positions: make(map[int]bool),
+		last:      -1,
+		stops:     make(chan Event),
+		resume:    make(chan debugCmd),
+		done:      make(chan struct{}),
+	}
+}
+
+// BreakRule stops the execution whenever the rule with the given name is
+// entered.
+func (d *Debugger) BreakRule(name string) {
+	d.rules[name] = true
+}
+
+// BreakPos stops the execution whenever the parser advances from before pos
+// to pos or beyond.
+func (d *Debugger) BreakPos(pos int) {
+	d.positions[pos] = true
+}
+
+// BreakFail stops the execution whenever an instruction fails.
+func (d *Debugger) BreakFail() {
+	d.fails = true
+}
+
+// Step executes the program until the next event and returns it. It returns
+// false if the program has finished.
+func (d *Debugger) Step() (Event, bool) {
+	return d.run(cmdStep)
+}
+
+// Continue executes the program until a breakpoint is reached and returns
+// the event that triggered it. It returns false if the program has finished.
+func (d *Debugger) Continue() (Event, bool) {
+	return d.run(cmdContinue)
+}
+
+// Stop ends the execution. The result reports an AbortError caused by
+// ErrStopped if the program had not finished.
+func (d *Debugger) Stop() {
+	if !d.started {
+		d.started = true
+		d.finished = true
+		d.err = &AbortError{
+			Err: ErrStopped,
+		}
+		return
+	}
+	d.run(cmdStop)
+}
+
+// Result returns the result of the execution, as returned by ExecContext. It
+// is only valid once Step or Continue have returned false or after Stop.
+func (d *Debugger) Result() (bool, int, *memo.Capture, []ParseError, error) {
+	return d.match, d.n, d.capt, d.errs, d.err
+}
+
+// Backtrace returns the names of the rules that are being executed, from
+// the outermost to the innermost call.
+func (d *Debugger) Backtrace() []string {
+	if d.st == nil || d.finished {
+		return nil
+	}
+	var rules []string
+	for _, ent := range d.st.entries {
+		if ent.stype == stRet || ent.stype == stLR {
+			rules = append(rules, d.vm.data.Rules[ent.btrack.ip])
+		}
+	}
+	return rules
+}
+
+func (d *Debugger) run(cmd debugCmd) (Event, bool) {
+	if d.finished {
+		return Event{}, false
+	}
+	if !d.started {
+		d.started = true
+		d.stepping = cmd == cmdStep
+		d.st = newStack(d.vm.maxDepth)
+		go d.exec()
+	} else {
+		d.resume <- cmd
+	}
+	select {
+	case ev := <-d.stops:
+		return ev, true
+	case <-d.done:
+		d.finished = true
+		return Event{}, false
+	}
+}
+
+func (d *Debugger) exec() {
+	mon := &monitor{
+		trace: d.trace,
+	}
+	src := input.NewInput(d.r)
+	d.match, d.n, d.capt, d.errs, d.err = d.vm.exec(0, d.st, src, d.memtbl, nil, nil, mon)
+	close(d.done)
+}
+
+// trace is called by the VM goroutine for every event and blocks while the
+// execution is stopped.
+func (d *Debugger) trace(ev *Event) error {
+	brk := d.stepping
+	switch ev.Kind {
+	case EventStep:
+		for pos := range d.positions {
+			if d.last < pos && ev.Pos >= pos {
+				brk = true
+			}
+		}
+		d.last = ev.Pos
+	case EventEnter:
+		brk = brk || d.rules[ev.Rule]
+	case EventFail:
+		brk = brk || d.fails
+	}
+	if !brk {
+		return nil
+	}
+
+	d.stops <- *ev
+	switch <-d.resume {
+	case cmdStep:
+		d.stepping = true
+	case cmdContinue:
+		d.stepping = false
+	case cmdStop:
+		return ErrStopped
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/zyedidia/gpeg/charset"
+	"github.com/zyedidia/gpeg/input"
+)
+
+// A trie stores the strings of a Literals instruction as a tree of their
+// prefixes, so that the longest of them that the subject starts with is found
+// with one lookup per byte.
+type trie struct {
+	// the root is the first node, and the children of each node are stored
+	// next to each other, sorted by the byte that leads to them.
+	Nodes []trieNode
+}
+
+// A trieNode is a prefix of the strings of a trie.
+type trieNode struct {
+	// Next is the set of bytes that can follow the prefix, and Child is the
+	// index of the child for the smallest of them.
+	Next  charset.Set
+	Child int
+	// Final is true if the prefix is one of the strings.
+	Final bool
+}
+
+func newTrie(strs []string) trie {
+	sorted := append([]string(nil), strs...)
+	sort.Strings(sorted)
+
+	// the strings that start with the prefix of a node are contiguous in
+	// sorted, and the nodes are created breadth first so that siblings are
+	// next to each other.
+	type prefix struct {
+		node, lo, hi, depth int
+	}
+	t := trie{
+		Nodes: []trieNode{{}},
+	}
+	queue := []prefix{{0, 0, len(sorted), 0}}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		lo := p.lo
+		for lo < p.hi && len(sorted[lo]) == p.depth {
+			t.Nodes[p.node].Final = true
+			lo++
+		}
+		t.Nodes[p.node].Child = len(t.Nodes)
+		for i := lo; i < p.hi; {
+			b := sorted[i][p.depth]
+			j := i + 1
+			for j < p.hi && sorted[j][p.depth] == b {
+				j++
+			}
+			t.Nodes[p.node].Next = t.Nodes[p.node].Next.Add(charset.New([]byte{b}))
+			queue = append(queue, prefix{len(t.Nodes), i, j, p.depth + 1})
+			t.Nodes = append(t.Nodes, trieNode{})
+			i = j
+		}
+	}
+	return t
+}
+
+// Returns the index of the child of n for the byte b, which must be in
+// n.Next.
+func (n *trieNode) child(b byte) int {
+	w := b >> 6
+	i := n.Child + bits.OnesCount64(n.Next.Bits[w]&(uint64(1)<<(b&63)-1))
+	for _, word := range n.Next.Bits[:w] {
+		i += bits.OnesCount64(word)
+	}
+	return i
+}
+
+// match advances src past the longest string of t that it starts with, and
+// returns false if there is none. If ff is not nil, the bytes that the trie
+// expected where the search stopped are recorded as failures of the
+// instruction at ip, like the Char instructions of an ordered choice of the
+// strings would record them.
+func (t *trie) match(src *input.Input, ff *failTracker, ip int) bool {
+	start := src.Pos()
+	end := -1
+	i := 0
+	for {
+		n := &t.Nodes[i]
+		if n.Final {
+			end = src.Pos()
+		}
+		if n.Next == (charset.Set{}) {
+			break
+		}
+		c, ok := src.Peek()
+		if !ok || !n.Next.Has(c) {
+			if ff != nil {
+				ff.addNode(ip, i, src.Pos())
+			}
+			break
+		}
+		i = n.child(c)
+		src.Advance(1)
+	}
+	if end == -1 {
+		src.SeekTo(start)
+		return false
+	}
+	src.SeekTo(end)
+	return true
+}
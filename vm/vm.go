@@ -25,12 +25,31 @@ func (e ParseError) Error() string {
 	return fmt.Sprintf("%v: %s", e.Pos, e.Message)
 }
 
+// An ExecError is returned when the VM cannot continue executing a program
+// because the program is malformed, for example if it returns without a
+// return address on the stack.
+type ExecError struct {
+	Ip      int
+	Message string
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("ip %d: %s", e.Ip, e.Message)
+}
+
 // Exec executes the parsing program this virtual machine was created with. It
 // returns whether the parse was a match, the last position in the subject
-// string that was matched, and any captures that were created.
-func (vm *Code) Exec(r io.ReaderAt, memtbl memo.Table) (bool, int, *memo.Capture, []ParseError) {
+// string that was matched, and any captures that were created. If the program
+// is malformed, an *ExecError is returned, and if the execution exceeds the
+// limit set by SetMaxStackDepth, an *AbortError is returned. Exec runs the
+// code with the backend selected by SetBackend.
+func (vm *Code) Exec(r io.ReaderAt, memtbl memo.Table) (bool, int, *memo.Capture, []ParseError, error) {
+	if vm.threads != nil {
+		return vm.threads.exec(input.NewInput(r), memtbl, vm.maxDepth)
+	}
+
 	ip := 0
-	st := newStack()
+	st := newStack(vm.maxDepth)
 	src := input.NewInput(r)
 
 	// parse in parallel?
@@ -40,22 +59,57 @@ func (vm *Code) Exec(r io.ReaderAt, memtbl memo.Table) (bool, int, *memo.Capture
 	// 	go vm.exec(0, newStack(), srccopy, memtbl)
 	// }
 
-	return vm.exec(ip, st, src, memtbl, nil)
+	return vm.exec(ip, st, src, memtbl, nil, nil, nil)
+}
+
+// Parse executes the parsing program like Exec, but if the subject does not
+// match it returns a *SyntaxError describing the farthest position that the
+// parser reached and what it expected to find there. Failures that are found
+// in the memoization table are not examined again, so the expected items are
+// most precise when memtbl does not contain entries for the failing region.
+func (vm *Code) Parse(r io.ReaderAt, memtbl memo.Table) (int, *memo.Capture, []ParseError, error) {
+	src := input.NewInput(r)
+	ff := newFailTracker()
+	match, n, capt, errs, err := vm.exec(0, newStack(vm.maxDepth), src, memtbl, nil, ff, nil)
+	if err != nil {
+		return n, nil, errs, err
+	}
+	if !match {
+		return n, nil, errs, ff.err(vm, r)
+	}
+	return n, capt, errs, nil
+}
+
+// ExecAt executes the parsing program like Exec, but starts matching at
+// position pos of the subject rather than at its beginning. Instructions that
+// test the previous byte, such as the empty-width assertions of regular
+// expressions, see the byte before pos.
+func (vm *Code) ExecAt(r io.ReaderAt, pos int, memtbl memo.Table) (bool, int, *memo.Capture, []ParseError, error) {
+	src := input.NewInput(r)
+	src.SeekTo(pos)
+	if vm.threads != nil {
+		return vm.threads.exec(src, memtbl, vm.maxDepth)
+	}
+
+	return vm.exec(0, newStack(vm.maxDepth), src, memtbl, nil, nil, nil)
 }
 
-func (vm *Code) ExecInterval(r io.ReaderAt, memtbl memo.Table, intrvl *Interval) (bool, int, *memo.Capture, []ParseError) {
+func (vm *Code) ExecInterval(r io.ReaderAt, memtbl memo.Table, intrvl *Interval) (bool, int, *memo.Capture, []ParseError, error) {
 	ip := 0
-	st := newStack()
+	st := newStack(vm.maxDepth)
 	src := input.NewInput(r)
 
-	return vm.exec(ip, st, src, memtbl, intrvl)
+	return vm.exec(ip, st, src, memtbl, intrvl, nil, nil)
 }
 
-func (vm *Code) exec(ip int, st *stack, src *input.Input, memtbl memo.Table, intrvl *Interval) (bool, int, *memo.Capture, []ParseError) {
+// exec runs the program starting at ip. If ff is not nil, the farthest
+// failure is recorded in it. If mon is not nil, the execution is reported to
+// it and stops when it exceeds its limits.
+func (vm *Code) exec(ip int, st *stack, src *input.Input, memtbl memo.Table, intrvl *Interval, ff *failTracker, mon *monitor) (bool, int, *memo.Capture, []ParseError, error) {
 	idata := vm.data.Insns
 
 	if ip < 0 || ip >= len(idata) {
-		return true, 0, memo.NewCaptureDummy(0, 0, nil), nil
+		return true, 0, memo.NewCaptureDummy(0, 0, nil), nil, nil
 	}
 
 	var caprange Interval
@@ -72,7 +126,19 @@ func (vm *Code) exec(ip int, st *stack, src *input.Input, memtbl memo.Table, int
 		})
 	}
 
+	// seeds for the calls to left-recursive rules that are in progress.
+	var lrtbl map[lrKey]*lrSeed
+	// number of left-recursive calls on the stack.
+	lrdepth := 0
+
+	newNode, newDummy := vm.captureFuncs()
+
 	memoize := func(id, pos, mlen, count int, capt []*memo.Capture) {
+		if lrdepth > 0 {
+			// results computed while a left-recursive rule is being
+			// grown depend on an intermediate seed and cannot be reused.
+			return
+		}
 		if intrvl != nil {
 			capt = nil
 		}
@@ -83,8 +149,22 @@ func (vm *Code) exec(ip int, st *stack, src *input.Input, memtbl memo.Table, int
 	success := true
 	var errs []ParseError = nil
 
+	// the label and recovery rule of a labeled failure being thrown.
+	var label, recovery int
+	// whether a failure has been reported to mon and not recovered from.
+	failing := false
+
 loop:
 	for {
+		if st.overflow {
+			return false, src.Pos(), nil, errs, mon.abort(ErrStackOverflow, st, src.Pos())
+		}
+		if mon != nil {
+			failing = false
+			if err := mon.step(vm, ip, st, src.Pos()); err != nil {
+				return false, src.Pos(), nil, errs, mon.abort(err, st, src.Pos())
+			}
+		}
 		op := idata[ip]
 		switch op {
 		case opChar:
@@ -94,7 +174,7 @@ loop:
 				src.Advance(1)
 				ip += szChar
 			} else {
-				goto fail
+				goto expect
 			}
 		case opJump:
 			lbl := decodeU24(idata[ip+1:])
@@ -102,21 +182,89 @@ loop:
 		case opChoice:
 			lbl := decodeU24(idata[ip+1:])
 			st.pushBacktrack(stackBacktrack{int(lbl), src.Pos()})
+			if ff != nil && vm.data.Preds[int(lbl)] {
+				ff.enterPred(st.peek())
+			}
 			ip += szChoice
 		case opCall:
 			lbl := decodeU24(idata[ip+1:])
-			st.pushRet(stackRet(ip + szCall))
+			st.pushRet(stackRet(ip+szCall), stackBacktrack{int(lbl), src.Pos()})
+			if ff != nil {
+				st.peek().memo.count = ff.mark(src.Pos())
+			}
+			if mon != nil {
+				mon.enter(vm, st, src.Pos())
+			}
 			ip = int(lbl)
+		case opLRCall:
+			lbl := decodeU24(idata[ip+1:])
+			key := lrKey{int(lbl), src.Pos()}
+			if seed, ok := lrtbl[key]; ok {
+				// recursive call: use the current seed
+				if seed.end == -1 {
+					goto fail
+				}
+				st.addCapt(seed.capt...)
+				src.SeekTo(seed.end)
+				ip += szLRCall
+			} else {
+				if lrtbl == nil {
+					lrtbl = make(map[lrKey]*lrSeed)
+				}
+				lrtbl[key] = &lrSeed{end: -1}
+				st.pushLR(stackRet(ip+szLRCall), stackBacktrack{int(lbl), src.Pos()})
+				if ff != nil {
+					st.peek().memo.count = ff.mark(src.Pos())
+				}
+				lrdepth++
+				if mon != nil {
+					mon.enter(vm, st, src.Pos())
+				}
+				ip = int(lbl)
+			}
 		case opCommit:
 			lbl := decodeU24(idata[ip+1:])
-			st.pop(true)
+			if st.pop(true) == nil {
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "Commit failed"}
+			}
 			ip = int(lbl)
 		case opReturn:
+			if ent := st.peek(); ent != nil && ent.stype == stLR {
+				key := lrKey{ent.btrack.ip, ent.btrack.off}
+				seed := lrtbl[key]
+				if src.Pos() > seed.end {
+					// the rule made progress: save the result as the new
+					// seed and grow it by entering the rule again.
+					seed.end = src.Pos()
+					seed.capt = ent.capt[:len(ent.capt):len(ent.capt)]
+					ent.capt = nil
+					src.SeekTo(ent.btrack.off)
+					ip = ent.btrack.ip
+				} else {
+					// no progress: the previous seed is the result.
+					if mon != nil {
+						mon.exit(vm, ip, st, ent, seed.end, true)
+					}
+					st.pop(false)
+					lrdepth--
+					delete(lrtbl, key)
+					st.addCapt(seed.capt...)
+					src.SeekTo(seed.end)
+					ip = int(ent.ret)
+				}
+				break
+			}
 			ent := st.pop(true)
 			if ent != nil && ent.stype == stRet {
+				if ff != nil && ent.btrack.off == src.Pos() {
+					ff.drop(ent.btrack.off, ent.memo.count)
+				}
+				if mon != nil {
+					mon.exit(vm, ip, st, ent, src.Pos(), true)
+				}
 				ip = int(ent.ret)
 			} else {
-				panic("Return failed")
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "Return failed"}
 			}
 		case opFail:
 			goto fail
@@ -127,7 +275,7 @@ loop:
 				src.Advance(1)
 				ip += szSet
 			} else {
-				goto fail
+				goto expect
 			}
 		case opAny:
 			n := decodeU8(idata[ip+1:])
@@ -135,6 +283,31 @@ loop:
 			if ok {
 				ip += szAny
 			} else {
+				goto expect
+			}
+		case opAnyRune:
+			_, n, ok := src.PeekRune()
+			if ok {
+				src.Advance(n)
+				ip += szAnyRune
+			} else {
+				goto expect
+			}
+		case opRuneSet:
+			set := &vm.data.RuneSets[decodeU24(idata[ip+1:])]
+			r, n, ok := src.PeekRune()
+			if ok && set.Has(r) {
+				src.Advance(n)
+				ip += szRuneSet
+			} else {
+				goto expect
+			}
+		case opLiterals:
+			t := &vm.data.Tries[decodeU24(idata[ip+1:])]
+			if t.match(src, ff, ip) {
+				ip += szLiterals
+			} else {
+				// the trie has recorded what it expected.
 				goto fail
 			}
 		case opPartialCommit:
@@ -146,7 +319,7 @@ loop:
 				ent.capt = nil
 				ip = int(lbl)
 			} else {
-				panic("PartialCommit failed")
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "PartialCommit failed"}
 			}
 		case opSpan:
 			set := decodeSet(idata[ip+1:], vm.data.Sets)
@@ -163,10 +336,13 @@ loop:
 				src.SeekTo(ent.btrack.off)
 				ip = int(lbl)
 			} else {
-				panic("BackCommit failed")
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "BackCommit failed"}
 			}
 		case opFailTwice:
-			st.pop(false)
+			ent := st.pop(false)
+			if ff != nil {
+				ff.exitPred(ent)
+			}
 			goto fail
 		case opEmpty:
 			op := syntax.EmptyOp(decodeU8(idata[ip+1:]))
@@ -184,7 +360,7 @@ loop:
 			if (sat & op) != 0 {
 				ip += szEmpty
 			} else {
-				goto fail
+				goto expect
 			}
 		case opTestChar:
 			b := decodeU8(idata[ip+2:])
@@ -192,9 +368,15 @@ loop:
 			in, ok := src.Peek()
 			if ok && in == b {
 				st.pushBacktrack(stackBacktrack{int(lbl), src.Pos()})
+				if ff != nil && vm.data.Preds[int(lbl)] {
+					ff.enterPred(st.peek())
+				}
 				src.Advance(1)
 				ip += szTestChar
 			} else {
+				if ff != nil && !vm.data.Preds[int(lbl)] {
+					ff.add(ip, src.Pos())
+				}
 				ip = int(lbl)
 			}
 		case opTestCharNoChoice:
@@ -205,6 +387,9 @@ loop:
 				ip += szTestCharNoChoice
 			} else {
 				lbl := decodeU24(idata[ip+3:])
+				if ff != nil && !vm.data.Preds[int(lbl)] {
+					ff.add(ip, src.Pos())
+				}
 				ip = int(lbl)
 			}
 		case opTestSet:
@@ -213,9 +398,15 @@ loop:
 			in, ok := src.Peek()
 			if ok && set.Has(in) {
 				st.pushBacktrack(stackBacktrack{int(lbl), src.Pos()})
+				if ff != nil && vm.data.Preds[int(lbl)] {
+					ff.enterPred(st.peek())
+				}
 				src.Advance(1)
 				ip += szTestSet
 			} else {
+				if ff != nil && !vm.data.Preds[int(lbl)] {
+					ff.add(ip, src.Pos())
+				}
 				ip = int(lbl)
 			}
 		case opTestSetNoChoice:
@@ -226,8 +417,22 @@ loop:
 				ip += szTestSetNoChoice
 			} else {
 				lbl := decodeU24(idata[ip+3:])
+				if ff != nil && !vm.data.Preds[int(lbl)] {
+					ff.add(ip, src.Pos())
+				}
 				ip = int(lbl)
 			}
+		case opPeekSet:
+			set := decodeSet(idata[ip+2:], vm.data.Sets)
+			if in, ok := src.Peek(); ok && set.Has(in) {
+				ip += szPeekSet
+			} else {
+				// the first alternative would have failed here.
+				if ff != nil {
+					ff.add(ip, src.Pos())
+				}
+				ip = int(decodeU24(idata[ip+3:]))
+			}
 		case opTestAny:
 			n := decodeU8(idata[ip+2:])
 			lbl := decodeU24(idata[ip+3:])
@@ -235,8 +440,14 @@ loop:
 			ok := src.Advance(int(n))
 			if ok {
 				st.pushBacktrack(ent)
+				if ff != nil && vm.data.Preds[int(lbl)] {
+					ff.enterPred(st.peek())
+				}
 				ip += szTestAny
 			} else {
+				if ff != nil && !vm.data.Preds[int(lbl)] {
+					ff.add(ip, src.Pos())
+				}
 				ip = int(lbl)
 			}
 		case opCaptureBegin:
@@ -271,17 +482,56 @@ loop:
 			ent := st.pop(false)
 
 			if ent == nil || ent.stype != stCapt {
-				panic("CaptureEnd did not find capture entry")
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "CaptureEnd did not find capture entry"}
 			}
 
 			end := src.Pos()
 			if overlaps(intrvl, ent.memo.pos, end) {
 				caprange.Low = min(caprange.Low, ent.memo.pos)
 				caprange.High = max(caprange.High, end)
-				capt := memo.NewCaptureNode(int(ent.memo.id), ent.memo.pos, end-ent.memo.pos, ent.capt)
+				capt := newNode(int(ent.memo.id), ent.memo.pos, end-ent.memo.pos, ent.capt)
 				st.addCapt(capt)
 			}
 			ip += szCaptureEnd
+		case opRepeatOpen:
+			n := decodeU24(idata[ip+1:])
+			st.pushRepeat(stackMemo{
+				count: int(n),
+				pos:   src.Pos(),
+			})
+			ip += szRepeatOpen
+		case opRepeatNext:
+			ent := st.peek()
+			if ent == nil || ent.stype != stRepeat {
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "RepeatNext did not find counter entry"}
+			}
+			ent.memo.count--
+			if ent.memo.count > 0 {
+				lbl := decodeU24(idata[ip+1:])
+				ip = int(lbl)
+			} else {
+				st.pop(true)
+				ip += szRepeatNext
+			}
+		case opRepeatClose:
+			ent := st.pop(true)
+			if ent == nil || ent.stype != stRepeat {
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "RepeatClose did not find counter entry"}
+			}
+			ip += szRepeatClose
+		case opCatch:
+			lbl := decodeU24(idata[ip+1:])
+			set := decodeU16(idata[ip+4:])
+			st.pushCatch(stackRet(set), stackBacktrack{int(lbl), src.Pos()})
+			ip += szCatch
+		case opThrow:
+			label = int(decodeU24(idata[ip+1:]))
+			recovery = -1
+			goto throw
+		case opThrowRecover:
+			label = int(decodeU16(idata[ip+4:]))
+			recovery = int(decodeU24(idata[ip+1:]))
+			goto throw
 		case opEnd:
 			fail := decodeU8(idata[ip+1:])
 			success = fail != 1
@@ -291,6 +541,9 @@ loop:
 			id := decodeI16(idata[ip+4:])
 
 			ment, ok := memtbl.Get(int(id), src.Pos())
+			if mon != nil {
+				mon.memo(ok)
+			}
 			if ok {
 				if ment.Length() == -1 {
 					goto fail
@@ -312,9 +565,11 @@ loop:
 			ent := st.pop(true)
 			if ent != nil && ent.stype == stMemo {
 				mlen := src.Pos() - ent.memo.pos
-				memoize(int(ent.memo.id), ent.memo.pos, mlen, 1, ent.capt)
+				if !ent.memo.external {
+					memoize(int(ent.memo.id), ent.memo.pos, mlen, 1, ent.capt)
+				}
 			} else {
-				panic("memo close failed")
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "memo close failed"}
 			}
 			ip += szMemoClose
 		case opMemoTreeOpen:
@@ -322,6 +577,9 @@ loop:
 			id := decodeI16(idata[ip+4:])
 
 			ment, ok := memtbl.Get(int(id), src.Pos())
+			if mon != nil {
+				mon.memo(ok)
+			}
 			if ok {
 				if ment.Length() == -1 {
 					goto fail
@@ -354,11 +612,13 @@ loop:
 		case opMemoTreeInsert:
 			ent := st.peek()
 			if ent == nil || ent.stype != stMemoTree {
-				panic("no memo entry on stack")
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "no memo entry on stack"}
 			}
 			mlen := src.Pos() - ent.memo.pos
 			ent.memo.count++
-			memoize(int(ent.memo.id), ent.memo.pos, mlen, ent.memo.count, ent.capt)
+			if !ent.memo.external {
+				memoize(int(ent.memo.id), ent.memo.pos, mlen, ent.memo.count, ent.capt)
+			}
 			ip += szMemoTreeInsert
 		case opMemoTree:
 			seen := 0
@@ -384,7 +644,7 @@ loop:
 				ent := st.pop(false) // next is now top of stack
 
 				if len(ent.capt) > 0 && intrvl == nil {
-					dummy := memo.NewCaptureDummy(ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt)
+					dummy := newDummy(ent.memo.pos, src.Pos()-ent.memo.pos, ent.capt)
 					st.addCapt(dummy)
 				} else if len(ent.capt) > 0 {
 					st.addCapt(ent.capt...)
@@ -392,7 +652,9 @@ loop:
 
 				next.memo.count = accum + next.memo.count
 				mlen := src.Pos() - next.memo.pos
-				memoize(int(next.memo.id), next.memo.pos, mlen, next.memo.count, next.capt)
+				if !next.memo.external {
+					memoize(int(next.memo.id), next.memo.pos, mlen, next.memo.count, next.capt)
+				}
 
 				accum = 0
 				seen = 0
@@ -409,16 +671,23 @@ loop:
 			})
 			ip += szCheckBegin
 		case opCheckEnd:
-			ent := st.pop(true)
-			if ent == nil || ent.stype != stCheck {
-				panic("check end needs check stack entry")
-			}
 			checkid := decodeU24(idata[ip+1:])
 			checker := vm.data.Checkers[checkid]
+			backref := isBackRef(checker)
+
+			ent := st.pop(!backref)
+			if ent == nil || ent.stype != stCheck {
+				return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "check end needs check stack entry"}
+			}
 
-			id := int(ent.memo.id)
-			flag := ent.memo.count
-			n := checker.Check(src.Slice(int(ent.memo.pos), src.Pos()), src, id, flag)
+			var n int
+			if backref {
+				n = st.backRef(ent, src)
+			} else {
+				id := int(ent.memo.id)
+				flag := ent.memo.count
+				n = checker.Check(src.Slice(int(ent.memo.pos), src.Pos()), src, id, flag)
+			}
 			if n == -1 {
 				goto fail
 			} else {
@@ -435,35 +704,132 @@ loop:
 			})
 			ip += szError
 		default:
-			panic("Invalid opcode")
+			return false, src.Pos(), nil, errs, &ExecError{Ip: ip, Message: "Invalid opcode"}
 		}
 	}
 
 	if intrvl != nil {
-		return success, src.Pos(), memo.NewCaptureDummy(caprange.Low, caprange.High-caprange.Low, st.capt), errs
+		return success, src.Pos(), memo.NewCaptureDummy(caprange.Low, caprange.High-caprange.Low, st.capt), errs, nil
 	}
-	return success, src.Pos(), memo.NewCaptureDummy(0, src.Pos(), st.capt), errs
+	return success, src.Pos(), memo.NewCaptureDummy(0, src.Pos(), st.capt), errs, nil
 
+throw:
+	// unwind to the innermost catch entry that handles the label
+	for i := len(st.entries) - 1; i >= 0; i-- {
+		if st.entries[i].stype != stCatch || !vm.catches(int(st.entries[i].ret), label) {
+			continue
+		}
+		for len(st.entries) > i+1 {
+			ent := st.pop(false)
+			switch ent.stype {
+			case stRet:
+				if mon != nil {
+					mon.exit(vm, ip, st, ent, src.Pos(), false)
+				}
+			case stLR:
+				lrdepth--
+				delete(lrtbl, lrKey{ent.btrack.ip, ent.btrack.off})
+				if mon != nil {
+					mon.exit(vm, ip, st, ent, src.Pos(), false)
+				}
+			case stBtrack:
+				if ff != nil {
+					ff.exitPred(ent)
+				}
+			}
+			ent.capt = nil
+		}
+		ent := st.pop(false)
+		ent.capt = nil
+		ip = ent.btrack.ip
+		src.SeekTo(ent.btrack.off)
+		goto loop
+	}
+
+	errs = append(errs, ParseError{
+		Pos:     src.Pos(),
+		Message: vm.data.Labels[label],
+	})
+	if recovery == -1 {
+		// nothing handles the label: the match fails
+		return false, src.Pos(), nil, errs, nil
+	}
+	// call the recovery rule in place of the failure
+	st.pushRet(stackRet(ip+szThrowRecover), stackBacktrack{recovery, src.Pos()})
+	if ff != nil {
+		st.peek().memo.count = ff.mark(src.Pos())
+	}
+	if mon != nil {
+		mon.enter(vm, st, src.Pos())
+	}
+	ip = recovery
+	goto loop
+
+expect:
+	// a terminal failed to match
+	if ff != nil {
+		ff.add(ip, src.Pos())
+	}
 fail:
+	if mon != nil && !failing {
+		failing = true
+		mon.fail(vm, ip, st, src.Pos())
+	}
 	ent := st.pop(false)
 	if ent == nil {
 		// match failed
-		return false, src.Pos(), nil, errs
+		return false, src.Pos(), nil, errs, nil
 	}
 
 	switch ent.stype {
 	case stBtrack:
+		if ff != nil {
+			ff.exitPred(ent)
+		}
 		ip = ent.btrack.ip
 		src.SeekTo(ent.btrack.off)
 		ent.capt = nil
 	case stMemo:
 		// Mark this position in the memoTable as a failed match
-		memoize(int(ent.memo.id), ent.memo.pos, -1, 0, nil)
+		if !ent.memo.external {
+			memoize(int(ent.memo.id), ent.memo.pos, -1, 0, nil)
+		}
+		ent.capt = nil
+		goto fail
+	case stRet:
+		if ff != nil {
+			ff.reduce(vm.data.Rules[ent.btrack.ip], ent.btrack.off, ent.memo.count)
+		}
+		if mon != nil {
+			mon.exit(vm, ip, st, ent, src.Pos(), false)
+		}
 		ent.capt = nil
 		goto fail
-	case stRet, stCapt, stCheck:
+	case stCapt, stCheck, stRepeat, stCatch:
 		ent.capt = nil
 		goto fail
+	case stLR:
+		lrdepth--
+		key := lrKey{ent.btrack.ip, ent.btrack.off}
+		seed := lrtbl[key]
+		delete(lrtbl, key)
+		ent.capt = nil
+		if seed.end == -1 {
+			if mon != nil {
+				mon.exit(vm, ip, st, ent, src.Pos(), false)
+			}
+			if ff != nil {
+				ff.reduce(vm.data.Rules[ent.btrack.ip], ent.btrack.off, ent.memo.count)
+			}
+			goto fail
+		}
+		// growing the seed failed: the previous seed is the result.
+		if mon != nil {
+			mon.exit(vm, ip, st, ent, seed.end, true)
+		}
+		st.addCapt(seed.capt...)
+		src.SeekTo(seed.end)
+		ip = int(ent.ret)
 	}
 
 	goto loop
@@ -504,6 +870,16 @@ func overlaps(i *Interval, low2, high2 int) bool {
 	return i.Low < high2 && i.High > low2
 }
 
+// Returns true if the set of labels at index set contains label.
+func (vm *Code) catches(set, label int) bool {
+	for _, l := range vm.data.Catches[set] {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a